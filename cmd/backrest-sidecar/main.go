@@ -2,18 +2,25 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/zettaio/backrest-sidecar/internal/app"
+	"github.com/zettaio/backrest-sidecar/internal/compose"
+	"github.com/zettaio/backrest-sidecar/internal/config"
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+	"github.com/zettaio/backrest-sidecar/internal/model"
 )
 
 var version = "dev"
@@ -49,7 +56,20 @@ type commonFlags struct {
 	planIDPrefix        string
 	includeProjectName  bool
 	excludeBindMounts   bool
+	planGranularity     string
 	restartTimeout      time.Duration
+	statePath           string
+	orphanPolicy        string
+	overridePolicy      string
+	orphanGrace         time.Duration
+	applyMode           string
+	backrestURL         string
+	backrestUsername    string
+	backrestPassword    string
+	backrestToken       string
+	verifyTimeout       time.Duration
+	healthURL           string
+	historyLimit        int
 	logFormat           string
 	logLevel            string
 }
@@ -72,6 +92,17 @@ func newRootCmd() *cobra.Command {
 		planIDPrefix:        envOr("BACKREST_PLAN_ID_PREFIX", "backrest_sidecar_"),
 		backrestContainer:   "backrest",
 		restartTimeout:      15 * time.Second,
+		planGranularity:     envOr("BACKREST_PLAN_GRANULARITY", string(app.GranularityContainer)),
+		orphanPolicy:        envOr("BACKREST_ORPHAN_POLICY", string(app.OrphanKeep)),
+		overridePolicy:      envOr("BACKREST_OVERRIDE_POLICY", string(app.OverrideLabelsWin)),
+		orphanGrace:         24 * time.Hour,
+		applyMode:           envOr("BACKREST_APPLY_MODE", string(app.ApplyRestart)),
+		backrestURL:         envOr("BACKREST_URL", "http://backrest:9898"),
+		backrestUsername:    os.Getenv("BACKREST_USERNAME"),
+		backrestPassword:    os.Getenv("BACKREST_PASSWORD"),
+		backrestToken:       os.Getenv("BACKREST_TOKEN"),
+		verifyTimeout:       60 * time.Second,
+		historyLimit:        20,
 		logFormat:           "json",
 		logLevel:            "info",
 	}
@@ -99,18 +130,16 @@ func newRootCmd() *cobra.Command {
 	}
 	bindReconcileFlags(reconcileCmd, &flags)
 
-	daemonInterval := 60 * time.Second
-	withEvents := false
+	daemonOpts := newDaemonCLIOptions()
 	daemonCmd := &cobra.Command{
 		Use:   "daemon",
 		Short: "Continuous reconcile loop, optionally listening to docker events",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runDaemon(cmd, flags, daemonInterval, withEvents)
+			return runDaemon(cmd, flags, daemonOpts)
 		},
 	}
 	bindReconcileFlags(daemonCmd, &flags)
-	daemonCmd.Flags().DurationVar(&daemonInterval, "interval", daemonInterval, "reconcile interval (e.g. 60s)")
-	daemonCmd.Flags().BoolVar(&withEvents, "with-events", false, "subscribe to docker events for faster updates")
+	bindDaemonFlags(daemonCmd, &daemonOpts)
 
 	backupOpts := newBackupCLIOptions()
 	backupCmd := &cobra.Command{
@@ -122,16 +151,110 @@ func newRootCmd() *cobra.Command {
 	}
 	bindBackupFlags(backupCmd, &backupOpts)
 
-	rootCmd.AddCommand(reconcileCmd, daemonCmd, backupCmd, newVersionCmd())
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "List recorded config snapshots",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runHistory(cmd, flags)
+		},
+	}
+	historyCmd.Flags().StringVar(&flags.configPath, "config", flags.configPath, "path to Backrest config file (defaults BACKREST_CONFIG)")
+
+	rollbackCmd := &cobra.Command{
+		Use:   "rollback <snapshot-id>",
+		Short: "Restore a recorded config snapshot (optionally applying it)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRollback(cmd, flags, args[0])
+		},
+	}
+	bindReconcileFlags(rollbackCmd, &flags)
+
+	diffOpts := diffCLIOptions{output: "text"}
+	diffCmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Show what reconcile would change in the Backrest config, plan by plan",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDiff(cmd, flags, diffOpts)
+		},
+	}
+	bindReconcileFlags(diffCmd, &flags)
+	diffCmd.Flags().StringVar(&diffOpts.output, "output", diffOpts.output, "output format (text|json)")
+	diffCmd.Flags().BoolVar(&diffOpts.unified, "unified", diffOpts.unified, "print a unified diff of each plan's JSON (text output)")
+	diffCmd.Flags().BoolVar(&diffOpts.exitCode, "exit-code", diffOpts.exitCode, "exit 4 when reconcile would change the config")
+
+	explainOutput := "text"
+	explainCmd := &cobra.Command{
+		Use:   "explain <name|id>",
+		Short: "Trace how a container's plan is derived from its labels, defaults and mounts",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runExplain(cmd, flags, args[0], explainOutput)
+		},
+	}
+	bindReconcileFlags(explainCmd, &flags)
+	explainCmd.Flags().StringVar(&explainOutput, "output", explainOutput, "output format (text|json)")
+
+	lintOutput := "text"
+	var lintCompose composeFlags
+	lintCmd := &cobra.Command{
+		Use:   "lint",
+		Short: "Check the backrest.* labels of every container (or compose service) for mistakes; exits 5 when it finds any",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runLint(cmd, flags, lintCompose, lintOutput)
+		},
+	}
+	bindReconcileFlags(lintCmd, &flags)
+	bindComposeFlags(lintCmd, &lintCompose)
+	lintCmd.Flags().StringVar(&lintOutput, "output", lintOutput, "output format (text|json)")
+
+	renderOutput := "plans"
+	var renderCompose composeFlags
+	renderCmd := &cobra.Command{
+		Use:   "render",
+		Short: "Render plans from compose files without a Docker daemon",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRender(cmd, flags, renderCompose, renderOutput)
+		},
+	}
+	bindBuilderFlags(renderCmd, &flags)
+	bindComposeFlags(renderCmd, &renderCompose)
+	_ = renderCmd.MarkFlagRequired("compose")
+	renderCmd.Flags().StringVar(&renderOutput, "output", renderOutput, "output (plans|config): the rendered plans, or config.json with them upserted as labels render them")
+
+	rootCmd.AddCommand(reconcileCmd, daemonCmd, backupCmd, historyCmd, rollbackCmd, diffCmd, explainCmd, lintCmd, renderCmd, newHealthcheckCmd(), newVersionCmd())
 	return rootCmd
 }
 
 func bindReconcileFlags(cmd *cobra.Command, flags *commonFlags) {
-	cmd.Flags().StringVar(&flags.configPath, "config", flags.configPath, "path to Backrest config file (defaults BACKREST_CONFIG)")
+	bindBuilderFlags(cmd, flags)
 	cmd.Flags().BoolVar(&flags.apply, "apply", flags.apply, "restart Backrest container when config changes")
 	cmd.Flags().StringVar(&flags.backrestContainer, "backrest-container", flags.backrestContainer, "container name/id for Backrest")
 	cmd.Flags().BoolVar(&flags.dryRun, "dry-run", flags.dryRun, "render plans but skip config write")
 	cmd.Flags().StringVar(&flags.dockerSocket, "docker-sock", flags.dockerSocket, "docker socket path or host (e.g. /var/run/docker.sock)")
+	cmd.Flags().DurationVar(&flags.restartTimeout, "restart-timeout", flags.restartTimeout, "Backrest restart timeout")
+	cmd.Flags().StringVar(&flags.statePath, "state-file", flags.statePath, "sidecar state file (defaults to <config>.sidecar-state.json)")
+	cmd.Flags().StringVar(&flags.orphanPolicy, "orphan-policy", flags.orphanPolicy, "what to do with sidecar-owned plans whose containers are gone (keep|disable|delete)")
+	cmd.Flags().StringVar(&flags.overridePolicy, "override-policy", flags.overridePolicy, "how edits made in Backrest to sidecar-owned plans fare against labels (labels-win|ui-wins|field-merge)")
+	cmd.Flags().DurationVar(&flags.orphanGrace, "orphan-grace", flags.orphanGrace, "how long a plan must stay orphaned before the orphan policy applies")
+	cmd.Flags().StringVar(&flags.applyMode, "apply-mode", flags.applyMode, "how --apply reaches Backrest (restart|api); api falls back to restart when unreachable")
+	cmd.Flags().StringVar(&flags.backrestURL, "backrest-url", flags.backrestURL, "Backrest base URL for --apply-mode=api (defaults BACKREST_URL)")
+	cmd.Flags().StringVar(&flags.backrestUsername, "backrest-username", flags.backrestUsername, "Backrest API username (defaults BACKREST_USERNAME)")
+	cmd.Flags().StringVar(&flags.backrestPassword, "backrest-password", flags.backrestPassword, "Backrest API password (prefer BACKREST_PASSWORD)")
+	cmd.Flags().StringVar(&flags.backrestToken, "backrest-token", flags.backrestToken, "Backrest API bearer token (prefer BACKREST_TOKEN)")
+	cmd.Flags().DurationVar(&flags.verifyTimeout, "verify-timeout", flags.verifyTimeout, "wait this long for Backrest to be healthy after a restart before rolling back (0 disables)")
+	cmd.Flags().StringVar(&flags.healthURL, "backrest-health-url", flags.healthURL, "optional HTTP endpoint probed after restart when Backrest has no Docker healthcheck")
+	cmd.Flags().IntVar(&flags.historyLimit, "history-limit", flags.historyLimit, "config snapshots kept in <config>.history (0 disables)")
+}
+
+// bindBuilderFlags binds the flags that shape rendered plans, which is all
+// render takes.
+func bindBuilderFlags(cmd *cobra.Command, flags *commonFlags) {
+	cmd.Flags().StringVar(&flags.configPath, "config", flags.configPath, "path to Backrest config file (defaults BACKREST_CONFIG)")
 	cmd.Flags().StringVar(&flags.dockerRoot, "docker-root", flags.dockerRoot, "host docker root for named volumes")
 	cmd.Flags().StringVar(&flags.defaultRepo, "default-repo", flags.defaultRepo, "fallback Backrest repo id")
 	cmd.Flags().StringVar(&flags.defaultSchedule, "default-schedule", flags.defaultSchedule, "fallback cron schedule")
@@ -139,38 +262,94 @@ func bindReconcileFlags(cmd *cobra.Command, flags *commonFlags) {
 	cmd.Flags().StringVar(&flags.planIDPrefix, "plan-id-prefix", flags.planIDPrefix, "prefix applied to rendered plan ids")
 	cmd.Flags().StringVar(&flags.volumePrefix, "volume-prefix", flags.volumePrefix, "rewrite derived volume paths to this prefix (e.g. /docker_volumes)")
 	cmd.Flags().BoolVar(&flags.excludeBindMounts, "exclude-bind-mounts", flags.excludeBindMounts, "derive backup paths only from named volumes")
+	cmd.Flags().StringVar(&flags.planGranularity, "plan-granularity", flags.planGranularity, "what one plan covers unless backrest.granularity says otherwise (container|volume|project)")
 	cmd.Flags().BoolVar(&flags.includeProjectName, "include-project-name", flags.includeProjectName, "prefix plan IDs with compose project")
-	cmd.Flags().DurationVar(&flags.restartTimeout, "restart-timeout", flags.restartTimeout, "Backrest restart timeout")
 }
 
-func runReconcile(cmd *cobra.Command, flags commonFlags) error {
-	logger, err := buildLogger(flags.logFormat, flags.logLevel)
+func builderOptions(flags commonFlags) (app.PlanBuilderOptions, error) {
+	planGranularity, err := app.ParseGranularity(flags.planGranularity)
 	if err != nil {
-		exitCode = 1
-		return err
-	}
-	defaultRepoProvided := flags.defaultRepoProvided
-	if cmd.Flags().Changed("default-repo") {
-		defaultRepoProvided = true
+		return app.PlanBuilderOptions{}, err
 	}
+	return app.PlanBuilderOptions{
+		DockerRoot:         flags.dockerRoot,
+		VolumePrefix:       flags.volumePrefix,
+		DefaultRepo:        flags.defaultRepo,
+		DefaultSchedule:    flags.defaultSchedule,
+		DefaultRetention:   flags.defaultRetention,
+		PlanIDPrefix:       flags.planIDPrefix,
+		IncludeProjectName: flags.includeProjectName,
+		ExcludeBindMounts:  flags.excludeBindMounts,
+		Granularity:        planGranularity,
+	}, nil
+}
 
-	opts := app.ReconcileOptions{
+func defaultRepoProvided(cmd *cobra.Command, flags commonFlags) bool {
+	return flags.defaultRepoProvided || cmd.Flags().Changed("default-repo")
+}
+
+func reconcileOptions(cmd *cobra.Command, flags commonFlags, logger *slog.Logger) (app.ReconcileOptions, error) {
+	builder, err := builderOptions(flags)
+	if err != nil {
+		return app.ReconcileOptions{}, err
+	}
+	orphanPolicy, err := app.ParseOrphanPolicy(flags.orphanPolicy)
+	if err != nil {
+		return app.ReconcileOptions{}, err
+	}
+	overridePolicy, err := app.ParseOverridePolicy(flags.overridePolicy)
+	if err != nil {
+		return app.ReconcileOptions{}, err
+	}
+	applyMode, err := app.ParseApplyMode(flags.applyMode)
+	if err != nil {
+		return app.ReconcileOptions{}, err
+	}
+	return app.ReconcileOptions{
 		ConfigPath:          flags.configPath,
 		Apply:               flags.apply,
 		BackrestContainer:   flags.backrestContainer,
 		DryRun:              flags.dryRun,
 		DockerSocket:        flags.dockerSocket,
-		DockerRoot:          flags.dockerRoot,
-		VolumePrefix:        flags.volumePrefix,
-		DefaultRepo:         flags.defaultRepo,
-		DefaultRepoProvided: defaultRepoProvided,
-		DefaultSchedule:     flags.defaultSchedule,
-		DefaultRetention:    flags.defaultRetention,
-		PlanIDPrefix:        flags.planIDPrefix,
-		IncludeProjectName:  flags.includeProjectName,
-		ExcludeBindMounts:   flags.excludeBindMounts,
+		DockerRoot:          builder.DockerRoot,
+		VolumePrefix:        builder.VolumePrefix,
+		DefaultRepo:         builder.DefaultRepo,
+		DefaultRepoProvided: defaultRepoProvided(cmd, flags),
+		DefaultSchedule:     builder.DefaultSchedule,
+		DefaultRetention:    builder.DefaultRetention,
+		PlanIDPrefix:        builder.PlanIDPrefix,
+		IncludeProjectName:  builder.IncludeProjectName,
+		ExcludeBindMounts:   builder.ExcludeBindMounts,
+		PlanGranularity:     builder.Granularity,
 		Logger:              logger,
 		RestartTimeout:      flags.restartTimeout,
+		StatePath:           flags.statePath,
+		OrphanPolicy:        orphanPolicy,
+		OverridePolicy:      overridePolicy,
+		OrphanGrace:         flags.orphanGrace,
+		ApplyMode:           applyMode,
+		BackrestAPI: app.BackrestAPIOptions{
+			URL:      flags.backrestURL,
+			Username: flags.backrestUsername,
+			Password: flags.backrestPassword,
+			Token:    flags.backrestToken,
+		},
+		VerifyTimeout: flags.verifyTimeout,
+		HealthURL:     flags.healthURL,
+		HistoryLimit:  flags.historyLimit,
+	}, nil
+}
+
+func runReconcile(cmd *cobra.Command, flags commonFlags) error {
+	logger, err := buildLogger(flags.logFormat, flags.logLevel)
+	if err != nil {
+		exitCode = 1
+		return err
+	}
+	opts, err := reconcileOptions(cmd, flags, logger)
+	if err != nil {
+		exitCode = 1
+		return err
 	}
 
 	reconciler, err := app.NewReconciler(opts)
@@ -193,37 +372,78 @@ func runReconcile(cmd *cobra.Command, flags commonFlags) error {
 	return nil
 }
 
-func runDaemon(cmd *cobra.Command, flags commonFlags, interval time.Duration, withEvents bool) error {
+type daemonCLIOptions struct {
+	interval         time.Duration
+	withEvents       bool
+	applyQuietWindow time.Duration
+	applyMaxDelay    time.Duration
+	applyWaitIdle    bool
+	watchConfig      bool
+	eventActions     string
+	eventDebounce    time.Duration
+	metricsAddr      string
+	healthAddr       string
+	healthMaxAge     time.Duration
+	apiAddr          string
+	apiToken         string
+}
+
+func newDaemonCLIOptions() daemonCLIOptions {
+	return daemonCLIOptions{
+		interval:         60 * time.Second,
+		applyQuietWindow: 10 * time.Second,
+		applyMaxDelay:    15 * time.Minute,
+		applyWaitIdle:    true,
+		watchConfig:      true,
+		eventActions:     strings.Join(app.DefaultEventActions, ","),
+		eventDebounce:    2 * time.Second,
+		healthAddr:       defaultHealthAddr,
+		apiToken:         os.Getenv("BACKREST_SIDECAR_API_TOKEN"),
+	}
+}
+
+func bindDaemonFlags(cmd *cobra.Command, opts *daemonCLIOptions) {
+	cmd.Flags().DurationVar(&opts.interval, "interval", opts.interval, "reconcile interval (e.g. 60s)")
+	cmd.Flags().BoolVar(&opts.withEvents, "with-events", opts.withEvents, "subscribe to docker events for faster updates")
+	cmd.Flags().DurationVar(&opts.applyQuietWindow, "apply-quiet-window", opts.applyQuietWindow, "batch config changes until none were written for this long before applying (0 applies every pass)")
+	cmd.Flags().DurationVar(&opts.applyMaxDelay, "apply-max-delay", opts.applyMaxDelay, "longest a pending apply may be postponed by the quiet window or running operations")
+	cmd.Flags().BoolVar(&opts.applyWaitIdle, "apply-wait-idle", opts.applyWaitIdle, "postpone applying while the Backrest API reports running operations")
+	cmd.Flags().BoolVar(&opts.watchConfig, "watch-config", opts.watchConfig, "reconcile as soon as config.json is edited outside the sidecar (inotify)")
+	cmd.Flags().StringVar(&opts.eventActions, "event-actions", opts.eventActions, "comma-separated container events that trigger a reconcile with --with-events (e.g. add start,die)")
+	cmd.Flags().StringVar(&opts.metricsAddr, "metrics-addr", opts.metricsAddr, "serve Prometheus metrics at /metrics on this address (e.g. :9464)")
+	cmd.Flags().StringVar(&opts.healthAddr, "health-addr", opts.healthAddr, "serve /healthz and /readyz on this address (empty disables)")
+	cmd.Flags().DurationVar(&opts.healthMaxAge, "health-max-age", opts.healthMaxAge, "fail /healthz when no reconcile pass finished for this long (0 derives it from --interval)")
+	cmd.Flags().StringVar(&opts.apiAddr, "api-addr", opts.apiAddr, "serve the JSON API (/api/v1/containers, /api/v1/status, POST /api/v1/reconcile) on this address")
+	cmd.Flags().StringVar(&opts.apiToken, "api-token", opts.apiToken, "bearer token required by POST /api/v1/reconcile (prefer BACKREST_SIDECAR_API_TOKEN)")
+	cmd.Flags().DurationVar(&opts.eventDebounce, "event-debounce", opts.eventDebounce, "wait until no relevant docker event arrived for this long before reconciling (0 reconciles on every event)")
+}
+
+func runDaemon(cmd *cobra.Command, flags commonFlags, daemonOpts daemonCLIOptions) error {
 	logger, err := buildLogger(flags.logFormat, flags.logLevel)
 	if err != nil {
 		exitCode = 1
 		return err
 	}
-	defaultRepoProvided := flags.defaultRepoProvided
-	if cmd.Flags().Changed("default-repo") {
-		defaultRepoProvided = true
+	reconcileOpts, err := reconcileOptions(cmd, flags, logger)
+	if err != nil {
+		exitCode = 1
+		return err
 	}
 	opts := app.DaemonOptions{
-		ReconcileOptions: app.ReconcileOptions{
-			ConfigPath:          flags.configPath,
-			Apply:               flags.apply,
-			BackrestContainer:   flags.backrestContainer,
-			DryRun:              flags.dryRun,
-			DockerSocket:        flags.dockerSocket,
-			DockerRoot:          flags.dockerRoot,
-			VolumePrefix:        flags.volumePrefix,
-			DefaultRepo:         flags.defaultRepo,
-			DefaultRepoProvided: defaultRepoProvided,
-			DefaultSchedule:     flags.defaultSchedule,
-			DefaultRetention:    flags.defaultRetention,
-			PlanIDPrefix:        flags.planIDPrefix,
-			IncludeProjectName:  flags.includeProjectName,
-			ExcludeBindMounts:   flags.excludeBindMounts,
-			Logger:              logger,
-			RestartTimeout:      flags.restartTimeout,
-		},
-		Interval:   interval,
-		WithEvents: withEvents,
+		ReconcileOptions: reconcileOpts,
+		Interval:         daemonOpts.interval,
+		WithEvents:       daemonOpts.withEvents,
+		ApplyQuietWindow: daemonOpts.applyQuietWindow,
+		ApplyMaxDelay:    daemonOpts.applyMaxDelay,
+		ApplyWaitIdle:    daemonOpts.applyWaitIdle,
+		WatchConfig:      daemonOpts.watchConfig,
+		EventActions:     model.ParseCSV(daemonOpts.eventActions),
+		EventDebounce:    daemonOpts.eventDebounce,
+		MetricsAddr:      daemonOpts.metricsAddr,
+		HealthAddr:       daemonOpts.healthAddr,
+		HealthMaxAge:     daemonOpts.healthMaxAge,
+		APIAddr:          daemonOpts.apiAddr,
+		APIToken:         daemonOpts.apiToken,
 	}
 	if err := app.RunDaemon(cmd.Context(), opts); err != nil {
 		if errors.Is(err, context.Canceled) {
@@ -236,6 +456,365 @@ func runDaemon(cmd *cobra.Command, flags commonFlags, interval time.Duration, wi
 	return nil
 }
 
+func runHistory(cmd *cobra.Command, flags commonFlags) error {
+	snaps, err := config.NewHistory(flags.configPath, 0).List()
+	if err != nil {
+		exitCode = 1
+		return err
+	}
+	out := cmd.OutOrStdout()
+	if len(snaps) == 0 {
+		fmt.Fprintf(out, "no snapshots in %s\n", config.HistoryDir(flags.configPath))
+		return nil
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tNOTE\tCHANGED PLANS")
+	for _, snap := range snaps {
+		changed := strings.Join(snap.Changed, ",")
+		if changed == "" {
+			changed = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", snap.ID, snap.Time.Local().Format(time.RFC3339), snap.Note, changed)
+	}
+	return tw.Flush()
+}
+
+func runRollback(cmd *cobra.Command, flags commonFlags, id string) error {
+	logger, err := buildLogger(flags.logFormat, flags.logLevel)
+	if err != nil {
+		exitCode = 1
+		return err
+	}
+	opts, err := reconcileOptions(cmd, flags, logger)
+	if err != nil {
+		exitCode = 1
+		return err
+	}
+	reconciler, err := app.NewReconciler(opts)
+	if err != nil {
+		exitCode = 3
+		return err
+	}
+	defer reconciler.Close()
+
+	snap, err := reconciler.Rollback(cmd.Context(), id)
+	if err != nil {
+		logger.Error("rollback.failed", slog.String("error", err.Error()))
+		exitCode = 3
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "restored %s (%s)\n", snap.ID, snap.Time.Local().Format(time.RFC3339))
+	return nil
+}
+
+type diffCLIOptions struct {
+	output   string
+	unified  bool
+	exitCode bool
+}
+
+func runDiff(cmd *cobra.Command, flags commonFlags, opts diffCLIOptions) error {
+	if opts.output != "text" && opts.output != "json" {
+		exitCode = 1
+		return fmt.Errorf("invalid output %q (want text|json)", opts.output)
+	}
+	logger, err := buildLoggerTo(cmd.ErrOrStderr(), flags.logFormat, flags.logLevel)
+	if err != nil {
+		exitCode = 1
+		return err
+	}
+	reconcileOpts, err := reconcileOptions(cmd, flags, logger)
+	if err != nil {
+		exitCode = 1
+		return err
+	}
+	reconciler, err := app.NewReconciler(reconcileOpts)
+	if err != nil {
+		exitCode = 3
+		return err
+	}
+	defer reconciler.Close()
+
+	diff, err := reconciler.Diff(cmd.Context())
+	if err != nil {
+		logger.Error("diff.failed", slog.String("error", err.Error()))
+		exitCode = 3
+		return err
+	}
+	out := cmd.OutOrStdout()
+	if opts.output == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diff); err != nil {
+			exitCode = 3
+			return err
+		}
+	} else if err := printDiff(out, diff, opts.unified); err != nil {
+		exitCode = 3
+		return err
+	}
+	if opts.exitCode && diff.Changed {
+		exitCode = 4
+	}
+	return nil
+}
+
+func printDiff(out io.Writer, diff *app.ConfigDiff, unified bool) error {
+	if !diff.Changed {
+		fmt.Fprintln(out, "no changes")
+	}
+	marks := map[string]string{app.PlanAdded: "+", app.PlanChanged: "~", app.PlanOrphaned: "-"}
+	for _, change := range diff.Plans {
+		line := fmt.Sprintf("%s %s (%s", marks[change.Status], change.PlanID, change.Status)
+		if len(change.Fields) > 0 {
+			line += ": " + strings.Join(change.Fields, ", ")
+		}
+		fmt.Fprintln(out, line+")")
+		if !unified {
+			continue
+		}
+		text, err := change.Unified()
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(out, text)
+	}
+	for _, skipped := range diff.Skipped {
+		fmt.Fprintf(out, "! %s skipped: %s\n", skipped.Name, skipped.Skipped)
+	}
+	return nil
+}
+
+func runExplain(cmd *cobra.Command, flags commonFlags, ref, output string) error {
+	if output != "text" && output != "json" {
+		exitCode = 1
+		return fmt.Errorf("invalid output %q (want text|json)", output)
+	}
+	logger, err := buildLoggerTo(cmd.ErrOrStderr(), flags.logFormat, flags.logLevel)
+	if err != nil {
+		exitCode = 1
+		return err
+	}
+	reconcileOpts, err := reconcileOptions(cmd, flags, logger)
+	if err != nil {
+		exitCode = 1
+		return err
+	}
+	reconciler, err := app.NewReconciler(reconcileOpts)
+	if err != nil {
+		exitCode = 3
+		return err
+	}
+	defer reconciler.Close()
+
+	explanations, err := reconciler.Explain(cmd.Context(), ref)
+	if err != nil {
+		exitCode = 3
+		return err
+	}
+	out := cmd.OutOrStdout()
+	if output == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(explanations)
+	}
+	for i, explanation := range explanations {
+		if i == 0 {
+			id := explanation.ID
+			if len(id) > 12 {
+				id = id[:12]
+			}
+			fmt.Fprintf(out, "container %s (%s)\n", explanation.Name, id)
+		}
+		if len(explanations) > 1 {
+			fmt.Fprintf(out, "plan %s\n", explanation.PlanID)
+		}
+		for _, step := range explanation.Steps {
+			fmt.Fprintf(out, "  %-9s %s\n", step.Stage, step.Detail)
+		}
+		if explanation.Plan != nil {
+			data, err := json.MarshalIndent(explanation.Plan, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "plan:\n%s\n", data)
+		}
+	}
+	return nil
+}
+
+type composeFlags struct {
+	files   []string
+	project string
+}
+
+func bindComposeFlags(cmd *cobra.Command, flags *composeFlags) {
+	cmd.Flags().StringArrayVar(&flags.files, "compose", nil, "compose file to read instead of Docker (repeatable; later files override earlier ones)")
+	cmd.Flags().StringVar(&flags.project, "project", "", "compose project name (defaults to the name: key, COMPOSE_PROJECT_NAME, then the directory name)")
+}
+
+func (f composeFlags) containers() ([]docker.Container, error) {
+	project, err := compose.Load(compose.Options{Files: f.files, Project: f.project})
+	if err != nil {
+		return nil, err
+	}
+	return project.Containers(), nil
+}
+
+func runLint(cmd *cobra.Command, flags commonFlags, composeOpts composeFlags, output string) error {
+	if output != "text" && output != "json" {
+		exitCode = 1
+		return fmt.Errorf("invalid output %q (want text|json)", output)
+	}
+	logger, err := buildLoggerTo(cmd.ErrOrStderr(), flags.logFormat, flags.logLevel)
+	if err != nil {
+		exitCode = 1
+		return err
+	}
+	reconcileOpts, err := reconcileOptions(cmd, flags, logger)
+	if err != nil {
+		exitCode = 1
+		return err
+	}
+	reconciler, err := app.NewReconciler(reconcileOpts)
+	if err != nil {
+		exitCode = 3
+		return err
+	}
+	defer reconciler.Close()
+
+	var report *app.LintReport
+	if len(composeOpts.files) > 0 {
+		containers, cerr := composeOpts.containers()
+		if cerr != nil {
+			exitCode = 1
+			return cerr
+		}
+		report, err = reconciler.LintContainers(containers)
+	} else {
+		report, err = reconciler.Lint(cmd.Context())
+	}
+	if err != nil {
+		exitCode = 3
+		return err
+	}
+	if err := printLint(cmd.OutOrStdout(), report, output); err != nil {
+		exitCode = 3
+		return err
+	}
+	if len(report.Findings) > 0 {
+		exitCode = 5
+	}
+	return nil
+}
+
+func runRender(cmd *cobra.Command, flags commonFlags, composeOpts composeFlags, output string) error {
+	if output != "plans" && output != "config" {
+		exitCode = 1
+		return fmt.Errorf("invalid output %q (want plans|config)", output)
+	}
+	logger, err := buildLoggerTo(cmd.ErrOrStderr(), flags.logFormat, flags.logLevel)
+	if err != nil {
+		exitCode = 1
+		return err
+	}
+	containers, err := composeOpts.containers()
+	if err != nil {
+		exitCode = 1
+		return err
+	}
+	builder, err := builderOptions(flags)
+	if err != nil {
+		exitCode = 1
+		return err
+	}
+	result, err := app.Render(app.RenderOptions{
+		ConfigPath:          flags.configPath,
+		Builder:             builder,
+		DefaultRepoProvided: defaultRepoProvided(cmd, flags),
+		Logger:              logger,
+	}, containers)
+	if err != nil {
+		exitCode = 3
+		return err
+	}
+	for _, skipped := range result.Skipped {
+		logger.Warn("plan skipped", slog.String("container", skipped.Name), slog.String("error", skipped.Skipped))
+	}
+	for _, c := range result.Conflicts {
+		logger.Warn("project.conflict", slog.String("project", c.Project), slog.String("plan_id", c.PlanID), slog.String("label", c.Label), slog.String("value", c.Value), slog.String("from", c.From), slog.Any("ignored", c.Ignored))
+	}
+	out := cmd.OutOrStdout()
+	if output == "config" {
+		data, err := config.Marshal(result.Config)
+		if err != nil {
+			exitCode = 3
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", data)
+		return err
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
+
+func printLint(out io.Writer, report *app.LintReport, output string) error {
+	if output == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	last := ""
+	for _, finding := range report.Findings {
+		if finding.Container != last {
+			fmt.Fprintln(out, finding.Container)
+			last = finding.Container
+		}
+		if finding.Label != "" {
+			fmt.Fprintf(out, "  %s: %s\n", finding.Label, finding.Message)
+		} else {
+			fmt.Fprintf(out, "  %s\n", finding.Message)
+		}
+	}
+	fmt.Fprintf(out, "%d finding(s) across %d labelled container(s)\n", len(report.Findings), report.Containers)
+	return nil
+}
+
+// defaultHealthAddr is where the daemon serves /healthz and /readyz unless
+// --health-addr says otherwise; healthcheck probes it by default.
+const defaultHealthAddr = "127.0.0.1:9465"
+
+func newHealthcheckCmd() *cobra.Command {
+	addr := defaultHealthAddr
+	var ready bool
+	timeout := 5 * time.Second
+	cmd := &cobra.Command{
+		Use:   "healthcheck",
+		Short: "Probe a running daemon's health endpoint (for Docker HEALTHCHECK)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := "/healthz"
+			if ready {
+				path = "/readyz"
+			}
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+			defer cancel()
+			if err := app.Probe(ctx, addr, path); err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "unhealthy: %v\n", err)
+				exitCode = 1
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "ok")
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", addr, "daemon --health-addr to probe")
+	cmd.Flags().BoolVar(&ready, "ready", ready, "probe /readyz instead of /healthz")
+	cmd.Flags().DurationVar(&timeout, "timeout", timeout, "give up after this long")
+	return cmd
+}
+
 func newVersionCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "version",
@@ -247,6 +826,11 @@ func newVersionCmd() *cobra.Command {
 }
 
 func buildLogger(format, level string) (*slog.Logger, error) {
+	return buildLoggerTo(os.Stdout, format, level)
+}
+
+// buildLoggerTo is buildLogger for commands whose stdout is their report.
+func buildLoggerTo(w io.Writer, format, level string) (*slog.Logger, error) {
 	var slogLevel slog.Level
 	switch strings.ToLower(level) {
 	case "debug":
@@ -265,9 +849,9 @@ func buildLogger(format, level string) (*slog.Logger, error) {
 	var handler slog.Handler
 	switch strings.ToLower(format) {
 	case "json":
-		handler = slog.NewJSONHandler(os.Stdout, opts)
+		handler = slog.NewJSONHandler(w, opts)
 	case "text":
-		handler = slog.NewTextHandler(os.Stdout, opts)
+		handler = slog.NewTextHandler(w, opts)
 	default:
 		return nil, fmt.Errorf("invalid log format %s", format)
 	}
@@ -297,6 +881,7 @@ type backupCLIOptions struct {
 	quiesceTimeout   time.Duration
 	resticGroupBy    string
 	resticPathPrefix string
+	metricsFile      string
 }
 
 func newBackupCLIOptions() backupCLIOptions {
@@ -319,6 +904,7 @@ func bindBackupFlags(cmd *cobra.Command, opts *backupCLIOptions) {
 	cmd.Flags().DurationVar(&opts.quiesceTimeout, "quiesce-timeout", opts.quiesceTimeout, "quiesce stop timeout")
 	cmd.Flags().StringVar(&opts.resticGroupBy, "restic-group-by", opts.resticGroupBy, "restic --group-by value for retention")
 	cmd.Flags().StringVar(&opts.resticPathPrefix, "restic-path-prefix", opts.resticPathPrefix, "base path prefix used in restic forget")
+	cmd.Flags().StringVar(&opts.metricsFile, "metrics-file", opts.metricsFile, "write the run's outcome as Prometheus metrics to this file (node_exporter textfile collector)")
 }
 
 func runBackup(cmd *cobra.Command, flags *commonFlags, opts backupCLIOptions) error {
@@ -341,6 +927,7 @@ func runBackup(cmd *cobra.Command, flags *commonFlags, opts backupCLIOptions) er
 		QuiesceTimeout:     opts.quiesceTimeout,
 		ResticGroupBy:      opts.resticGroupBy,
 		ResticPathPrefix:   opts.resticPathPrefix,
+		MetricsFile:        opts.metricsFile,
 	}
 	if err := app.RunBackup(cmd.Context(), backupOpts); err != nil {
 		logger.Error("backup-once.failed", slog.String("error", err.Error()))
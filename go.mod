@@ -7,6 +7,7 @@ toolchain go1.23.12
 require (
 	github.com/docker/docker v25.0.3+incompatible
 	github.com/spf13/cobra v1.8.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
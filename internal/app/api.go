@@ -0,0 +1,149 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+)
+
+// ContainerRender is what the last pass made of one discovered container:
+// the plan it rendered to, or why it was skipped. A container with named
+// plans or volume granularity has one ContainerRender per plan.
+type ContainerRender struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Project string `json:"project,omitempty"`
+	Service string `json:"service,omitempty"`
+	State   string `json:"state,omitempty"`
+	PlanID  string `json:"plan_id,omitempty"`
+	// PlanName is set for named plans (backrest.plan.<name>.* labels).
+	PlanName string `json:"plan_name,omitempty"`
+	// Volume is set in volume granularity: the volume or mount the plan covers.
+	Volume  string      `json:"volume,omitempty"`
+	Plan    *model.Plan `json:"plan,omitempty"`
+	Skipped string      `json:"skipped,omitempty"`
+}
+
+func newContainerRender(ctr docker.Container) ContainerRender {
+	return ContainerRender{ID: ctr.ID, Name: ctr.Name, Project: ctr.Project, Service: ctr.Service, State: ctr.State}
+}
+
+// forPlan is c for one of the container's plans.
+func (c ContainerRender) forPlan(built BuiltPlan) ContainerRender {
+	c.PlanID, c.PlanName, c.Volume = built.ID, built.Name, built.Volume
+	return c
+}
+
+func (c ContainerRender) skip(reason string) ContainerRender {
+	c.Skipped = reason
+	return c
+}
+
+// renderLog keeps the last pass's renders for the API, which reads them from
+// the HTTP goroutines.
+type renderLog struct {
+	mu      sync.Mutex
+	renders []ContainerRender
+}
+
+func (l *renderLog) set(renders []ContainerRender) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.renders = renders
+}
+
+func (l *renderLog) get() []ContainerRender {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.renders
+}
+
+// apiServer is the daemon's optional JSON API. Reads are open; the
+// reconcile trigger needs the bearer token.
+type apiServer struct {
+	renders *renderLog
+	status  *passStatus
+	token   string
+	trigger func()
+}
+
+// statusReport is the body of GET /api/v1/status.
+type statusReport struct {
+	LastReconcile *time.Time       `json:"last_reconcile,omitempty"`
+	Error         string           `json:"error,omitempty"`
+	Passes        int              `json:"passes"`
+	Result        *ReconcileResult `json:"result,omitempty"`
+}
+
+func (a *apiServer) register(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/containers", a.containers)
+	mux.HandleFunc("/api/v1/status", a.lastStatus)
+	mux.HandleFunc("/api/v1/reconcile", a.reconcile)
+}
+
+func (a *apiServer) containers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	renders := a.renders.get()
+	if renders == nil {
+		renders = []ContainerRender{}
+	}
+	writeAPI(w, http.StatusOK, renders)
+}
+
+func (a *apiServer) lastStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	_, lastPass, lastErr, passes := a.status.get()
+	report := statusReport{Passes: passes, Result: a.status.result()}
+	if !lastPass.IsZero() {
+		report.LastReconcile = &lastPass
+	}
+	if lastErr != nil {
+		report.Error = lastErr.Error()
+	}
+	writeAPI(w, http.StatusOK, report)
+}
+
+// reconcile queues a full pass, bypassing the unchanged-pass memo, through
+// the daemon's trigger; it returns before the pass runs.
+func (a *apiServer) reconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	if a.token == "" {
+		writeAPIError(w, http.StatusForbidden, "reconcile trigger disabled; set --api-token")
+		return
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(a.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+		return
+	}
+	a.trigger()
+	writeAPI(w, http.StatusAccepted, map[string]string{"status": "queued"})
+}
+
+func writeAPI(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, code int, msg string) {
+	writeAPI(w, code, map[string]string{"error": msg})
+}
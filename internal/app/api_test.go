@@ -0,0 +1,158 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker/dockertest"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+)
+
+func TestAPIListsRendersWithSkipReasons(t *testing.T) {
+	broken := testAppContainer("broken")
+	broken.Labels[model.LabelRepo] = "repo-missing"
+	env := newTestEnv(t, nil, testAppContainer("app"), broken)
+	if _, err := env.r.Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	srv := testAPIServer(t, &apiServer{renders: &env.r.renders, status: newPassStatus(time.Now())})
+
+	var renders []ContainerRender
+	getAPI(t, srv.URL+"/api/v1/containers", &renders)
+	byName := map[string]ContainerRender{}
+	for _, render := range renders {
+		byName[render.Name] = render
+	}
+	if got := byName["app"]; got.Plan == nil || got.Plan.ID != "backrest_sidecar_app" || got.Skipped != "" {
+		t.Fatalf("expected app to render its plan, got %+v", got)
+	}
+	if got := byName["broken"]; got.Plan != nil || got.Skipped != `repo "repo-missing" missing from config` {
+		t.Fatalf("expected broken to carry its skip reason, got %+v", got)
+	}
+}
+
+func TestAPIReportsTheLastPass(t *testing.T) {
+	status := newPassStatus(time.Now())
+	status.record(time.Now(), &ReconcileResult{Changed: true}, nil)
+	srv := testAPIServer(t, &apiServer{renders: &renderLog{}, status: status})
+
+	var report statusReport
+	getAPI(t, srv.URL+"/api/v1/status", &report)
+	if report.Passes != 1 || report.Result == nil || !report.Result.Changed || report.LastReconcile == nil {
+		t.Fatalf("unexpected status %+v", report)
+	}
+}
+
+func TestAPIGuardsTheReconcileTrigger(t *testing.T) {
+	cases := []struct {
+		name      string
+		token     string
+		bearer    string
+		code      int
+		triggered int
+	}{
+		{"no bearer", "s3cret", "", http.StatusUnauthorized, 0},
+		{"wrong bearer", "s3cret", "wrong", http.StatusUnauthorized, 0},
+		{"right bearer", "s3cret", "s3cret", http.StatusAccepted, 1},
+		{"no token configured", "", "s3cret", http.StatusForbidden, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			triggered := 0
+			srv := testAPIServer(t, &apiServer{renders: &renderLog{}, status: newPassStatus(time.Now()), token: tc.token, trigger: func() { triggered++ }})
+			req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/v1/reconcile", nil)
+			if tc.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.bearer)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("post reconcile: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.code || triggered != tc.triggered {
+				t.Fatalf("expected code=%d triggered=%d, got code=%d triggered=%d", tc.code, tc.triggered, resp.StatusCode, triggered)
+			}
+		})
+	}
+}
+
+func TestRunDaemonForcesAFullPassOnAPIReconcile(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	engine.Add(testAppContainer("app"))
+	path := writeTestConfig(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	opts := testEngineOptions(engine, path)
+	opts.Apply = false
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- RunDaemon(ctx, DaemonOptions{ReconcileOptions: opts, Interval: time.Hour, APIAddr: addr, APIToken: "s3cret"})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitForStatus := func(passes int) statusReport {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			var report statusReport
+			if resp, err := http.Get("http://" + addr + "/api/v1/status"); err == nil {
+				err = json.NewDecoder(resp.Body).Decode(&report)
+				resp.Body.Close()
+				if err == nil && report.Passes >= passes {
+					return report
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("daemon never reached %d passes", passes)
+		return statusReport{}
+	}
+	waitForStatus(1)
+
+	req, _ := http.NewRequest(http.MethodPost, "http://"+addr+"/api/v1/reconcile", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post reconcile: %v", err)
+	}
+	resp.Body.Close()
+	// nothing changed, so only the forced pass avoids the memo
+	if report := waitForStatus(2); report.Result == nil || report.Result.Cached {
+		t.Fatalf("expected a full pass, got %+v", report.Result)
+	}
+}
+
+func testAPIServer(t *testing.T, api *apiServer) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	api.register(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func getAPI(t *testing.T, url string, v any) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("get %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("decode %s: %v", url, err)
+	}
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zettaio/backrest-sidecar/internal/backrest"
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+)
+
+// ApplyMode selects how a written config reaches a running Backrest.
+type ApplyMode string
+
+const (
+	// ApplyRestart restarts the Backrest container so it re-reads config.json.
+	ApplyRestart ApplyMode = "restart"
+	// ApplyAPI pushes the config through Backrest's SetConfig API and only
+	// restarts when the API cannot be reached.
+	ApplyAPI ApplyMode = "api"
+)
+
+// ParseApplyMode validates an apply mode name, defaulting to restart.
+func ParseApplyMode(raw string) (ApplyMode, error) {
+	switch ApplyMode(strings.ToLower(strings.TrimSpace(raw))) {
+	case "", ApplyRestart:
+		return ApplyRestart, nil
+	case ApplyAPI:
+		return ApplyAPI, nil
+	default:
+		return "", fmt.Errorf("invalid apply mode %q (want restart|api)", raw)
+	}
+}
+
+// BackrestAPIOptions configures access to Backrest's HTTP API.
+type BackrestAPIOptions struct {
+	URL      string
+	Username string
+	Password string
+	Token    string
+	Timeout  time.Duration
+}
+
+// containerControl is the slice of the Docker client used to apply configs
+// and verify Backrest afterwards.
+type containerControl interface {
+	RestartContainer(ctx context.Context, name string, timeout time.Duration) error
+	InspectState(ctx context.Context, name string) (docker.ContainerState, error)
+}
+
+// operationsProbe reports whether Backrest is busy running operations.
+type operationsProbe interface {
+	RunningOperations(ctx context.Context, lastN int) (int, error)
+}
+
+func newBackrestClient(opts BackrestAPIOptions) (*backrest.Client, error) {
+	return backrest.New(backrest.Options{
+		URL:      opts.URL,
+		Username: opts.Username,
+		Password: opts.Password,
+		Token:    opts.Token,
+		Timeout:  opts.Timeout,
+	})
+}
+
+// applyConfig makes Backrest pick up a freshly written config. It returns the
+// method that was used ("api" or "restart").
+func (r *Reconciler) applyConfig(ctx context.Context, data []byte) (ApplyMode, error) {
+	if r.opts.ApplyMode == ApplyAPI && r.backrest != nil {
+		err := r.hotApply(ctx, data)
+		if err == nil {
+			r.log.Info("backrest.hot_apply", slog.String("url", r.opts.BackrestAPI.URL))
+			mApplies.Inc(string(ApplyAPI))
+			return ApplyAPI, nil
+		}
+		if !errors.Is(err, backrest.ErrUnreachable) {
+			return "", err
+		}
+		r.log.Warn("backrest.api_unreachable", slog.String("url", r.opts.BackrestAPI.URL), slog.String("error", err.Error()), slog.String("fallback", string(ApplyRestart)))
+	}
+	if r.restarts.container == "" {
+		return "", errors.New("backrest container name required to restart")
+	}
+	if err := r.control.RestartContainer(ctx, r.restarts.container, r.restarts.timeout); err != nil {
+		return "", fmt.Errorf("restart backrest container: %w", err)
+	}
+	r.log.Info("backrest.restart", slog.String("container", r.restarts.container))
+	mApplies.Inc(string(ApplyRestart))
+	mRestarts.Inc("apply")
+	return ApplyRestart, nil
+}
+
+// hotApply pushes data through SetConfig. Backrest only accepts a config whose
+// modno matches its in-memory copy and bumps it itself, so the payload carries
+// Backrest's current modno rather than the one written to disk.
+func (r *Reconciler) hotApply(ctx context.Context, data []byte) error {
+	current, err := r.backrest.GetConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("backrest get config: %w", err)
+	}
+	var live struct {
+		Modno int64 `json:"modno"`
+	}
+	if err := json.Unmarshal(current, &live); err != nil {
+		return fmt.Errorf("decode backrest config: %w", err)
+	}
+	payload, err := withModno(data, live.Modno)
+	if err != nil {
+		return err
+	}
+	if err := r.backrest.SetConfig(ctx, payload); err != nil {
+		return fmt.Errorf("backrest set config: %w", err)
+	}
+	return nil
+}
+
+// withModno returns the config JSON with its top-level modno replaced.
+func withModno(data []byte, modno int64) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("parse config for api apply: %w", err)
+	}
+	fields["modno"] = json.RawMessage(strconv.FormatInt(modno, 10))
+	return json.Marshal(fields)
+}
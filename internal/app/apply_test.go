@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+)
+
+// fakeControl records restarts and replays scripted container states.
+type fakeControl struct {
+	restarts []string
+	states   []docker.ContainerState
+}
+
+func (f *fakeControl) RestartContainer(_ context.Context, name string, _ time.Duration) error {
+	f.restarts = append(f.restarts, name)
+	return nil
+}
+
+func (f *fakeControl) InspectState(context.Context, string) (docker.ContainerState, error) {
+	if len(f.states) == 0 {
+		return docker.ContainerState{Status: "running", Running: true, Health: "healthy"}, nil
+	}
+	st := f.states[0]
+	f.states = f.states[1:]
+	return st, nil
+}
+
+func TestApplyConfigHotAppliesThroughAPI(t *testing.T) {
+	var got []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1.Backrest/GetConfig":
+			_, _ = w.Write([]byte(`{"modno":7,"plans":[]}`))
+		case "/v1.Backrest/SetConfig":
+			got, _ = io.ReadAll(r.Body)
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	r, restarter := testApplyReconciler(t, srv.URL)
+	// the file on disk already carries the bumped modno; Backrest wants its own
+	mode, err := r.applyConfig(context.Background(), []byte(`{"modno":8,"plans":[]}`))
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if mode != ApplyAPI || len(restarter.restarts) != 0 {
+		t.Fatalf("expected hot apply without restart, got mode=%s restarts=%v", mode, restarter.restarts)
+	}
+	if string(got) != `{"modno":7,"plans":[]}` {
+		t.Fatalf("unexpected payload %s", got)
+	}
+}
+
+func TestApplyConfigFallsBackToRestartWhenUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	r, restarter := testApplyReconciler(t, url)
+	mode, err := r.applyConfig(context.Background(), []byte(`{}`))
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if mode != ApplyRestart || len(restarter.restarts) != 1 || restarter.restarts[0] != "backrest" {
+		t.Fatalf("expected restart fallback, got mode=%s restarts=%v", mode, restarter.restarts)
+	}
+}
+
+func TestApplyConfigDoesNotRestartOnRejectedConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1.Backrest/GetConfig" {
+			_, _ = w.Write([]byte(`{"modno":1}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"code":"invalid_argument","message":"modno mismatch"}`))
+	}))
+	defer srv.Close()
+
+	r, restarter := testApplyReconciler(t, srv.URL)
+	if _, err := r.applyConfig(context.Background(), []byte(`{}`)); err == nil {
+		t.Fatalf("expected rejected config to surface an error")
+	}
+	if len(restarter.restarts) != 0 {
+		t.Fatalf("expected no restart for a rejected config, got %v", restarter.restarts)
+	}
+}
+
+func testApplyReconciler(t *testing.T, url string) (*Reconciler, *fakeControl) {
+	t.Helper()
+	api, err := newBackrestClient(BackrestAPIOptions{URL: url, Token: "token"})
+	if err != nil {
+		t.Fatalf("backrest client: %v", err)
+	}
+	restarter := &fakeControl{}
+	r := &Reconciler{
+		opts:     ReconcileOptions{ApplyMode: ApplyAPI, BackrestAPI: BackrestAPIOptions{URL: url}},
+		control:  restarter,
+		backrest: api,
+		log:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	r.restarts.container = "backrest"
+	return r, restarter
+}
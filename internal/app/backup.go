@@ -33,6 +33,10 @@ type BackupOptions struct {
 
 	ResticGroupBy    string
 	ResticPathPrefix string
+
+	// MetricsFile, when set, receives the run's outcome in Prometheus text
+	// format (for node_exporter's textfile collector).
+	MetricsFile string
 }
 
 // RunBackup runs the one-shot backup pipeline.
@@ -40,6 +44,18 @@ func RunBackup(ctx context.Context, opts BackupOptions) error {
 	if opts.Logger == nil {
 		opts.Logger = slog.Default()
 	}
+	started := time.Now()
+	err := runBackup(ctx, opts)
+	observeBackup(started, err)
+	if opts.MetricsFile != "" {
+		if werr := registry.WriteFile(opts.MetricsFile); werr != nil {
+			opts.Logger.Warn("metrics.write_failed", slog.String("path", opts.MetricsFile), slog.String("error", werr.Error()))
+		}
+	}
+	return err
+}
+
+func runBackup(ctx context.Context, opts BackupOptions) error {
 	if opts.RCBImage == "" {
 		return errors.New("rcb image required")
 	}
@@ -63,15 +79,7 @@ func RunBackup(ctx context.Context, opts BackupOptions) error {
 	defer client.Close()
 
 	stopped, stopErr := quiesceContainers(ctx, client, opts)
-	defer func() {
-		for _, ctr := range stopped {
-			if err := client.StartContainer(ctx, ctr.ID); err != nil {
-				opts.Logger.Error("quiesce.start_failed", slog.String("container", ctr.Name), slog.String("error", err.Error()))
-			} else {
-				opts.Logger.Info("quiesce.started", slog.String("container", ctr.Name))
-			}
-		}
-	}()
+	defer resumeContainers(ctx, client, stopped, opts.Logger)
 	if stopErr != nil {
 		return stopErr
 	}
@@ -88,7 +96,7 @@ func RunBackup(ctx context.Context, opts BackupOptions) error {
 	return nil
 }
 
-func quiesceContainers(ctx context.Context, client *docker.Client, opts BackupOptions) ([]docker.Container, error) {
+func quiesceContainers(ctx context.Context, client docker.API, opts BackupOptions) ([]docker.Container, error) {
 	if opts.QuiesceLabel == "" {
 		return nil, nil
 	}
@@ -110,13 +118,27 @@ func quiesceContainers(ctx context.Context, client *docker.Client, opts BackupOp
 	return stopped, nil
 }
 
-func runRetention(ctx context.Context, client *docker.Client, opts BackupOptions) error {
+// resumeContainers starts quiesced containers again, logging rather than
+// stopping on failures so every container gets its chance.
+func resumeContainers(ctx context.Context, client docker.API, stopped []docker.Container, logger *slog.Logger) {
+	for _, ctr := range stopped {
+		if err := client.StartContainer(ctx, ctr.ID); err != nil {
+			logger.Error("quiesce.start_failed", slog.String("container", ctr.Name), slog.String("error", err.Error()))
+		} else {
+			logger.Info("quiesce.started", slog.String("container", ctr.Name))
+		}
+	}
+}
+
+func runRetention(ctx context.Context, client docker.API, opts BackupOptions) error {
 	containers, err := client.ListBackrestEnabled(ctx)
 	if err != nil {
 		return err
 	}
 	for _, ctr := range containers {
-		spec := strings.TrimSpace(ctr.Labels[model.LabelRetentionKeep])
+		// an invalid backrest.spec is reported by reconcile; fall back to the label
+		structured, _ := model.ParseSpec(ctr.Labels)
+		spec, _ := structured.Setting(ctr.Labels, model.LabelRetentionKeep)
 		if spec == "" {
 			continue
 		}
@@ -0,0 +1,41 @@
+package app
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker/dockertest"
+)
+
+func TestQuiesceStopsRunningContainersAndResumesThem(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	engine.Add(dockertest.Container{Name: "db", Labels: map[string]string{"backrest.quiesce": "true"}})
+	engine.Add(dockertest.Container{Name: "already-down", State: "exited", Labels: map[string]string{"backrest.quiesce": "true"}})
+	engine.Add(dockertest.Container{Name: "web"})
+	client := engine.Client(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	opts := BackupOptions{QuiesceLabel: "backrest.quiesce=true", QuiesceTimeout: time.Second, Logger: logger}
+
+	stopped, err := quiesceContainers(context.Background(), client, opts)
+	if err != nil {
+		t.Fatalf("quiesce: %v", err)
+	}
+	if len(stopped) != 1 || stopped[0].Name != "db" {
+		t.Fatalf("expected only db to be stopped, got %+v", stopped)
+	}
+	if ctr, _ := engine.Get("db"); ctr.State != "exited" {
+		t.Fatalf("db not stopped: %s", ctr.State)
+	}
+
+	resumeContainers(context.Background(), client, stopped, logger)
+	if got := strings.Join(engine.Calls(), ","); got != "stop db,start db" {
+		t.Fatalf("unexpected lifecycle calls %s", got)
+	}
+	if ctr, _ := engine.Get("already-down"); ctr.State != "exited" {
+		t.Fatalf("a container that was already down must stay down")
+	}
+}
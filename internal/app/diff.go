@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/zettaio/backrest-sidecar/internal/config"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+	"github.com/zettaio/backrest-sidecar/internal/util/textdiff"
+)
+
+// Plan change kinds reported by Diff.
+const (
+	PlanAdded    = "added"
+	PlanChanged  = "changed"
+	PlanOrphaned = "orphaned"
+)
+
+// PlanChange is one plan reconcile would touch.
+type PlanChange struct {
+	PlanID string `json:"plan_id"`
+	Status string `json:"status"`
+	// Fields lists the changed JSON fields as dotted paths.
+	Fields []string    `json:"fields,omitempty"`
+	Before *model.Plan `json:"before,omitempty"`
+	After  *model.Plan `json:"after,omitempty"`
+}
+
+// ConfigDiff is what a reconcile pass would change in config.json.
+type ConfigDiff struct {
+	Changed bool         `json:"changed"`
+	Plans   []PlanChange `json:"plans"`
+	// Skipped lists containers that would not render a plan.
+	Skipped []ContainerRender `json:"skipped,omitempty"`
+}
+
+// Diff runs a pass in memory, without locking or writing anything, and
+// compares the merged config with config.json plan by plan.
+func (r *Reconciler) Diff(ctx context.Context) (*ConfigDiff, error) {
+	dryRun := r.dryRun
+	r.dryRun = true
+	defer func() { r.dryRun = dryRun }()
+	containers, err := r.client.ListBackrestEnabled(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list containers: %w", err)
+	}
+	before, _, err := config.Load(r.cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	out, err := r.mergeAndWrite(ctx, containers)
+	if err != nil {
+		return nil, err
+	}
+
+	orphaned := make(map[string]bool, len(out.result.Orphans))
+	for _, orphan := range out.result.Orphans {
+		orphaned[orphan.PlanID] = true
+	}
+	diff := &ConfigDiff{Plans: []PlanChange{}}
+	for _, id := range out.changedIDs {
+		change := PlanChange{PlanID: id, Status: PlanChanged}
+		if plan := before.FindPlan(id); plan != nil {
+			change.Before = plan
+		}
+		if plan := out.merged.FindPlan(id); plan != nil {
+			change.After = plan
+		}
+		switch {
+		case change.Before == nil:
+			change.Status = PlanAdded
+		case orphaned[id]:
+			change.Status = PlanOrphaned
+		}
+		if change.Before != nil && change.After != nil {
+			change.Fields = model.DiffPlans(*change.Before, *change.After)
+		}
+		diff.Plans = append(diff.Plans, change)
+	}
+	sort.Slice(diff.Plans, func(i, j int) bool { return diff.Plans[i].PlanID < diff.Plans[j].PlanID })
+	diff.Changed = len(diff.Plans) > 0
+	for _, render := range r.renders.get() {
+		if render.Skipped != "" {
+			diff.Skipped = append(diff.Skipped, render)
+		}
+	}
+	return diff, nil
+}
+
+// Unified renders the change as a unified diff of the plan's JSON.
+func (c PlanChange) Unified() (string, error) {
+	before, err := indentedPlan(c.Before)
+	if err != nil {
+		return "", err
+	}
+	after, err := indentedPlan(c.After)
+	if err != nil {
+		return "", err
+	}
+	return textdiff.Unified("config.json: "+c.PlanID, "rendered: "+c.PlanID, before, after, 3), nil
+}
+
+func indentedPlan(plan *model.Plan) (string, error) {
+	if plan == nil {
+		return "", nil
+	}
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
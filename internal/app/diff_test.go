@@ -0,0 +1,84 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker/dockertest"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+)
+
+func TestDiffReportsPlanChangesWithoutWriting(t *testing.T) {
+	cases := []struct {
+		name   string
+		run    bool // reconcile once before editing the containers
+		edit   func(*dockertest.Engine)
+		want   string
+		inDiff string // expected in the unified diff of the first change
+	}{
+		{name: "new containers", want: "backrest_sidecar_app added, backrest_sidecar_db added"},
+		{name: "after a run", run: true},
+		{
+			name: "label change",
+			run:  true,
+			edit: func(e *dockertest.Engine) {
+				e.Update("app", func(c *dockertest.Container) { c.Labels[model.LabelSchedule] = "0 5 * * *" })
+			},
+			want:   "backrest_sidecar_app changed [schedule.cron]",
+			inDiff: "0 5 * * *",
+		},
+		{
+			name: "removed container",
+			run:  true,
+			edit: func(e *dockertest.Engine) { e.Remove("db") },
+			want: "backrest_sidecar_db orphaned",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t, func(o *ReconcileOptions) { o.OrphanPolicy = OrphanDelete }, testAppContainer("app"), testAppContainer("db"))
+			ctx := context.Background()
+			if tc.run {
+				if _, err := env.r.Run(ctx); err != nil {
+					t.Fatalf("run: %v", err)
+				}
+			}
+			if tc.edit != nil {
+				tc.edit(env.engine)
+			}
+			diff, err := env.r.Diff(ctx)
+			if err != nil {
+				t.Fatalf("diff: %v", err)
+			}
+			if got := summarizeDiff(diff); got != tc.want || diff.Changed != (tc.want != "") {
+				t.Fatalf("expected %q, got %q (changed=%v)", tc.want, got, diff.Changed)
+			}
+			// a diff never writes
+			if again, err := env.r.Diff(ctx); err != nil || summarizeDiff(again) != tc.want {
+				t.Fatalf("expected the second diff to match the first, got %+v (err %v)", again, err)
+			}
+			if tc.inDiff == "" {
+				return
+			}
+			text, err := diff.Plans[0].Unified()
+			if err != nil || !strings.Contains(text, "+++ rendered: "+diff.Plans[0].PlanID) || !strings.Contains(text, tc.inDiff) {
+				t.Fatalf("unexpected unified diff (err %v):\n%s", err, text)
+			}
+		})
+	}
+}
+
+// summarizeDiff renders a diff as "<plan> <status> [fields]", comma-separated.
+func summarizeDiff(diff *ConfigDiff) string {
+	var parts []string
+	for _, change := range diff.Plans {
+		part := change.PlanID + " " + change.Status
+		if change.Status == PlanChanged {
+			part += fmt.Sprintf(" %v", change.Fields)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
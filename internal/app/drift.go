@@ -0,0 +1,162 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/zettaio/backrest-sidecar/internal/model"
+	"github.com/zettaio/backrest-sidecar/internal/state"
+)
+
+// OverridePolicy decides what happens to edits made outside the sidecar (e.g.
+// in the Backrest UI) to fields of sidecar-owned plans.
+type OverridePolicy string
+
+const (
+	// OverrideLabelsWin restores every edited field from the labels.
+	OverrideLabelsWin OverridePolicy = "labels-win"
+	// OverrideUIWins keeps edited fields even when the labels change them later.
+	OverrideUIWins OverridePolicy = "ui-wins"
+	// OverrideFieldMerge keeps edited fields until the labels change the same field.
+	OverrideFieldMerge OverridePolicy = "field-merge"
+)
+
+// ParseOverridePolicy validates a policy name, defaulting to labels-win.
+func ParseOverridePolicy(raw string) (OverridePolicy, error) {
+	switch OverridePolicy(strings.ToLower(strings.TrimSpace(raw))) {
+	case "", OverrideLabelsWin:
+		return OverrideLabelsWin, nil
+	case OverrideUIWins:
+		return OverrideUIWins, nil
+	case OverrideFieldMerge:
+		return OverrideFieldMerge, nil
+	default:
+		return "", fmt.Errorf("invalid override policy %q (want labels-win|ui-wins|field-merge)", raw)
+	}
+}
+
+func (p OverridePolicy) mergeMode() model.MergeMode {
+	switch p {
+	case OverrideUIWins:
+		return model.MergeTheirs
+	case OverrideFieldMerge:
+		return model.MergeFields
+	default:
+		return model.MergeLabels
+	}
+}
+
+// PlanDrift is a sidecar-owned plan that was edited or deleted outside the
+// sidecar since it was last rendered from labels.
+type PlanDrift struct {
+	PlanID string `json:"plan_id"`
+	// Fields lists the edited JSON fields as dotted paths.
+	Fields []string `json:"fields,omitempty"`
+	// Kept and Reverted split Fields by the override policy's verdict.
+	Kept     []string `json:"kept,omitempty"`
+	Reverted []string `json:"reverted,omitempty"`
+	Deleted  bool     `json:"deleted,omitempty"`
+}
+
+// detectDrift three-way merges each rendered plan (base: the previous label
+// render kept in state, ours: the new render, theirs: the plan in cfg) and
+// replaces plans[i] with the result, so edits made elsewhere survive as the
+// override policy allows. Deleted plans are always recreated.
+func (r *Reconciler) detectDrift(cfg *model.Config, st *state.State, plans []model.Plan) []PlanDrift {
+	var drift []PlanDrift
+	for i := range plans {
+		id := plans[i].ID
+		raw, ok := st.Rendered[id]
+		if !ok {
+			continue
+		}
+		var base model.Plan
+		if err := json.Unmarshal(raw, &base); err != nil {
+			continue
+		}
+		current := cfg.FindPlan(id)
+		if current == nil {
+			drift = append(drift, PlanDrift{PlanID: id, Deleted: true})
+			r.log.Warn("plan.drift", slog.String("plan_id", id), slog.Bool("deleted", true), slog.String("action", "restoring from labels"))
+			continue
+		}
+		ours := plans[i]
+		ours.InheritExtras(*current)
+		merged, kept, reverted, err := model.MergePlans(base, ours, *current, r.opts.OverridePolicy.mergeMode())
+		if err != nil {
+			r.log.Warn("plan.merge_failed", slog.String("plan_id", id), slog.String("error", err.Error()))
+			continue
+		}
+		r.noteOverrides(id, kept)
+		if len(kept) == 0 && len(reverted) == 0 {
+			continue
+		}
+		plans[i] = merged
+		fields := append(append([]string(nil), kept...), reverted...)
+		sort.Strings(fields)
+		drift = append(drift, PlanDrift{PlanID: id, Fields: fields, Kept: kept, Reverted: reverted})
+		if len(reverted) > 0 {
+			r.log.Warn("plan.drift", slog.String("plan_id", id), slog.Any("fields", reverted), slog.String("action", "restoring from labels"))
+		}
+	}
+	return drift
+}
+
+// noteOverrides logs the fields kept from outside edits whenever that set
+// changes, rather than on every pass.
+func (r *Reconciler) noteOverrides(id string, kept []string) {
+	key := strings.Join(kept, ",")
+	if r.overrides[id] == key {
+		return
+	}
+	if key == "" {
+		delete(r.overrides, id)
+		r.log.Info("plan.overrides_cleared", slog.String("plan_id", id))
+		return
+	}
+	if r.overrides == nil {
+		r.overrides = make(map[string]string)
+	}
+	r.overrides[id] = key
+	r.log.Info("plan.drift", slog.String("plan_id", id), slog.Any("fields", kept), slog.String("action", "keeping edits"), slog.String("policy", string(r.opts.OverridePolicy)))
+}
+
+// recordRendered remembers the label renders as the base of the next merge
+// and forgets plans whose containers are gone.
+func (r *Reconciler) recordRendered(st *state.State, rendered []model.Plan, live map[string]struct{}) {
+	for id := range st.Rendered {
+		if _, ok := live[id]; !ok {
+			delete(st.Rendered, id)
+			r.stateDirty = true
+		}
+	}
+	for _, plan := range rendered {
+		if raw, ok := st.Rendered[plan.ID]; ok {
+			var last model.Plan
+			if json.Unmarshal(raw, &last) == nil && last.Fingerprint() == plan.Fingerprint() {
+				continue
+			}
+		}
+		raw, err := json.Marshal(plan)
+		if err != nil {
+			continue
+		}
+		st.Rendered[plan.ID] = raw
+		r.stateDirty = true
+	}
+}
+
+// externalConfigChange reports whether the config on disk differs from what
+// this reconciler last wrote, i.e. a watch event was not our own write.
+func (r *Reconciler) externalConfigChange() bool {
+	data, err := os.ReadFile(r.cfgPath)
+	if err != nil {
+		return true
+	}
+	return sha256.Sum256(data) != r.lastWritten
+}
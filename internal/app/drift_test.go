@@ -0,0 +1,180 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/zettaio/backrest-sidecar/internal/model"
+	"github.com/zettaio/backrest-sidecar/internal/state"
+)
+
+func TestDetectDriftReportsExternalEditsAndDeletes(t *testing.T) {
+	r := testDriftReconciler(OverrideLabelsWin)
+	edited := model.Plan{ID: "backrest_sidecar_app", Repo: "repo", Paths: []string{"/data"}, Schedule: model.PlanSchedule{Cron: "0 2 * * *"}}
+	deleted := model.Plan{ID: "backrest_sidecar_db", Repo: "repo", Paths: []string{"/db"}, Schedule: model.PlanSchedule{Cron: "0 3 * * *"}}
+	untouched := model.Plan{ID: "backrest_sidecar_web", Repo: "repo", Paths: []string{"/web"}, Schedule: model.PlanSchedule{Cron: "0 4 * * *"}}
+
+	st := &state.State{}
+	st.EnsureNonNil()
+	live := map[string]struct{}{edited.ID: {}, deleted.ID: {}, untouched.ID: {}}
+	rendered := []model.Plan{edited, deleted, untouched}
+	r.recordRendered(st, rendered, live)
+	if len(st.Rendered) != 3 || !r.stateDirty {
+		t.Fatalf("expected all rendered plans recorded, got %d", len(st.Rendered))
+	}
+
+	// someone edits one plan in the UI and deletes another
+	ui := &model.Config{Plans: []model.Plan{edited, untouched}}
+	ui.Plans[0].Schedule.Cron = "0 5 * * *"
+	ui.Plans[0].Paths = []string{"/data", "/extra"}
+
+	plans := []model.Plan{edited, deleted, untouched}
+	drift := r.detectDrift(ui, st, plans)
+	if len(drift) != 2 {
+		t.Fatalf("expected 2 drifted plans, got %+v", drift)
+	}
+	if drift[0].PlanID != edited.ID || strings.Join(drift[0].Fields, ",") != "paths,schedule.cron" {
+		t.Fatalf("unexpected edit drift %+v", drift[0])
+	}
+	if drift[1].PlanID != deleted.ID || !drift[1].Deleted {
+		t.Fatalf("unexpected delete drift %+v", drift[1])
+	}
+	if plans[0].Schedule.Cron != "0 2 * * *" || len(plans[0].Paths) != 1 {
+		t.Fatalf("labels-win should restore the label render, got %+v", plans[0])
+	}
+}
+
+func TestDetectDriftOverridePolicies(t *testing.T) {
+	base := model.Plan{ID: "backrest_sidecar_app", Repo: "repo", Paths: []string{"/data"}, Schedule: model.PlanSchedule{Cron: "0 2 * * *", Clock: "CLOCK_LOCAL"}}
+	// the UI changed the cron and the paths; the labels since changed the paths too
+	ui := base
+	ui.Schedule.Cron = "30 1 * * *"
+	ui.Paths = []string{"/data", "/ui"}
+	labels := base
+	labels.Paths = []string{"/data", "/labels"}
+
+	cases := []struct {
+		policy   OverridePolicy
+		cron     string
+		paths    string
+		kept     string
+		reverted string
+	}{
+		{OverrideLabelsWin, "0 2 * * *", "/data,/labels", "", "paths,schedule.cron"},
+		{OverrideFieldMerge, "30 1 * * *", "/data,/labels", "schedule.cron", "paths"},
+		{OverrideUIWins, "30 1 * * *", "/data,/ui", "paths,schedule.cron", ""},
+	}
+	for _, tc := range cases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			r := testDriftReconciler(tc.policy)
+			st := &state.State{}
+			st.EnsureNonNil()
+			r.recordRendered(st, []model.Plan{base}, map[string]struct{}{base.ID: {}})
+
+			plans := []model.Plan{labels}
+			drift := r.detectDrift(&model.Config{Plans: []model.Plan{ui}}, st, plans)
+			if len(drift) != 1 {
+				t.Fatalf("expected one drifted plan, got %+v", drift)
+			}
+			got := plans[0]
+			if got.Schedule.Cron != tc.cron || strings.Join(got.Paths, ",") != tc.paths || got.Schedule.Clock != "CLOCK_LOCAL" {
+				t.Fatalf("unexpected merge result %+v", got)
+			}
+			if strings.Join(drift[0].Kept, ",") != tc.kept || strings.Join(drift[0].Reverted, ",") != tc.reverted {
+				t.Fatalf("unexpected verdict kept=%v reverted=%v", drift[0].Kept, drift[0].Reverted)
+			}
+		})
+	}
+}
+
+func TestDetectDriftKeepsRetentionPolicyEditedInBackrest(t *testing.T) {
+	base := model.Plan{ID: "backrest_sidecar_app", Repo: "repo", Paths: []string{"/data"}, Schedule: model.PlanSchedule{Cron: "0 2 * * *", Clock: "CLOCK_LOCAL"}}
+	base.Retention.RetentionFromSpec("daily=7")
+	// the UI switched to keep-last-30; the labels since changed the schedule
+	var ui model.Plan
+	seed := `{"id":"backrest_sidecar_app","repo":"repo","paths":["/data"],"schedule":{"cron":"0 2 * * *","clock":"CLOCK_LOCAL"},"retention":{"policyKeepLastN":30}}`
+	if err := json.Unmarshal([]byte(seed), &ui); err != nil {
+		t.Fatalf("unmarshal plan: %v", err)
+	}
+	labels := base.Clone()
+	labels.Schedule.Cron = "0 4 * * *"
+
+	r := testDriftReconciler(OverrideUIWins)
+	st := &state.State{}
+	st.EnsureNonNil()
+	r.recordRendered(st, []model.Plan{base}, map[string]struct{}{base.ID: {}})
+	cfg := &model.Config{Plans: []model.Plan{ui}}
+	plans := []model.Plan{labels}
+	drift := r.detectDrift(cfg, st, plans)
+	if len(drift) != 1 || strings.Join(drift[0].Kept, ",") != "retention" {
+		t.Fatalf("expected the retention edit to be kept, got %+v", drift)
+	}
+
+	cfg.UpsertPlans(plans)
+	out, err := json.Marshal(cfg.Plans[0])
+	if err != nil {
+		t.Fatalf("marshal plan: %v", err)
+	}
+	if !strings.Contains(string(out), `"retention":{"policyKeepLastN":30}`) || !strings.Contains(string(out), `"cron":"0 4 * * *"`) {
+		t.Fatalf("expected the label schedule and the UI retention, got %s", out)
+	}
+}
+
+func TestRunKeepsTheRecordedBaseFreeOfBackrestEdits(t *testing.T) {
+	app := testAppContainer("app")
+	app.Labels[model.LabelHookSnapshotStart] = "echo start"
+	env := newTestEnv(t, nil, app)
+	ctx := context.Background()
+	if _, err := env.r.Run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	// an unmodeled hook field set in the Backrest UI
+	data, err := os.ReadFile(env.path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	edited := strings.Replace(string(data), `"actionCommand"`, `"onError": "ON_ERROR_CANCEL", "actionCommand"`, 1)
+	if edited == string(data) {
+		t.Fatalf("expected a hook in %s", data)
+	}
+	if err := os.WriteFile(env.path, []byte(edited), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if _, err := env.r.Run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	st, err := state.Load(env.r.statePath)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if base := string(st.Rendered["backrest_sidecar_app"]); base == "" || strings.Contains(base, "onError") {
+		t.Fatalf("expected the recorded base to be the label render, got %s", base)
+	}
+	if data, _ := os.ReadFile(env.path); !strings.Contains(string(data), "ON_ERROR_CANCEL") {
+		t.Fatalf("expected the hook edit kept in config.json, got %s", data)
+	}
+}
+
+func TestRecordRenderedForgetsGoneContainers(t *testing.T) {
+	r := testDriftReconciler(OverrideLabelsWin)
+	st := &state.State{Rendered: map[string]json.RawMessage{"backrest_sidecar_gone": json.RawMessage(`{"id":"backrest_sidecar_gone"}`)}}
+	st.EnsureNonNil()
+	r.recordRendered(st, nil, map[string]struct{}{})
+	if len(st.Rendered) != 0 {
+		t.Fatalf("expected gone plan to be forgotten, got %v", st.Rendered)
+	}
+}
+
+func testDriftReconciler(policy OverridePolicy) *Reconciler {
+	return &Reconciler{
+		opts: ReconcileOptions{OverridePolicy: policy},
+		log:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
@@ -0,0 +1,208 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dockerevents "github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+)
+
+// DefaultEventActions are the container events that can change rendered
+// plans. start and die are left out since stopped containers are rendered
+// too; add them when a pass should follow every restart.
+var DefaultEventActions = []string{"create", "destroy", "rename", "update"}
+
+// containerEventFilter subscribes to the given container actions only, so
+// exec_start, health_status and attach noise from healthchecks never reaches
+// the sidecar.
+func containerEventFilter(actions []string) filters.Args {
+	args := filters.NewArgs()
+	args.Add("type", string(dockerevents.ContainerEventType))
+	for _, action := range actions {
+		args.Add("event", action)
+	}
+	return args
+}
+
+// hasBackrestLabels reports whether an event's container carries any
+// backrest.* label. The Engine API can only filter on exact label keys, so
+// the prefix is matched here; container events carry all labels as actor
+// attributes, including on destroy.
+func hasBackrestLabels(msg dockerevents.Message) bool {
+	for key := range msg.Actor.Attributes {
+		if strings.HasPrefix(key, model.LabelPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventTargets collects the IDs of the containers events named since the
+// last pass, for RunChanged.
+type eventTargets struct {
+	mu  sync.Mutex
+	ids map[string]struct{}
+}
+
+func (t *eventTargets) add(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ids == nil {
+		t.ids = make(map[string]struct{})
+	}
+	t.ids[id] = struct{}{}
+}
+
+// take returns the collected IDs, sorted, and forgets them.
+func (t *eventTargets) take() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]string, 0, len(t.ids))
+	for id := range t.ids {
+		ids = append(ids, id)
+	}
+	t.ids = nil
+	sort.Strings(ids)
+	return ids
+}
+
+// eventStream keeps a Docker event subscription alive. When the stream breaks
+// (e.g. dockerd restarts) it resubscribes with exponential backoff, resuming
+// from the last event seen so nothing in between is lost.
+type eventStream struct {
+	client     docker.API
+	filter     filters.Args
+	log        *slog.Logger
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	// onEvent is called for every event; onReconnect after a resubscription,
+	// since events may still have been missed while dockerd was down.
+	onEvent     func(dockerevents.Message)
+	onReconnect func()
+
+	mu        sync.Mutex
+	connected bool
+	lastErr   error
+	since     time.Time
+}
+
+func newEventStream(client docker.API, filter filters.Args, log *slog.Logger) *eventStream {
+	return &eventStream{
+		client:      client,
+		filter:      filter,
+		log:         log,
+		minBackoff:  time.Second,
+		maxBackoff:  time.Minute,
+		onEvent:     func(dockerevents.Message) {},
+		onReconnect: func() {},
+	}
+}
+
+// Connected reports whether the subscription is currently up, and the error
+// that last broke it.
+func (s *eventStream) Connected() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connected, s.lastErr
+}
+
+// run subscribes until ctx is done.
+func (s *eventStream) run(ctx context.Context) {
+	backoff := s.minBackoff
+	for attempt := 0; ; attempt++ {
+		started := time.Now()
+		s.mu.Lock()
+		since := s.since
+		if since.IsZero() {
+			s.since = started
+		}
+		s.mu.Unlock()
+
+		err := s.consume(ctx, since, func() { s.up(attempt, since) })
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = errors.New("event stream closed")
+		}
+		s.mu.Lock()
+		s.connected = false
+		s.lastErr = err
+		s.mu.Unlock()
+		mEventsConnected.Set(0)
+
+		// a stream that stayed up a while was healthy; start over with short waits
+		if time.Since(started) > s.maxBackoff {
+			backoff = s.minBackoff
+		}
+		s.log.Warn("docker.events_disconnected", slog.String("error", err.Error()), slog.Duration("retry_in", backoff))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+	}
+}
+
+// up marks the stream connected once a subscription is established.
+func (s *eventStream) up(attempt int, since time.Time) {
+	s.mu.Lock()
+	s.connected = true
+	s.mu.Unlock()
+	mEventsConnected.Set(1)
+	if attempt > 0 {
+		mEventReconnects.Inc()
+		s.log.Info("docker.events_connected", slog.Int("attempt", attempt), slog.Time("resumed_from", since))
+		s.onReconnect()
+	}
+}
+
+// consume reads one subscription until it fails, calling subscribed once
+// dockerd answers: the subscription itself fails asynchronously, so a ping
+// that follows it without the stream having failed stands in for its
+// acknowledgement.
+func (s *eventStream) consume(ctx context.Context, since time.Time, subscribed func()) error {
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	msgs, errs := s.client.Events(subCtx, s.filter, since)
+	if err := s.client.Ping(subCtx); err != nil {
+		return err
+	}
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+	subscribed()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-msgs:
+			if !ok {
+				return nil
+			}
+			if msg.TimeNano > 0 {
+				s.mu.Lock()
+				s.since = time.Unix(0, msg.TimeNano)
+				s.mu.Unlock()
+			}
+			s.onEvent(msg)
+		case err := <-errs:
+			return err
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	dockerevents "github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker/dockertest"
+)
+
+func TestEventStreamResubscribesAndReplaysMissedEvents(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	client := engine.Client(t)
+
+	var (
+		mu         sync.Mutex
+		seen       []string
+		reconnects int
+	)
+	args := filters.NewArgs()
+	args.Add("type", "container")
+	stream := newEventStream(client, args, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	stream.minBackoff = 200 * time.Millisecond
+	stream.onEvent = func(msg dockerevents.Message) {
+		mu.Lock()
+		seen = append(seen, msg.Actor.Attributes["name"])
+		mu.Unlock()
+	}
+	stream.onReconnect = func() {
+		mu.Lock()
+		reconnects++
+		mu.Unlock()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go stream.run(ctx)
+
+	waitFor(t, "first subscription", func() bool { return engine.Subscribers() == 1 })
+	engine.Emit("start", "app")
+	waitFor(t, "live event", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(seen) == 1
+	})
+
+	// dockerd goes away; an event fires before the sidecar is back
+	engine.DropEventStreams()
+	if engine.Subscribers() != 0 {
+		t.Fatalf("expected no subscribers after the drop")
+	}
+	engine.Emit("start", "db")
+
+	waitFor(t, "replayed event", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(seen) > 0 && seen[len(seen)-1] == "db" && reconnects == 1
+	})
+	if up, lastErr := stream.Connected(); !up || lastErr == nil {
+		t.Fatalf("expected a reconnected stream that remembers the break, got up=%v err=%v", up, lastErr)
+	}
+}
+
+func TestEventStreamStaysDownWhileDockerdIsUnreachable(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	client := engine.Client(t)
+	engine.Close()
+
+	var (
+		mu         sync.Mutex
+		reconnects int
+	)
+	stream := newEventStream(client, containerEventFilter(DefaultEventActions), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	stream.minBackoff = 10 * time.Millisecond
+	stream.maxBackoff = 20 * time.Millisecond
+	stream.onReconnect = func() {
+		mu.Lock()
+		reconnects++
+		mu.Unlock()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go stream.run(ctx)
+
+	waitFor(t, "failed subscription", func() bool {
+		_, lastErr := stream.Connected()
+		return lastErr != nil
+	})
+	// let it retry a few times
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if up, _ := stream.Connected(); up || reconnects != 0 {
+		t.Fatalf("expected no subscription to count as connected, got up=%v reconnects=%d", up, reconnects)
+	}
+}
+
+func TestEventFilterDropsNoiseAndUnlabelledContainers(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	client := engine.Client(t)
+	engine.Add(testAppContainer("app"))
+	engine.Add(dockertest.Container{Name: "web"})
+
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	stream := newEventStream(client, containerEventFilter(DefaultEventActions), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	stream.onEvent = func(msg dockerevents.Message) {
+		if !hasBackrestLabels(msg) {
+			return
+		}
+		mu.Lock()
+		seen = append(seen, string(msg.Action)+" "+msg.Actor.Attributes["name"])
+		mu.Unlock()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go stream.run(ctx)
+
+	waitFor(t, "subscription", func() bool { return engine.Subscribers() == 1 })
+	engine.Emit("exec_start", "app")
+	engine.Emit("health_status", "app")
+	engine.Emit("die", "app")
+	engine.Emit("create", "web")
+	engine.Emit("rename", "app")
+	waitFor(t, "rename event", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(seen) > 0 && seen[len(seen)-1] == "rename app"
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	for _, got := range seen {
+		if got != "create app" && got != "rename app" {
+			t.Fatalf("unexpected event passed the filter: %q (all: %v)", got, seen)
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
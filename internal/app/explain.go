@@ -0,0 +1,227 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+
+	"github.com/zettaio/backrest-sidecar/internal/config"
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+	"github.com/zettaio/backrest-sidecar/internal/state"
+)
+
+// TraceStep is one step of a plan derivation, such as a label read, a
+// default applied or a mount considered for an include path.
+type TraceStep struct {
+	Stage  string `json:"stage"`
+	Detail string `json:"detail"`
+}
+
+// Explanation is how one of a container's plans was derived, step by step.
+type Explanation struct {
+	ContainerRender
+	Steps []TraceStep `json:"steps"`
+}
+
+// planTrace collects derivation steps while PlanBuilder runs. A nil trace
+// records nothing, so the normal build path pays for a nil check only.
+type planTrace struct {
+	steps []TraceStep
+	// repoSource is where the builder's default repo came from.
+	repoSource string
+}
+
+func (t *planTrace) add(stage, format string, args ...any) {
+	if t == nil {
+		return
+	}
+	t.steps = append(t.steps, TraceStep{Stage: stage, Detail: fmt.Sprintf(format, args...)})
+}
+
+// labels notes the backrest.* and compose labels the builder reads.
+func (t *planTrace) labels(labels map[string]string) {
+	if t == nil {
+		return
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		if strings.HasPrefix(key, model.LabelPrefix) || key == model.LabelComposeProject || key == model.LabelComposeService {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		t.add("label", "%s=%s", key, labels[key])
+	}
+}
+
+// value notes where a label-or-default setting came from; source is what
+// Spec.Setting reported, empty when the default applied.
+func (t *planTrace) value(stage, value, source, key, defaultSource string) {
+	if t == nil {
+		return
+	}
+	switch {
+	case source != "":
+		t.add(stage, "%s (%s)", value, source)
+	case value != "":
+		t.add(stage, "%s (default from %s)", value, defaultSource)
+	default:
+		t.add(stage, "unset (no label %s and no %s)", key, defaultSource)
+	}
+}
+
+func (t *planTrace) mount(m dockertypes.MountPoint, detail string) {
+	source := m.Source
+	if m.Type == mount.TypeVolume && m.Name != "" {
+		source = m.Name
+	}
+	t.add("mount", "%s %s at %s: %s", m.Type, source, m.Destination, detail)
+}
+
+func (t *planTrace) defaultRepoSource() string {
+	if t == nil {
+		return ""
+	}
+	switch t.repoSource {
+	case "plan":
+		return "the first plan's repo in config.json"
+	case "repo":
+		return "the first repo in config.json"
+	case "unresolved":
+		return "--default-repo (not found in config.json)"
+	default:
+		return "--default-repo"
+	}
+}
+
+// Explain renders the plans for one container, matched by name or ID
+// prefix, and traces every step the builder took: one Explanation per plan
+// the container renders to. It reads config.json and the state file but
+// changes nothing.
+func (r *Reconciler) Explain(ctx context.Context, ref string) ([]*Explanation, error) {
+	containers, err := r.client.ListBackrestEnabled(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list containers: %w", err)
+	}
+	ctr, err := findContainer(containers, ref)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := config.Load(r.cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	r.setDefaultRepoFromConfig(cfg)
+	st, err := state.Load(r.statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	built := r.builder.buildAll(ctr, func() *planTrace {
+		return &planTrace{repoSource: r.defaultRepoSource}
+	})
+	r.explainProject(ctr, built, containers)
+	r.explainPlanIDs(ctr, built, containers)
+	out := make([]*Explanation, 0, len(built))
+	for _, b := range built {
+		exp := &Explanation{ContainerRender: newContainerRender(ctr).forPlan(b)}
+		tr, plan := b.trace, b.Plan
+		switch {
+		case b.Err != nil:
+			exp.Skipped = b.Err.Error()
+		case !cfg.RepoExists(plan.Repo):
+			exp.Skipped = fmt.Sprintf("repo %q missing from config", plan.Repo)
+		case r.quarantinedRender(st, plan):
+			exp.Skipped = "quarantined: render previously left backrest unhealthy"
+		default:
+			exp.Plan = plan
+		}
+		if exp.Skipped != "" {
+			tr.add("result", "skipped: %s", exp.Skipped)
+		} else if existing := cfg.FindPlan(plan.ID); existing == nil {
+			tr.add("result", "plan %s is not in config.json yet; reconcile adds it", plan.ID)
+		} else if fields := model.DiffPlans(*existing, *plan); len(fields) > 0 {
+			tr.add("result", "plan %s differs from config.json in %s", plan.ID, strings.Join(fields, ", "))
+		} else {
+			tr.add("result", "plan %s matches config.json", plan.ID)
+		}
+		exp.Steps = tr.steps
+		out = append(out, exp)
+	}
+	return out, nil
+}
+
+// explainProject completes a project-granularity plan from the other
+// containers of its project, in place, and traces how they were merged.
+func (r *Reconciler) explainProject(ctr docker.Container, built []BuiltPlan, containers []docker.Container) {
+	if len(built) != 1 || built[0].Project == "" {
+		return
+	}
+	builds := []containerPlans{{ctr: ctr, plans: built}}
+	members := []string{ctr.Name}
+	for _, other := range containers {
+		if other.ID != ctr.ID && other.Project == ctr.Project {
+			builds = append(builds, containerPlans{ctr: other, plans: r.builder.BuildAll(other)})
+		}
+	}
+	conflicts := mergeProjects(builds)
+	for _, cb := range builds[1:] {
+		if len(cb.plans) == 1 && cb.plans[0].ID == built[0].ID {
+			members = append(members, cb.ctr.Name)
+		}
+	}
+	tr := built[0].trace
+	sort.Strings(members)
+	tr.add("project", "plan %s merges %s", built[0].ID, strings.Join(members, ", "))
+	for _, c := range conflicts {
+		tr.add("conflict", "%s", c)
+	}
+}
+
+// explainPlanIDs fails, in place, the plans whose ID a plan of another
+// container took first.
+func (r *Reconciler) explainPlanIDs(ctr docker.Container, built []BuiltPlan, containers []docker.Container) {
+	builds := []containerPlans{{ctr: ctr, plans: built}}
+	for _, other := range containers {
+		if other.ID != ctr.ID {
+			builds = append(builds, containerPlans{ctr: other, plans: r.builder.BuildAll(other)})
+		}
+	}
+	claimPlanIDs(builds)
+}
+
+// quarantinedRender is quarantined without releasing anything.
+func (r *Reconciler) quarantinedRender(st *state.State, plan *model.Plan) bool {
+	fp, ok := st.Quarantined[plan.ID]
+	return ok && fp == plan.Fingerprint()
+}
+
+// findContainer matches ref against container names, then ID prefixes.
+func findContainer(containers []docker.Container, ref string) (docker.Container, error) {
+	ref = strings.TrimPrefix(strings.TrimSpace(ref), "/")
+	for _, ctr := range containers {
+		if ctr.Name == ref {
+			return ctr, nil
+		}
+	}
+	var matches []docker.Container
+	for _, ctr := range containers {
+		if ref != "" && strings.HasPrefix(ctr.ID, ref) {
+			matches = append(matches, ctr)
+		}
+	}
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		return docker.Container{}, fmt.Errorf("no container with %s=true matches %q", model.LabelEnable, ref)
+	default:
+		return docker.Container{}, fmt.Errorf("container id prefix %q is ambiguous", ref)
+	}
+}
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker/dockertest"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+)
+
+func TestExplainTracesHowThePlanIsDerived(t *testing.T) {
+	cases := []struct {
+		name string
+		edit func(*dockertest.Container)
+		want []string
+	}{
+		{
+			name: "defaults",
+			edit: func(c *dockertest.Container) { delete(c.Labels, model.LabelRepo) },
+			want: []string{
+				"repo: repo-a (default from the first repo in config.json)",
+				"schedule: 0 2 * * * (default from --default-schedule)",
+				"result: plan backrest_sidecar_app is not in config.json yet; reconcile adds it",
+			},
+		},
+		{
+			name: "includes through mounts",
+			edit: func(c *dockertest.Container) {
+				c.Labels[model.LabelPathsInclude] = "/config/app.yml,/data/db,/srv"
+				c.Mounts = append([]dockertypes.MountPoint{{Type: mount.TypeBind, Source: "/opt/app/config", Destination: "/config"}}, c.Mounts...)
+			},
+			want: []string{
+				"label: backrest.paths.include=/config/app.yml,/data/db,/srv",
+				"include: /config/app.yml -> /opt/app/config/app.yml",
+				"mount: bind /opt/app/config at /config: does not contain /data/db",
+				"include: /data/db -> /var/lib/docker/volumes/app-data/_data/db",
+				"include: /srv -> /srv (no mount covers it, used as-is)",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctr := testAppContainer("app")
+			tc.edit(&ctr)
+			env := newTestEnv(t, nil, ctr)
+			explanations, err := env.r.Explain(context.Background(), "app")
+			if err != nil {
+				t.Fatalf("explain: %v", err)
+			}
+			if len(explanations) != 1 {
+				t.Fatalf("expected one explanation, got %d", len(explanations))
+			}
+			explanation := explanations[0]
+			if explanation.Plan == nil || explanation.Skipped != "" {
+				t.Fatalf("expected a rendered plan, got %+v", explanation)
+			}
+			var lines []string
+			for _, step := range explanation.Steps {
+				lines = append(lines, step.Stage+": "+step.Detail)
+			}
+			trace := strings.Join(lines, "\n")
+			for _, want := range tc.want {
+				if !strings.Contains(trace, want) {
+					t.Fatalf("trace is missing %q:\n%s", want, trace)
+				}
+			}
+		})
+	}
+}
+
+func TestExplainRejectsUnknownContainers(t *testing.T) {
+	env := newTestEnv(t, nil, testAppContainer("app"))
+	if _, err := env.r.Explain(context.Background(), "missing"); err == nil {
+		t.Fatalf("expected an unknown container to fail")
+	}
+}
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+)
+
+// Granularity controls how much of a container one plan covers.
+type Granularity string
+
+const (
+	// GranularityContainer renders one plan per container (or named plan).
+	GranularityContainer Granularity = "container"
+	// GranularityVolume renders one plan per named volume or bind mount, so
+	// each is snapshotted and retained on its own.
+	GranularityVolume Granularity = "volume"
+	// GranularityProject renders one plan per compose project, merging every
+	// enabled container of the project into it.
+	GranularityProject Granularity = "project"
+)
+
+// ParseGranularity validates a granularity name, defaulting to container.
+func ParseGranularity(raw string) (Granularity, error) {
+	switch Granularity(strings.ToLower(strings.TrimSpace(raw))) {
+	case "", GranularityContainer:
+		return GranularityContainer, nil
+	case GranularityVolume:
+		return GranularityVolume, nil
+	case GranularityProject:
+		return GranularityProject, nil
+	default:
+		return "", fmt.Errorf("invalid plan granularity %q (want container|volume|project)", raw)
+	}
+}
+
+// granularity resolves the backrest.granularity label over
+// --plan-granularity.
+func (b *PlanBuilder) granularity(labels map[string]string, spec *model.Spec, tr *planTrace) (Granularity, error) {
+	raw, source := spec.Setting(labels, model.LabelGranularity)
+	if raw == "" {
+		g, err := ParseGranularity(string(b.opts.Granularity))
+		if err == nil && g != GranularityContainer {
+			tr.value("granularity", string(g), "", model.LabelGranularity, "--plan-granularity")
+		}
+		return g, err
+	}
+	g, err := ParseGranularity(raw)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", sourceLabel(source), err)
+	}
+	tr.value("granularity", string(g), source, model.LabelGranularity, "--plan-granularity")
+	return g, nil
+}
+
+// pathUnit is the host paths one volume or bind mount contributes to a plan.
+// key names the plan the unit becomes in volume granularity; source
+// identifies the mount, or the path no mount covers.
+type pathUnit struct {
+	key    string
+	source string
+	paths  []string
+}
+
+// addPathUnit appends hostPath to the unit of the mount m (or of path, when
+// no mount covers it), creating the unit as needed, so units keep the order
+// their first path was found in. A unit whose key another unit already took,
+// such as volume myapp_data and a bind mount at /data, gets a numeric suffix
+// rather than sharing that unit's plan. Keys are only unique within the
+// container; claimPlanIDs settles IDs other containers' plans also derive.
+func addPathUnit(units []pathUnit, container docker.Container, m *dockertypes.MountPoint, path, hostPath string) []pathUnit {
+	key, source := unitKey(container, m, path)
+	for i := range units {
+		if units[i].source == source {
+			units[i].paths = append(units[i].paths, hostPath)
+			return units
+		}
+	}
+	base := key
+	for n := 2; unitKeyTaken(units, key); n++ {
+		key = fmt.Sprintf("%s_%d", base, n)
+	}
+	return append(units, pathUnit{key: key, source: source, paths: []string{hostPath}})
+}
+
+func unitKeyTaken(units []pathUnit, key string) bool {
+	for _, u := range units {
+		if u.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// unitKey derives a stable plan ID suffix for the mount a path came from:
+// the volume name without its compose project prefix, the bind mount's
+// target, or the path itself when no mount covers it. source tells apart
+// mounts whose keys collide.
+func unitKey(container docker.Container, m *dockertypes.MountPoint, path string) (key, source string) {
+	var raw string
+	switch {
+	case m != nil && m.Type == mount.TypeVolume:
+		raw, source = m.Name, "volume "+m.Name
+		if project := strings.TrimSpace(container.Project); project != "" {
+			if trimmed := strings.TrimPrefix(raw, project+"_"); trimmed != "" {
+				raw = trimmed
+			}
+		}
+	case m != nil:
+		raw, source = m.Destination, "mount "+m.Destination
+	default:
+		raw, source = path, "path "+path
+	}
+	if key = sanitizeID(filepath.ToSlash(raw)); key != "" {
+		return key, source
+	}
+	return "root", source
+}
+
+// volumeKeys lists the units a volume-granularity container splits into, or
+// nil when it renders a single plan. Errors are left for build to report.
+func (b *PlanBuilder) volumeKeys(container docker.Container) []string {
+	spec, err := model.ParseSpec(container.Labels)
+	if err != nil {
+		return nil
+	}
+	if g, err := b.granularity(container.Labels, spec, nil); err != nil || g != GranularityVolume {
+		return nil
+	}
+	units := b.pathUnits(container, spec, nil)
+	keys := make([]string, 0, len(units))
+	for _, u := range units {
+		keys = append(keys, u.key)
+	}
+	return keys
+}
@@ -0,0 +1,192 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/zettaio/backrest-sidecar/internal/config"
+	"github.com/zettaio/backrest-sidecar/internal/state"
+)
+
+// pendingChange is a written config that still has to reach Backrest. In the
+// daemon several passes may fold into one change before it is applied.
+type pendingChange struct {
+	data []byte
+	// rendered maps changed plan IDs to the fingerprint of their render.
+	rendered map[string]string
+	// backedUp is true when the config replaced by this change was saved.
+	backedUp bool
+}
+
+func (c *pendingChange) planIDs() []string {
+	ids := make([]string, 0, len(c.rendered))
+	for id := range c.rendered {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// UnhealthyApplyError reports a config change that left Backrest unhealthy.
+type UnhealthyApplyError struct {
+	PlanIDs    []string
+	Cause      error
+	RolledBack bool
+}
+
+func (e *UnhealthyApplyError) Error() string {
+	if e.RolledBack {
+		return fmt.Sprintf("backrest unhealthy after apply, rolled back plans %v: %v", e.PlanIDs, e.Cause)
+	}
+	return fmt.Sprintf("backrest unhealthy after apply of plans %v: %v", e.PlanIDs, e.Cause)
+}
+
+func (e *UnhealthyApplyError) Unwrap() error {
+	return e.Cause
+}
+
+// applyChange applies a written config and, after a restart, verifies Backrest
+// comes back healthy. An unhealthy Backrest gets the saved config restored.
+func (r *Reconciler) applyChange(ctx context.Context, change *pendingChange) (ApplyMode, error) {
+	mode, err := r.applyConfig(ctx, change.data)
+	if err != nil {
+		return "", err
+	}
+	if mode != ApplyRestart || r.opts.VerifyTimeout <= 0 {
+		return mode, nil
+	}
+	healthErr := r.waitHealthy(ctx)
+	if healthErr == nil {
+		r.log.Info("backrest.healthy", slog.String("container", r.restarts.container))
+		return mode, nil
+	}
+	if ctx.Err() != nil {
+		return mode, ctx.Err()
+	}
+	failed := change.planIDs()
+	r.log.Error("backrest.unhealthy", slog.String("container", r.restarts.container), slog.Any("plans_changed", failed), slog.String("error", healthErr.Error()))
+	applyErr := &UnhealthyApplyError{PlanIDs: failed, Cause: healthErr}
+	if !change.backedUp {
+		r.log.Error("backrest.rollback_skipped", slog.String("reason", "no saved config to restore"))
+		return mode, applyErr
+	}
+	if err := r.rollback(ctx, change); err != nil {
+		return mode, errors.Join(applyErr, err)
+	}
+	applyErr.RolledBack = true
+	return mode, applyErr
+}
+
+// rollback restores the saved config, quarantines the renders that broke
+// Backrest and restarts it again.
+func (r *Reconciler) rollback(ctx context.Context, change *pendingChange) error {
+	lock, err := config.Lock(ctx, r.cfgPath)
+	if err != nil {
+		return fmt.Errorf("config lock: %w", err)
+	}
+	restored, err := config.RestoreBackup(r.cfgPath)
+	lock.Release()
+	if err != nil {
+		return err
+	}
+	r.lastWritten = sha256.Sum256(restored)
+	st, err := state.Load(r.statePath)
+	if err != nil {
+		return err
+	}
+	for id, fp := range change.rendered {
+		st.Quarantined[id] = fp
+	}
+	if err := state.Write(r.statePath, st); err != nil {
+		return err
+	}
+	r.log.Warn("backrest.rollback", slog.String("config", r.cfgPath), slog.Any("plans_quarantined", change.planIDs()))
+	if err := r.control.RestartContainer(ctx, r.restarts.container, r.restarts.timeout); err != nil {
+		return fmt.Errorf("restart backrest after rollback: %w", err)
+	}
+	mRestarts.Inc("rollback")
+	if err := r.waitHealthy(ctx); err != nil {
+		return fmt.Errorf("backrest still unhealthy after rollback: %w", err)
+	}
+	r.log.Info("backrest.healthy", slog.String("container", r.restarts.container), slog.Bool("rolled_back", true))
+	return nil
+}
+
+// waitHealthy polls the Backrest container until it is running and healthy
+// (or, without a Docker healthcheck, has stayed up for the settle period since
+// verification began and passes the optional HTTP probe). A restart by
+// Docker's restart policy, an unhealthy status or an exited container fail fast.
+func (r *Reconciler) waitHealthy(ctx context.Context) error {
+	started := time.Now()
+	deadline := started.Add(r.opts.VerifyTimeout)
+	baseRestarts := -1
+	var lastErr error
+	for {
+		st, err := r.control.InspectState(ctx, r.restarts.container)
+		if err != nil {
+			lastErr = err
+		} else {
+			if baseRestarts < 0 {
+				baseRestarts = st.RestartCount
+			}
+			switch {
+			case st.RestartCount > baseRestarts || st.Restarting:
+				return fmt.Errorf("container is crash-looping (restart count %d)", st.RestartCount)
+			case st.Health == "unhealthy":
+				return errors.New("container healthcheck reports unhealthy")
+			case !st.Running && st.Status != "created":
+				return fmt.Errorf("container %s (exit code %d) %s", st.Status, st.ExitCode, st.Error)
+			case st.Running && st.Health == "healthy":
+				return nil
+			case st.Running && st.Health == "" && time.Since(latest(st.StartedAt, started)) >= r.healthSettle:
+				if lastErr = r.probeHTTP(ctx); lastErr == nil {
+					return nil
+				}
+			default:
+				lastErr = fmt.Errorf("container %s, health %q", st.Status, st.Health)
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not healthy after %s: %w", r.opts.VerifyTimeout, lastErr)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.healthPoll):
+		}
+	}
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// probeHTTP checks the optional Backrest health URL; any non-5xx response counts.
+func (r *Reconciler) probeHTTP(ctx context.Context) error {
+	if r.opts.HealthURL == "" {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.opts.HealthURL, nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http probe: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("http probe: status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zettaio/backrest-sidecar/internal/config"
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+	"github.com/zettaio/backrest-sidecar/internal/state"
+)
+
+func TestApplyChangeRollsBackCrashLoopingBackrest(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.json")
+	good := []byte(`{"plans":[]}`)
+	bad := []byte(`{"plans":[{"id":"backrest_sidecar_app"}]}`)
+	if err := os.WriteFile(cfgPath, bad, 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if err := config.SaveBackup(cfgPath, good); err != nil {
+		t.Fatalf("save backup: %v", err)
+	}
+
+	control := &fakeControl{states: []docker.ContainerState{
+		{Status: "running", Running: true, RestartCount: 0},
+		{Status: "restarting", Restarting: true, RestartCount: 1},
+	}}
+	r := testHealthReconciler(cfgPath, control)
+	r.healthSettle = time.Minute
+	change := &pendingChange{data: bad, rendered: map[string]string{"backrest_sidecar_app": "fp"}, backedUp: true}
+
+	_, err := r.applyChange(context.Background(), change)
+	var unhealthy *UnhealthyApplyError
+	if !errors.As(err, &unhealthy) || !unhealthy.RolledBack {
+		t.Fatalf("expected rolled back unhealthy error, got %v", err)
+	}
+	if len(unhealthy.PlanIDs) != 1 || unhealthy.PlanIDs[0] != "backrest_sidecar_app" {
+		t.Fatalf("unexpected failed plan IDs %v", unhealthy.PlanIDs)
+	}
+	restored, err := os.ReadFile(cfgPath)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	if string(restored) != string(good) {
+		t.Fatalf("expected saved config restored, got %s", restored)
+	}
+	if len(control.restarts) != 2 {
+		t.Fatalf("expected restart plus rollback restart, got %v", control.restarts)
+	}
+	st, err := state.Load(r.statePath)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if st.Quarantined["backrest_sidecar_app"] != "fp" {
+		t.Fatalf("expected failed render to be quarantined, got %+v", st.Quarantined)
+	}
+}
+
+func TestApplyChangeAcceptsHealthyBackrest(t *testing.T) {
+	control := &fakeControl{states: []docker.ContainerState{
+		{Status: "running", Running: true, Health: "starting"},
+		{Status: "running", Running: true, Health: "healthy"},
+	}}
+	r := testHealthReconciler(filepath.Join(t.TempDir(), "config.json"), control)
+	mode, err := r.applyChange(context.Background(), &pendingChange{data: []byte(`{}`), rendered: map[string]string{}})
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if mode != ApplyRestart || len(control.restarts) != 1 {
+		t.Fatalf("expected a single restart, got mode=%s restarts=%v", mode, control.restarts)
+	}
+}
+
+func testHealthReconciler(cfgPath string, control *fakeControl) *Reconciler {
+	r := &Reconciler{
+		opts:         ReconcileOptions{ApplyMode: ApplyRestart, VerifyTimeout: time.Second},
+		control:      control,
+		cfgPath:      cfgPath,
+		statePath:    state.DefaultPath(cfgPath),
+		healthPoll:   time.Millisecond,
+		healthSettle: time.Millisecond,
+		log:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	r.restarts.container = "backrest"
+	return r
+}
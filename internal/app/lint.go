@@ -0,0 +1,293 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/zettaio/backrest-sidecar/internal/config"
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+)
+
+// LintFinding is one problem with a container's backrest.* labels.
+type LintFinding struct {
+	Container string `json:"container"`
+	Label     string `json:"label,omitempty"`
+	Message   string `json:"message"`
+}
+
+// LintReport is the outcome of Lint.
+type LintReport struct {
+	// Containers counts the containers carrying any backrest.* label.
+	Containers int           `json:"containers"`
+	Findings   []LintFinding `json:"findings"`
+}
+
+// Lint checks the backrest.* labels of every container on the host,
+// enabled or not, for the mistakes the builder otherwise ignores silently.
+func (r *Reconciler) Lint(ctx context.Context) (*LintReport, error) {
+	containers, err := r.client.ListAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list containers: %w", err)
+	}
+	return r.LintContainers(containers)
+}
+
+// LintContainers is Lint for containers that did not come from Docker, such
+// as the services of a compose project. Repos are checked against
+// config.json only when it exists.
+func (r *Reconciler) LintContainers(containers []docker.Container) (*LintReport, error) {
+	cfg, loaded, err := config.Load(r.cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	r.setDefaultRepoFromConfig(cfg)
+	if loaded == nil {
+		cfg = nil
+	}
+	return r.lintContainers(cfg, containers), nil
+}
+
+func (r *Reconciler) lintContainers(cfg *model.Config, containers []docker.Container) *LintReport {
+	report := &LintReport{Findings: []LintFinding{}}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+	shared := r.lintSharedPlans(containers)
+	for _, ctr := range containers {
+		if !hasLabelPrefix(ctr.Labels) {
+			continue
+		}
+		report.Containers++
+		report.Findings = append(report.Findings, r.lintContainer(cfg, ctr)...)
+		report.Findings = append(report.Findings, shared[ctr.Name]...)
+	}
+	return report
+}
+
+// lintSharedPlans reports, per container, the settings a project plan
+// overruled in favour of another container's, and the plans skipped because
+// another plan took their ID.
+func (r *Reconciler) lintSharedPlans(containers []docker.Container) map[string][]LintFinding {
+	var builds []containerPlans
+	for _, ctr := range containers {
+		if ctr.Labels[model.LabelEnable] == "true" {
+			builds = append(builds, containerPlans{ctr: ctr, plans: r.builder.BuildAll(ctr)})
+		}
+	}
+	findings := map[string][]LintFinding{}
+	for _, c := range mergeProjects(builds) {
+		for _, name := range sortedNames(c.Ignored) {
+			findings[name] = append(findings[name], LintFinding{
+				Container: name,
+				Label:     c.Label,
+				Message:   fmt.Sprintf("%q is ignored; project plan %s uses %q from %s", c.Ignored[name], c.PlanID, c.Value, c.From),
+			})
+		}
+	}
+	for _, c := range claimPlanIDs(builds) {
+		findings[c.container] = append(findings[c.container], LintFinding{
+			Container: c.container,
+			Message:   fmt.Sprintf("plan id %s is already taken by %s; this plan is skipped", c.id, c.owner),
+		})
+	}
+	return findings
+}
+
+func hasLabelPrefix(labels map[string]string) bool {
+	for key := range labels {
+		if strings.HasPrefix(key, model.LabelPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// lintContainer checks one container. Labels of containers that are not
+// enabled are only checked for unknown keys; a nil cfg skips the repo check.
+func (r *Reconciler) lintContainer(cfg *model.Config, ctr docker.Container) []LintFinding {
+	var findings []LintFinding
+	add := func(label, format string, args ...any) {
+		findings = append(findings, LintFinding{Container: ctr.Name, Label: label, Message: fmt.Sprintf(format, args...)})
+	}
+
+	keys := make([]string, 0, len(ctr.Labels))
+	for key := range ctr.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, model.LabelPrefix) || model.IsKnownLabel(key) {
+			continue
+		}
+		if strings.HasPrefix(key, model.LabelPlanPrefix) {
+			switch _, setting, ok := model.SplitPlanLabel(key); {
+			case !ok:
+				add(key, "malformed named plan label; want %s<name>.<setting>", model.LabelPlanPrefix)
+			case !model.IsNamedPlanSetting(setting):
+				add(key, "%s cannot be set per named plan", setting)
+			}
+			continue
+		}
+		if near := nearestLabel(key); near != "" {
+			add(key, "unknown label; did you mean %s?", near)
+		} else {
+			add(key, "unknown label")
+		}
+	}
+
+	enable, ok := ctr.Labels[model.LabelEnable]
+	switch {
+	case !ok:
+		add(model.LabelEnable, "missing; the container's other backrest.* labels are ignored")
+		return findings
+	case enable == "true":
+	case model.BoolLabel(ctr.Labels, model.LabelEnable):
+		add(model.LabelEnable, "%q is not discovered; only the exact value true enables a container", enable)
+		return findings
+	default:
+		if !isFalse(enable) {
+			add(model.LabelEnable, "%q is not a boolean", enable)
+		}
+		return findings
+	}
+
+	names := model.PlanNames(ctr.Labels)
+	if len(names) == 0 {
+		names = []string{""}
+	}
+	for _, name := range names {
+		for _, f := range r.lintPlan(cfg, ctr, name) {
+			if !slices.Contains(findings, f) {
+				findings = append(findings, f)
+			}
+		}
+	}
+	return findings
+}
+
+// lintPlan checks the settings of one plan of an enabled container: the
+// named plan's view of the labels, or the labels as-is for name "".
+// Findings name the backrest.plan.<name>.* label when that set the value.
+func (r *Reconciler) lintPlan(cfg *model.Config, ctr docker.Container, name string) []LintFinding {
+	var findings []LintFinding
+	labels := ctr.Labels
+	if name != "" {
+		labels = model.NamedPlanLabels(ctr.Labels, name)
+	}
+	add := func(label, format string, args ...any) {
+		if name != "" && label != "" {
+			named := model.LabelPlanPrefix + name + "." + strings.TrimPrefix(label, model.LabelPrefix)
+			if _, ok := ctr.Labels[named]; ok {
+				label = named
+			}
+		}
+		findings = append(findings, LintFinding{Container: ctr.Name, Label: label, Message: fmt.Sprintf(format, args...)})
+	}
+
+	spec, specErr := model.ParseSpec(labels)
+	if specErr != nil {
+		add(model.LabelSpec, "%v", strings.TrimPrefix(specErr.Error(), model.LabelSpec+": "))
+	}
+	schedule, source := spec.Setting(labels, model.LabelSchedule)
+	if schedule != "" {
+		if err := model.ValidateCron(schedule); err != nil {
+			add(sourceLabel(source), "invalid cron %q: %v", schedule, err)
+		}
+	}
+	if keep, source := spec.Setting(labels, model.LabelRetentionKeep); keep != "" {
+		if err := model.ValidateRetentionSpec(keep); err != nil {
+			add(sourceLabel(source), "invalid retention %q: %s", keep, strings.ReplaceAll(err.Error(), "\n", "; "))
+		}
+		if keys := model.BackupOnceOnlyRetentionKeys(keep); len(keys) > 0 {
+			add(sourceLabel(source), "%s in %q: only honoured by backup-once, not by Backrest plans", strings.Join(keys, ", "), keep)
+		}
+	}
+	for _, key := range []string{model.LabelPathsInclude, model.LabelPathsExclude, model.LabelHookSnapshotStart, model.LabelHookSnapshotEnd} {
+		raw, ok := labels[key]
+		if ok && len(model.ParseCSV(raw)) != len(strings.Split(raw, ",")) {
+			add(key, "empty entry in comma-separated list %q", raw)
+		}
+	}
+	includes, source := spec.List(labels, model.LabelPathsInclude)
+	for _, include := range includes {
+		switch {
+		case !filepath.IsAbs(include):
+			add(sourceLabel(source), "%q is not an absolute container path", include)
+		case r.builder.hostPathForLabel(include, ctr.Mounts, nil) == "":
+			add(sourceLabel(source), "%q matches no mount; it is used as a host path as-is", include)
+		}
+	}
+	if template, source := spec.Setting(labels, model.LabelHooksTemplate); template != "" && !strings.EqualFold(template, "none") {
+		if len(r.builder.templateHooks(template, ctr)) == 0 {
+			add(sourceLabel(source), "unknown hooks template %q", template)
+		}
+	}
+	repo, source := spec.Setting(labels, model.LabelRepo)
+	if repo := orDefault(repo, r.builder.opts.DefaultRepo); repo != "" && cfg != nil && !cfg.RepoExists(repo) {
+		add(sourceLabel(orDefault(source, "label "+model.LabelRepo)), "repo %q missing from config.json", repo)
+	}
+	var granularityErr error
+	if raw, source := spec.Setting(labels, model.LabelGranularity); raw != "" {
+		if _, granularityErr = ParseGranularity(raw); granularityErr != nil {
+			add(sourceLabel(source), "%v", granularityErr)
+		}
+	}
+	if specErr == nil && granularityErr == nil {
+		view := ctr
+		view.Labels = labels
+		if _, err := r.builder.build(view, planTarget{name: name}, nil); err != nil {
+			add("", "%v", err)
+		}
+	}
+	return findings
+}
+
+// sourceLabel turns a Spec.Setting source into the label a finding names.
+func sourceLabel(source string) string {
+	if strings.HasPrefix(source, model.LabelSpec) {
+		return model.LabelSpec
+	}
+	return strings.TrimPrefix(source, "label ")
+}
+
+func isFalse(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "0", "f", "false", "n", "no":
+		return true
+	}
+	return false
+}
+
+// nearestLabel suggests the known label closest to a misspelt key.
+func nearestLabel(key string) string {
+	best, bestDist := "", 4
+	for _, known := range model.KnownLabels {
+		if d := editDistance(key, known); d < bestDist {
+			best, bestDist = known, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
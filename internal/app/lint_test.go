@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker/dockertest"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+)
+
+func TestLintReportsLabelMistakes(t *testing.T) {
+	cases := []struct {
+		name string
+		edit func(*dockertest.Container)
+		want []string // "<label>: <message>"
+	}{
+		{name: "valid", edit: func(*dockertest.Container) {}},
+		{
+			name: "unknown label",
+			edit: func(c *dockertest.Container) { c.Labels["backrest.paths.includes"] = "/data" },
+			want: []string{"backrest.paths.includes: unknown label; did you mean backrest.paths.include?"},
+		},
+		{
+			name: "missing enable",
+			edit: func(c *dockertest.Container) {
+				delete(c.Labels, model.LabelEnable)
+				c.Labels["backrest.enabled"] = "true"
+			},
+			want: []string{
+				"backrest.enable: missing; the container's other backrest.* labels are ignored",
+				"backrest.enabled: unknown label; did you mean backrest.enable?",
+			},
+		},
+		{
+			name: "invalid cron",
+			edit: func(c *dockertest.Container) { c.Labels[model.LabelSchedule] = "0 25 * * *" },
+			want: []string{`backrest.schedule: invalid cron "0 25 * * *": hour "25": 25 is outside 0-23`},
+		},
+		{
+			name: "invalid retention",
+			edit: func(c *dockertest.Container) { c.Labels[model.LabelRetentionKeep] = "dayly=7" },
+			want: []string{`backrest.keep: invalid retention "dayly=7": unknown retention key "dayly"`},
+		},
+		{
+			name: "retention keys only backup-once honours",
+			edit: func(c *dockertest.Container) { c.Labels[model.LabelRetentionKeep] = "daily=7,last=5,within=30d" },
+			want: []string{`backrest.keep: last, within in "daily=7,last=5,within=30d": only honoured by backup-once, not by Backrest plans`},
+		},
+		{
+			name: "missing repo",
+			edit: func(c *dockertest.Container) { c.Labels[model.LabelRepo] = "repo-z" },
+			want: []string{`backrest.repo: repo "repo-z" missing from config.json`},
+		},
+		{
+			name: "invalid granularity",
+			edit: func(c *dockertest.Container) { c.Labels[model.LabelGranularity] = "disk" },
+			want: []string{`backrest.granularity: invalid plan granularity "disk" (want container|volume|project)`},
+		},
+		{
+			name: "include paths",
+			edit: func(c *dockertest.Container) { c.Labels[model.LabelPathsInclude] = "/data,/elsewhere," },
+			want: []string{
+				`backrest.paths.include: "/elsewhere" matches no mount; it is used as a host path as-is`,
+				`backrest.paths.include: empty entry in comma-separated list "/data,/elsewhere,"`,
+			},
+		},
+		{
+			name: "named plans",
+			edit: func(c *dockertest.Container) {
+				c.Labels[model.LabelPlanPrefix+"dump.schedule"] = "hourly"
+				c.Labels[model.LabelPlanPrefix+"dump.quiesce"] = "true"
+				c.Labels[model.LabelPlanPrefix+"full"] = "true"
+			},
+			want: []string{
+				"backrest.plan.dump.quiesce: backrest.quiesce cannot be set per named plan",
+				`backrest.plan.dump.schedule: invalid cron "hourly": want 5 fields, got 1`,
+				"backrest.plan.full: malformed named plan label; want backrest.plan.<name>.<setting>",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctr := testAppContainer("app")
+			tc.edit(&ctr)
+			env := newTestEnv(t, nil, ctr)
+			report, err := env.r.Lint(context.Background())
+			if err != nil {
+				t.Fatalf("lint: %v", err)
+			}
+			var got []string
+			for _, f := range report.Findings {
+				got = append(got, f.Label+": "+f.Message)
+			}
+			sort.Strings(got)
+			if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+				t.Fatalf("expected findings:\n%s\ngot:\n%s", strings.Join(tc.want, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
+
+func TestLintCountsLabelledContainersOnly(t *testing.T) {
+	env := newTestEnv(t, nil, testAppContainer("app"), dockertest.Container{Name: "unrelated", Labels: map[string]string{"other": "x"}})
+	report, err := env.r.Lint(context.Background())
+	if err != nil {
+		t.Fatalf("lint: %v", err)
+	}
+	if report.Containers != 1 || len(report.Findings) != 0 {
+		t.Fatalf("expected one clean labelled container, got %+v", report)
+	}
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/zettaio/backrest-sidecar/internal/metrics"
+)
+
+// registry holds the process-wide sidecar metrics served by the daemon's
+// --metrics-addr and written by backup-once's --metrics-file.
+var registry = metrics.NewRegistry()
+
+var (
+	mPasses = registry.NewCounter("backrest_sidecar_reconcile_passes_total",
+		"Reconcile passes by outcome (changed, unchanged, skipped when nothing moved, failed).", "outcome")
+	mPassDuration = registry.NewHistogram("backrest_sidecar_reconcile_duration_seconds",
+		"Time spent in a reconcile pass, applying included.", []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 30, 120})
+	mLastSuccess = registry.NewGauge("backrest_sidecar_reconcile_last_success_timestamp_seconds",
+		"Unix time of the last reconcile pass that did not fail.")
+	mPlansRendered = registry.NewGauge("backrest_sidecar_plans_rendered",
+		"Plans rendered from container labels in the last full pass.")
+	mPlansSkipped = registry.NewCounter("backrest_sidecar_plans_skipped_total",
+		"Containers whose plan was not rendered, by reason (invalid, repo_missing, quarantined).", "reason")
+	mPlansChanged = registry.NewCounter("backrest_sidecar_plans_changed_total",
+		"Plans changed in written configs, by reason (labels, orphan).", "reason")
+	mConfigWrites = registry.NewCounter("backrest_sidecar_config_writes_total",
+		"Attempts to write config.json, by outcome (written, conflict, failed).", "outcome")
+	mApplies = registry.NewCounter("backrest_sidecar_backrest_applies_total",
+		"Configs applied to Backrest, by mode (api, restart).", "mode")
+	mRestarts = registry.NewCounter("backrest_sidecar_backrest_restarts_total",
+		"Backrest container restarts, by reason (apply, rollback).", "reason")
+	mEventReconnects = registry.NewCounter("backrest_sidecar_docker_event_reconnects_total",
+		"Docker event stream resubscriptions after the stream broke.")
+	mEventsConnected = registry.NewGauge("backrest_sidecar_docker_events_connected",
+		"1 while the Docker event stream is subscribed.")
+	mBackups = registry.NewCounter("backrest_sidecar_backup_runs_total",
+		"backup-once runs by outcome (success, failure).", "outcome")
+	mBackupDuration = registry.NewGauge("backrest_sidecar_backup_last_duration_seconds",
+		"Duration of the last backup-once run.")
+	mBackupLastSuccess = registry.NewGauge("backrest_sidecar_backup_last_success_timestamp_seconds",
+		"Unix time of the last successful backup-once run.")
+)
+
+// observePass records a finished reconcile pass.
+func observePass(started time.Time, result *ReconcileResult, err error) {
+	mPassDuration.Observe(time.Since(started).Seconds())
+	switch {
+	case err != nil:
+		mPasses.Inc("failed")
+		return
+	case result.Cached:
+		mPasses.Inc("skipped")
+	case result.Changed:
+		mPasses.Inc("changed")
+	default:
+		mPasses.Inc("unchanged")
+	}
+	mLastSuccess.Set(float64(time.Now().Unix()))
+}
+
+// observeBackup records a finished backup-once run.
+func observeBackup(started time.Time, err error) {
+	mBackupDuration.Set(time.Since(started).Seconds())
+	if err != nil {
+		mBackups.Inc("failure")
+		return
+	}
+	mBackups.Inc("success")
+	mBackupLastSuccess.Set(float64(time.Now().Unix()))
+}
+
+// serveHTTP serves handler on addr until ctx is done. Listen errors are
+// returned right away so a bad address fails the daemon at startup.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler, log *slog.Logger) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("http.failed", slog.String("addr", addr), slog.String("error", err.Error()))
+		}
+	}()
+	log.Info("http.listening", slog.String("addr", ln.Addr().String()))
+	return nil
+}
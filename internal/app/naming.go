@@ -1,6 +1,10 @@
 package app
 
-import "strings"
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
 
 func sanitizeID(raw string) string {
 	if raw == "" {
@@ -32,3 +36,52 @@ func serviceName(project, service, fallback string, includeProject bool) string
 	}
 	return name
 }
+
+// planIDCollision is a plan skipped because another plan took its ID first,
+// such as the plan db of service app and the plan of service app_db.
+type planIDCollision struct {
+	id        string
+	container string // whose plan is skipped
+	owner     string // the container or project keeping the ID
+}
+
+// claimPlanIDs gives each plan ID to the first plan that derives it, visiting
+// containers by name, and fails every later plan deriving the same ID, in
+// place. The members of a project plan share its ID. Plans that failed to
+// build claim nothing.
+func claimPlanIDs(builds []containerPlans) []planIDCollision {
+	order := make([]int, len(builds))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return builds[order[a]].ctr.Name < builds[order[b]].ctr.Name })
+
+	type claim struct{ source, owner string }
+	claims := map[string]claim{}
+	var collisions []planIDCollision
+	for _, i := range order {
+		cb := &builds[i]
+		for j := range cb.plans {
+			built := &cb.plans[j]
+			if built.ID == "" || built.Err != nil {
+				continue
+			}
+			source, owner := cb.ctr.Name+"/"+built.Name+"/"+built.Volume, "container "+cb.ctr.Name
+			if built.Project != "" {
+				source, owner = "project/"+built.Project, "project "+built.Project
+			}
+			c, taken := claims[built.ID]
+			if !taken {
+				claims[built.ID] = claim{source: source, owner: owner}
+				continue
+			}
+			if c.source == source {
+				continue
+			}
+			built.Err = fmt.Errorf("plan id %s is already taken by %s", built.ID, c.owner)
+			built.Plan = nil
+			collisions = append(collisions, planIDCollision{id: built.ID, container: cb.ctr.Name, owner: c.owner})
+		}
+	}
+	return collisions
+}
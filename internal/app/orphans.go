@@ -0,0 +1,147 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zettaio/backrest-sidecar/internal/model"
+	"github.com/zettaio/backrest-sidecar/internal/state"
+)
+
+// OrphanPolicy controls what happens to sidecar-owned plans whose containers are gone.
+type OrphanPolicy string
+
+const (
+	OrphanKeep    OrphanPolicy = "keep"
+	OrphanDisable OrphanPolicy = "disable"
+	OrphanDelete  OrphanPolicy = "delete"
+)
+
+// ParseOrphanPolicy validates a policy name, defaulting to keep.
+func ParseOrphanPolicy(raw string) (OrphanPolicy, error) {
+	switch OrphanPolicy(strings.ToLower(strings.TrimSpace(raw))) {
+	case "", OrphanKeep:
+		return OrphanKeep, nil
+	case OrphanDisable:
+		return OrphanDisable, nil
+	case OrphanDelete:
+		return OrphanDelete, nil
+	default:
+		return "", fmt.Errorf("invalid orphan policy %q (want keep|disable|delete)", raw)
+	}
+}
+
+// Orphan actions reported in OrphanDecision.
+const (
+	OrphanActionPending  = "pending"
+	OrphanActionKept     = "kept"
+	OrphanActionDisabled = "disabled"
+	OrphanActionDeleted  = "deleted"
+)
+
+// OrphanDecision records what a reconcile pass did with one orphaned plan.
+type OrphanDecision struct {
+	PlanID string    `json:"plan_id"`
+	Since  time.Time `json:"since"`
+	Action string    `json:"action"`
+}
+
+// ownsPlan reports whether the sidecar rendered the plan: its ID carries the
+// plan ID prefix and the state file recorded a render of it, or already
+// tracks it as an orphan (the render of a gone container is forgotten). A
+// hand-written plan that merely uses the prefix is not owned.
+func (r *Reconciler) ownsPlan(st *state.State, id string) bool {
+	prefix := sanitizeID(r.opts.PlanIDPrefix)
+	if prefix == "" || !strings.HasPrefix(id, prefix) {
+		return false
+	}
+	_, rendered := st.Rendered[id]
+	_, orphaned := st.Orphans[id]
+	return rendered || orphaned
+}
+
+// resolveOrphans applies the orphan policy to sidecar-owned plans that no live
+// container renders. It mutates cfg and st and returns the decisions made plus
+// the IDs of plans it changed.
+func (r *Reconciler) resolveOrphans(cfg *model.Config, live map[string]struct{}, st *state.State, now time.Time) ([]OrphanDecision, []string) {
+	if r.opts.OrphanPolicy == "" || r.opts.OrphanPolicy == OrphanKeep {
+		return nil, nil
+	}
+	if sanitizeID(r.opts.PlanIDPrefix) == "" {
+		if !r.orphanPrefixWarned {
+			r.orphanPrefixWarned = true
+			r.log.Warn("orphans.disabled", slog.String("reason", "empty plan id prefix; cannot tell sidecar-owned plans apart"))
+		}
+		return nil, nil
+	}
+	st.EnsureNonNil()
+
+	for id := range st.Orphans {
+		_, alive := live[id]
+		if alive || cfg.FindPlan(id) == nil {
+			delete(st.Orphans, id)
+			r.stateDirty = true
+			if alive {
+				r.log.Info("plan.orphan_cleared", slog.String("plan_id", id))
+			}
+		}
+	}
+
+	ids := make([]string, 0)
+	for _, plan := range cfg.Plans {
+		if _, ok := live[plan.ID]; ok || !r.ownsPlan(st, plan.ID) {
+			continue
+		}
+		ids = append(ids, plan.ID)
+	}
+	sort.Strings(ids)
+
+	decisions := make([]OrphanDecision, 0, len(ids))
+	changed := make([]string, 0, len(ids))
+	for _, id := range ids {
+		since, seen := st.Orphans[id]
+		if !seen {
+			since = now
+			st.Orphans[id] = since
+			r.stateDirty = true
+		}
+		decision := OrphanDecision{PlanID: id, Since: since, Action: OrphanActionPending}
+		if now.Sub(since) >= r.opts.OrphanGrace {
+			switch r.opts.OrphanPolicy {
+			case OrphanDisable:
+				decision.Action = OrphanActionDisabled
+				if cfg.DisablePlan(id) {
+					changed = append(changed, id)
+				}
+			case OrphanDelete:
+				decision.Action = OrphanActionDeleted
+				if cfg.RemovePlan(id) {
+					changed = append(changed, id)
+				}
+				delete(st.Orphans, id)
+				r.stateDirty = true
+			default:
+				decision.Action = OrphanActionKept
+			}
+		}
+		decisions = append(decisions, decision)
+
+		args := []any{
+			slog.String("plan_id", id),
+			slog.String("policy", string(r.opts.OrphanPolicy)),
+			slog.String("action", decision.Action),
+			slog.Time("since", since),
+			slog.Bool("dry_run", r.dryRun),
+		}
+		if !seen || slices.Contains(changed, id) {
+			r.log.Info("plan.orphaned", args...)
+		} else {
+			r.log.Debug("plan.orphaned", args...)
+		}
+	}
+	return decisions, changed
+}
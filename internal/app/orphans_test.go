@@ -0,0 +1,150 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zettaio/backrest-sidecar/internal/config"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+	"github.com/zettaio/backrest-sidecar/internal/state"
+)
+
+func TestResolveOrphansWaitsForGraceBeforeDeleting(t *testing.T) {
+	r := testOrphanReconciler(OrphanDelete, time.Hour)
+	cfg := orphanTestConfig()
+	st := orphanTestState()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	decisions, changed := r.resolveOrphans(cfg, map[string]struct{}{"backrest_sidecar_app": {}}, st, now)
+	if len(changed) != 0 {
+		t.Fatalf("expected no changes inside grace period, got %v", changed)
+	}
+	if len(decisions) != 1 || decisions[0].PlanID != "backrest_sidecar_gone" || decisions[0].Action != OrphanActionPending {
+		t.Fatalf("unexpected decisions: %+v", decisions)
+	}
+	// recordRendered forgets the render once the container is gone
+	delete(st.Rendered, "backrest_sidecar_gone")
+
+	decisions, changed = r.resolveOrphans(cfg, map[string]struct{}{"backrest_sidecar_app": {}}, st, now.Add(2*time.Hour))
+	if len(changed) != 1 || changed[0] != "backrest_sidecar_gone" {
+		t.Fatalf("expected orphan to be deleted after grace, got %v", changed)
+	}
+	if decisions[0].Action != OrphanActionDeleted {
+		t.Fatalf("expected deleted action, got %s", decisions[0].Action)
+	}
+	if cfg.FindPlan("backrest_sidecar_gone") != nil {
+		t.Fatalf("expected orphan plan to be removed")
+	}
+	if cfg.FindPlan("manual-plan") == nil || cfg.FindPlan("backrest_sidecar_manual") == nil {
+		t.Fatalf("plans the sidecar never rendered must never be touched")
+	}
+	if _, ok := st.Orphans["backrest_sidecar_gone"]; ok {
+		t.Fatalf("expected orphan bookkeeping to be cleared after delete")
+	}
+}
+
+func TestResolveOrphansDisablesAndClearsWhenContainerReturns(t *testing.T) {
+	r := testOrphanReconciler(OrphanDisable, 0)
+	cfg := orphanTestConfig()
+	st := orphanTestState()
+	now := time.Now()
+
+	_, changed := r.resolveOrphans(cfg, map[string]struct{}{}, st, now)
+	if len(changed) != 2 {
+		t.Fatalf("expected both sidecar plans disabled, got %v", changed)
+	}
+	plan := cfg.FindPlan("backrest_sidecar_gone")
+	if !plan.Schedule.Disabled || plan.Schedule.Cron != "" {
+		t.Fatalf("expected disabled schedule, got %+v", plan.Schedule)
+	}
+	if cfg.FindPlan("manual-plan").Schedule.Disabled || cfg.FindPlan("backrest_sidecar_manual").Schedule.Disabled {
+		t.Fatalf("manual plans must not be disabled")
+	}
+
+	_, changed = r.resolveOrphans(cfg, map[string]struct{}{}, st, now)
+	if len(changed) != 0 {
+		t.Fatalf("expected already-disabled plans to be left alone, got %v", changed)
+	}
+
+	r.resolveOrphans(cfg, map[string]struct{}{"backrest_sidecar_app": {}}, st, now)
+	if _, ok := st.Orphans["backrest_sidecar_app"]; ok {
+		t.Fatalf("expected orphan entry cleared once the container is back")
+	}
+}
+
+func TestResolveOrphansKeepIsNoop(t *testing.T) {
+	r := testOrphanReconciler(OrphanKeep, 0)
+	cfg := orphanTestConfig()
+	st := orphanTestState()
+	decisions, changed := r.resolveOrphans(cfg, nil, st, time.Now())
+	if decisions != nil || changed != nil || len(st.Orphans) != 0 {
+		t.Fatalf("keep policy should not track or change anything: %+v %v %+v", decisions, changed, st)
+	}
+}
+
+func TestRunLeavesHandWrittenPlansWithThePrefixAlone(t *testing.T) {
+	env := newTestEnv(t, func(o *ReconcileOptions) {
+		o.OrphanPolicy = OrphanDelete
+		o.OrphanGrace = 0
+	}, testAppContainer("app"))
+	seed := `{"modno": 1, "repos": [{"id": "repo-a", "uri": "/repos/a"}], "plans": [
+		{"id": "backrest_sidecar_manual", "repo": "repo-a", "paths": ["/srv"], "schedule": {"cron": "0 1 * * *"}}
+	]}`
+	if err := os.WriteFile(env.path, []byte(seed), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	for pass := 1; pass <= 2; pass++ {
+		result, err := env.r.Run(context.Background())
+		if err != nil {
+			t.Fatalf("pass %d: %v", pass, err)
+		}
+		if result.PlansOrphaned != 0 {
+			t.Fatalf("pass %d: expected no orphans, got %+v", pass, result.Orphans)
+		}
+	}
+	cfg, _, err := config.Load(env.path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.FindPlan("backrest_sidecar_manual") == nil || cfg.FindPlan("backrest_sidecar_app") == nil {
+		t.Fatalf("expected the hand-written plan kept next to the rendered one, got %+v", cfg.Plans)
+	}
+}
+
+func orphanTestConfig() *model.Config {
+	schedule := model.PlanSchedule{Cron: "0 2 * * *", Clock: "CLOCK_LOCAL"}
+	return &model.Config{
+		Plans: []model.Plan{
+			{ID: "backrest_sidecar_app", Repo: "repo", Schedule: schedule},
+			{ID: "backrest_sidecar_gone", Repo: "repo", Schedule: schedule},
+			{ID: "manual-plan", Repo: "repo", Schedule: schedule},
+			// hand-written, but with the sidecar's prefix
+			{ID: "backrest_sidecar_manual", Repo: "repo", Schedule: schedule},
+		},
+	}
+}
+
+// orphanTestState records renders of the sidecar plans in orphanTestConfig.
+func orphanTestState() *state.State {
+	return &state.State{Rendered: map[string]json.RawMessage{
+		"backrest_sidecar_app":  json.RawMessage(`{"id":"backrest_sidecar_app"}`),
+		"backrest_sidecar_gone": json.RawMessage(`{"id":"backrest_sidecar_gone"}`),
+	}}
+}
+
+func testOrphanReconciler(policy OrphanPolicy, grace time.Duration) *Reconciler {
+	return &Reconciler{
+		opts: ReconcileOptions{
+			PlanIDPrefix: "backrest_sidecar_",
+			OrphanPolicy: policy,
+			OrphanGrace:  grace,
+		},
+		log: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
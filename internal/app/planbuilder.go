@@ -24,6 +24,8 @@ type PlanBuilderOptions struct {
 	PlanIDPrefix       string
 	IncludeProjectName bool
 	ExcludeBindMounts  bool
+	// Granularity applies to containers without a backrest.granularity label.
+	Granularity Granularity
 }
 
 // PlanBuilder converts Docker containers into Backrest plans.
@@ -37,18 +39,126 @@ func NewPlanBuilder(opts PlanBuilderOptions) *PlanBuilder {
 }
 
 // Build constructs a plan or returns error if the container cannot be represented.
+// It ignores named plans and volume granularity; BuildAll renders those.
 func (b *PlanBuilder) Build(container docker.Container) (*model.Plan, error) {
-	repo := model.GetLabel(container.Labels, model.LabelRepo, b.opts.DefaultRepo)
+	return b.build(container, planTarget{}, nil)
+}
+
+// BuiltPlan is one plan a container renders to, or why it could not.
+type BuiltPlan struct {
+	// ID is the plan ID, set even when Err is (unless no ID can be derived).
+	ID   string
+	Name string // named plan, "" for the container's single plan
+	// Volume is the volume or mount the plan covers in volume granularity.
+	Volume string
+	// Project is the compose project whose plan this is in project
+	// granularity. Until mergeProjects runs, Plan is only this container's
+	// share of it.
+	Project string
+	Plan    *model.Plan
+	Err     error
+
+	trace *planTrace
+}
+
+// planTarget selects which of a container's plans build renders.
+type planTarget struct {
+	name   string // named plan, "" for the unnamed one
+	volume string // pathUnit key in volume granularity, "" for all paths
+	// project is the compose project in project granularity; the plan is
+	// then the container's share of the project plan.
+	project string
+}
+
+// BuildAll renders every plan of a container: one per backrest.plan.<name>
+// namespace, with the unnamespaced labels as shared defaults, or the single
+// unnamed plan when there are no named plans. In volume granularity each of
+// those splits into one plan per volume or bind mount. In project
+// granularity it is the container's share of its project's plan, which
+// mergeProjects completes.
+func (b *PlanBuilder) BuildAll(container docker.Container) []BuiltPlan {
+	return b.buildAll(container, func() *planTrace { return nil })
+}
+
+// buildAll is BuildAll, tracing each plan into a fresh newTrace().
+func (b *PlanBuilder) buildAll(container docker.Container, newTrace func() *planTrace) []BuiltPlan {
+	if project, err := b.aggregateInto(container); project != "" {
+		target := planTarget{project: project}
+		tr := newTrace()
+		var plan *model.Plan
+		if err == nil {
+			plan, err = b.build(container, target, tr)
+		}
+		return []BuiltPlan{{ID: b.planID(container, target, nil), Project: project, Plan: plan, Err: err, trace: tr}}
+	}
+	names := model.PlanNames(container.Labels)
+	if len(names) == 0 {
+		names = []string{""}
+	}
+	out := make([]BuiltPlan, 0, len(names))
+	for _, name := range names {
+		view := container
+		if name != "" {
+			view.Labels = model.NamedPlanLabels(container.Labels, name)
+		}
+		volumes := b.volumeKeys(view)
+		if len(volumes) == 0 {
+			volumes = []string{""}
+		}
+		for _, volume := range volumes {
+			target := planTarget{name: name, volume: volume}
+			tr := newTrace()
+			plan, err := b.build(view, target, tr)
+			out = append(out, BuiltPlan{
+				ID:     b.planID(view, target, nil),
+				Name:   name,
+				Volume: volume,
+				Plan:   plan,
+				Err:    err,
+				trace:  tr,
+			})
+		}
+	}
+	return out
+}
+
+// build is Build for one target, noting each derivation step in tr (which
+// may be nil). For a named plan, container carries NamedPlanLabels.
+func (b *PlanBuilder) build(container docker.Container, target planTarget, tr *planTrace) (*model.Plan, error) {
+	if target.name != "" {
+		tr.add("plan", "named plan %q: backrest.plan.%s.* labels override the shared ones", target.name, target.name)
+	}
+	tr.labels(container.Labels)
+	spec, err := model.ParseSpec(container.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("container %s: %w", container.Name, err)
+	}
+	granularity, err := b.granularity(container.Labels, spec, tr)
+	if err != nil {
+		return nil, fmt.Errorf("container %s: %w", container.Name, err)
+	}
+	if granularity == GranularityProject && target.project == "" {
+		if target.name != "" {
+			return nil, fmt.Errorf("container %s: named plan %s cannot use project granularity", container.Name, target.name)
+		}
+		tr.add("granularity", "no compose project to merge into; rendering a plan of its own")
+	}
+
+	repo, source := spec.Setting(container.Labels, model.LabelRepo)
+	repo = orDefault(repo, b.opts.DefaultRepo)
+	tr.value("repo", repo, source, model.LabelRepo, tr.defaultRepoSource())
 	if repo == "" {
 		return nil, fmt.Errorf("container %s missing repo label and default repo", container.Name)
 	}
 
-	id := b.planID(container)
+	id := b.planID(container, target, tr)
 	if id == "" {
 		return nil, fmt.Errorf("unable to derive plan id for container %s", container.Name)
 	}
 
-	schedule := model.GetLabel(container.Labels, model.LabelSchedule, b.opts.DefaultSchedule)
+	schedule, source := spec.Setting(container.Labels, model.LabelSchedule)
+	schedule = orDefault(schedule, b.opts.DefaultSchedule)
+	tr.value("schedule", schedule, source, model.LabelSchedule, "--default-schedule")
 	if schedule == "" {
 		return nil, fmt.Errorf("container %s missing schedule label and default", container.Name)
 	}
@@ -56,20 +166,25 @@ func (b *PlanBuilder) Build(container docker.Container) (*model.Plan, error) {
 	if err != nil {
 		return nil, fmt.Errorf("container %s invalid schedule: %w", container.Name, err)
 	}
+	if normalizedSchedule != strings.TrimSpace(schedule) {
+		tr.add("schedule", "minute T randomized per plan id: %s", normalizedSchedule)
+	}
 
-	paths := b.paths(container)
-	if len(paths) == 0 {
+	paths := b.paths(container, spec, target.volume, tr)
+	if len(paths) == 0 && target.project == "" {
 		return nil, fmt.Errorf("container %s has no derived paths; add backrest.paths.include", container.Name)
 	}
 
-	pathsExclude := model.ParseCSV(container.Labels[model.LabelPathsExclude])
+	pathsExclude, source := spec.List(container.Labels, model.LabelPathsExclude)
+	if len(pathsExclude) > 0 {
+		tr.add("exclude", "%s (%s, used as-is)", strings.Join(pathsExclude, ", "), source)
+	}
 
-	hooks := b.buildHooks(container)
+	hooks := b.buildHooks(container, spec, tr)
 
-	retSpec := strings.TrimSpace(container.Labels[model.LabelRetentionKeep])
-	if retSpec == "" {
-		retSpec = strings.TrimSpace(b.opts.DefaultRetention)
-	}
+	retSpec, source := spec.Setting(container.Labels, model.LabelRetentionKeep)
+	retSpec = orDefault(retSpec, strings.TrimSpace(b.opts.DefaultRetention))
+	tr.value("retention", retSpec, source, model.LabelRetentionKeep, "--default-retention")
 	var retention model.PlanRetention
 	retention.RetentionFromSpec(retSpec)
 
@@ -89,29 +204,52 @@ func (b *PlanBuilder) Build(container docker.Container) (*model.Plan, error) {
 	return plan, nil
 }
 
-func (b *PlanBuilder) planID(container docker.Container) string {
-	base := b.basePlanID(container)
+func (b *PlanBuilder) planID(container docker.Container, target planTarget, tr *planTrace) string {
+	var base string
+	if target.project != "" {
+		// namespaced, so a service named like its project keeps its own ID
+		if base = sanitizeID(target.project); base != "" {
+			base = "project_" + base
+		}
+		tr.add("id", "base %q from compose project (project granularity)", base)
+	} else {
+		base = b.basePlanID(container, tr)
+	}
 	if base == "" {
 		return ""
 	}
+	if target.name != "" {
+		base = sanitizeID(base + "_" + target.name)
+		tr.add("id", "suffixed with plan name: %s", base)
+	}
+	if target.volume != "" {
+		base = sanitizeID(base + "_" + target.volume)
+		tr.add("id", "suffixed with volume: %s", base)
+	}
 	if b.opts.PlanIDPrefix == "" {
+		tr.add("id", "%s", base)
 		return base
 	}
-	return sanitizeID(b.opts.PlanIDPrefix + base)
+	id := sanitizeID(b.opts.PlanIDPrefix + base)
+	tr.add("id", "%s (--plan-id-prefix %q)", id, b.opts.PlanIDPrefix)
+	return id
 }
 
-func (b *PlanBuilder) basePlanID(container docker.Container) string {
+func (b *PlanBuilder) basePlanID(container docker.Container, tr *planTrace) string {
 	project := strings.TrimSpace(container.Project)
 	service := strings.TrimSpace(container.Service)
 	var raw string
 	switch {
 	case project != "" && service != "" && b.opts.IncludeProjectName:
 		raw = project + "_" + service
+		tr.add("id", "base %q from compose project and service (--include-project-name)", raw)
 	case service != "":
 		raw = service
+		tr.add("id", "base %q from compose service", raw)
 	default:
 		if container.Name != "" {
 			raw = container.Name
+			tr.add("id", "base %q from container name (no compose service)", raw)
 			break
 		}
 		id := container.ID
@@ -119,13 +257,14 @@ func (b *PlanBuilder) basePlanID(container docker.Container) string {
 			id = id[:12]
 		}
 		raw = id
+		tr.add("id", "base %q from container id (no compose service or name)", raw)
 	}
 	return sanitizeID(raw)
 }
 
-func (b *PlanBuilder) buildHooks(container docker.Container) []model.PlanHook {
-	startCmds := model.ParseCSV(container.Labels[model.LabelHookSnapshotStart])
-	endCmds := model.ParseCSV(container.Labels[model.LabelHookSnapshotEnd])
+func (b *PlanBuilder) buildHooks(container docker.Container, spec *model.Spec, tr *planTrace) []model.PlanHook {
+	startCmds, startSource := spec.List(container.Labels, model.LabelHookSnapshotStart)
+	endCmds, endSource := spec.List(container.Labels, model.LabelHookSnapshotEnd)
 	hooks := make([]model.PlanHook, 0, len(startCmds)+len(endCmds)+2)
 	for _, cmd := range startCmds {
 		hooks = append(hooks, model.PlanHook{
@@ -139,10 +278,16 @@ func (b *PlanBuilder) buildHooks(container docker.Container) []model.PlanHook {
 			ActionCommand: model.HookCommand{Command: cmd},
 		})
 	}
-	if len(hooks) == 0 {
-		if templHooks := b.templateHooks(strings.TrimSpace(container.Labels[model.LabelHooksTemplate]), container); len(templHooks) > 0 {
-			hooks = append(hooks, templHooks...)
-		}
+	if len(hooks) > 0 {
+		tr.add("hooks", "%d start command(s) (%s), %d end command(s) (%s)", len(startCmds), orDefault(startSource, "none"), len(endCmds), orDefault(endSource, "none"))
+		return hooks
+	}
+	template, source := spec.Setting(container.Labels, model.LabelHooksTemplate)
+	if templHooks := b.templateHooks(template, container); len(templHooks) > 0 {
+		hooks = append(hooks, templHooks...)
+		tr.add("hooks", "template %q (%s)", template, source)
+	} else if template != "" {
+		tr.add("hooks", "none: template %q is unknown or empty", template)
 	}
 	return hooks
 }
@@ -182,31 +327,67 @@ func preferContainerName(container docker.Container) string {
 	return id
 }
 
-func (b *PlanBuilder) paths(container docker.Container) []string {
-	if labels := model.ParseCSV(container.Labels[model.LabelPathsInclude]); len(labels) > 0 {
-		return b.rewriteLabeledPaths(labels, container.Mounts)
+// paths is the plan's host paths: every unit's, or only the unit with key
+// volume when it is set.
+func (b *PlanBuilder) paths(container docker.Container, spec *model.Spec, volume string, tr *planTrace) []string {
+	var paths []string
+	for _, u := range b.pathUnits(container, spec, tr) {
+		if volume == "" || u.key == volume {
+			paths = append(paths, u.paths...)
+		}
+	}
+	if volume != "" {
+		tr.add("volume", "plan covers %s: %s", volume, strings.Join(paths, ", "))
+	}
+	return unique(paths)
+}
+
+// pathUnits derives the host paths to back up, grouped by the volume or
+// bind mount each came from.
+func (b *PlanBuilder) pathUnits(container docker.Container, spec *model.Spec, tr *planTrace) []pathUnit {
+	var units []pathUnit
+	if includes, source := spec.List(container.Labels, model.LabelPathsInclude); len(includes) > 0 {
+		tr.add("paths", "from %s, mapped through the container's mounts", source)
+		for _, p := range includes {
+			rewritten, m := b.mountForLabel(p, container.Mounts, tr)
+			if rewritten == "" {
+				if p != "" {
+					units = addPathUnit(units, container, nil, p, p)
+					tr.add("include", "%s -> %s (no mount covers it, used as-is)", p, p)
+				}
+				continue
+			}
+			units = addPathUnit(units, container, m, p, rewritten)
+		}
+		return units
 	}
 	if len(container.Mounts) == 0 {
+		tr.add("paths", "none: no %s label and no mounts", model.LabelPathsInclude)
 		return nil
 	}
-	paths := make([]string, 0, len(container.Mounts))
-	for _, m := range container.Mounts {
+	tr.add("paths", "from every mount (no %s label)", model.LabelPathsInclude)
+	for i, m := range container.Mounts {
 		switch m.Type {
 		case mount.TypeBind:
 			if b.opts.ExcludeBindMounts {
+				tr.mount(m, "skipped (--exclude-bind-mounts)")
 				continue
 			}
 			if m.Source != "" {
-				paths = append(paths, m.Source)
+				units = addPathUnit(units, container, &container.Mounts[i], "", m.Source)
+				tr.mount(m, "-> "+m.Source)
 			}
 		case mount.TypeVolume:
 			if m.Name != "" {
 				hostPath := filepath.Join(b.opts.DockerRoot, "volumes", m.Name, "_data")
-				paths = append(paths, b.rewriteVolumePath(hostPath))
+				units = addPathUnit(units, container, &container.Mounts[i], "", b.rewriteVolumePath(hostPath))
+				tr.mount(m, "-> "+b.describeVolumePath(hostPath))
 			}
+		default:
+			tr.mount(m, "skipped (only bind mounts and volumes are backed up)")
 		}
 	}
-	return unique(paths)
+	return units
 }
 
 func (b *PlanBuilder) rewriteVolumePath(path string) string {
@@ -221,22 +402,12 @@ func (b *PlanBuilder) rewriteVolumePath(path string) string {
 	return filepath.Join(b.opts.VolumePrefix, rel)
 }
 
-func (b *PlanBuilder) rewriteLabeledPaths(paths []string, mounts []dockertypes.MountPoint) []string {
-	if len(paths) == 0 {
-		return paths
+// describeVolumePath is the volume path as written, noting a --volume-prefix rewrite.
+func (b *PlanBuilder) describeVolumePath(hostPath string) string {
+	if rewritten := b.rewriteVolumePath(hostPath); rewritten != hostPath {
+		return fmt.Sprintf("%s (%s with --volume-prefix)", rewritten, hostPath)
 	}
-	out := make([]string, 0, len(paths))
-	for _, p := range paths {
-		rewritten := b.hostPathForLabel(p, mounts)
-		if rewritten == "" {
-			if p != "" {
-				out = append(out, p)
-			}
-			continue
-		}
-		out = append(out, rewritten)
-	}
-	return unique(out)
+	return hostPath
 }
 
 func (b *PlanBuilder) normalizeSchedule(schedule, planID string) (string, error) {
@@ -267,39 +438,53 @@ func randomMinuteForPlan(planID string) int {
 	return int(hasher.Sum32() % 60)
 }
 
-func (b *PlanBuilder) hostPathForLabel(path string, mounts []dockertypes.MountPoint) string {
+func (b *PlanBuilder) hostPathForLabel(path string, mounts []dockertypes.MountPoint, tr *planTrace) string {
+	hostPath, _ := b.mountForLabel(path, mounts, tr)
+	return hostPath
+}
+
+// mountForLabel maps a container path to its host path and the mount that
+// covers it, or "" and nil when none does.
+func (b *PlanBuilder) mountForLabel(path string, mounts []dockertypes.MountPoint, tr *planTrace) (string, *dockertypes.MountPoint) {
 	cleanLabel := filepath.Clean(path)
-	for _, m := range mounts {
+	for i, m := range mounts {
 		target := filepath.Clean(m.Destination)
 		if target == "." || target == "" {
 			continue
 		}
 		rel, ok := relWithin(cleanLabel, target)
 		if !ok {
+			tr.mount(m, fmt.Sprintf("does not contain %s", path))
 			continue
 		}
 		switch m.Type {
 		case mount.TypeVolume:
 			if m.Name == "" {
+				tr.mount(m, "contains "+path+" but the volume has no name")
 				continue
 			}
 			hostPath := filepath.Join(b.opts.DockerRoot, "volumes", m.Name, "_data")
 			if rel != "" {
 				hostPath = filepath.Join(hostPath, rel)
 			}
-			return b.rewriteVolumePath(hostPath)
+			tr.add("include", "%s -> %s", path, b.describeVolumePath(hostPath))
+			return b.rewriteVolumePath(hostPath), &mounts[i]
 		case mount.TypeBind:
 			if m.Source == "" {
+				tr.mount(m, "contains "+path+" but has no source")
 				continue
 			}
 			hostPath := m.Source
 			if rel != "" {
 				hostPath = filepath.Join(hostPath, rel)
 			}
-			return hostPath
+			tr.add("include", "%s -> %s", path, hostPath)
+			return hostPath, &mounts[i]
+		default:
+			tr.mount(m, fmt.Sprintf("contains %s but is a %s mount", path, m.Type))
 		}
 	}
-	return ""
+	return "", nil
 }
 
 func relWithin(path, base string) (string, bool) {
@@ -321,6 +506,13 @@ func relWithin(path, base string) (string, bool) {
 	return "", false
 }
 
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
 func unique(items []string) []string {
 	if len(items) == 0 {
 		return items
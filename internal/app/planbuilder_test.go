@@ -172,3 +172,226 @@ func TestPlanBuilderScheduleRandomMinuteFromDefault(t *testing.T) {
 		t.Fatalf("hour mutated: got %s want 4", fields[1])
 	}
 }
+
+func TestPlanBuilderMergesSpecLabelUnderFlatLabels(t *testing.T) {
+	b := NewPlanBuilder(PlanBuilderOptions{
+		DockerRoot:      "/var/lib/docker",
+		DefaultRepo:     "default-repo",
+		DefaultSchedule: "0 2 * * *",
+	})
+	ctr := docker.Container{
+		Name: "db",
+		Labels: map[string]string{
+			model.LabelSchedule: "0 4 * * *",
+			model.LabelSpec: `{
+				"repo": "spec-repo",
+				"schedule": "0 3 * * *",
+				"keep": {"daily": 7, "weekly": 2},
+				"paths": {"include": ["/data/a,b"], "exclude": ["*.tmp", "cache, old"]},
+				"hooks": {"snapshot-start": ["pg_dump -t a,b -f /data/a,b/dump.sql"]}
+			}`,
+		},
+		Mounts: []dockertypes.MountPoint{{Type: mount.TypeVolume, Name: "db-data", Destination: "/data"}},
+	}
+	plan, err := b.Build(ctr)
+	if err != nil {
+		t.Fatalf("build plan: %v", err)
+	}
+	if plan.Repo != "spec-repo" || plan.Schedule.Cron != "0 4 * * *" {
+		t.Fatalf("expected the spec repo and the label schedule, got repo=%s cron=%s", plan.Repo, plan.Schedule.Cron)
+	}
+	if got := strings.Join(plan.Paths, "|"); got != "/var/lib/docker/volumes/db-data/_data/a,b" {
+		t.Fatalf("unexpected paths %q", got)
+	}
+	if got := strings.Join(plan.PathsExclude, "|"); got != "*.tmp|cache, old" {
+		t.Fatalf("unexpected excludes %q", got)
+	}
+	if len(plan.Hooks) != 1 || plan.Hooks[0].ActionCommand.Command != "pg_dump -t a,b -f /data/a,b/dump.sql" {
+		t.Fatalf("expected the comma-carrying hook intact, got %+v", plan.Hooks)
+	}
+	if b := plan.Retention.PolicyTimeBucketed; b == nil || b.Daily != 7 || b.Weekly != 2 {
+		t.Fatalf("expected the object keep spec, got %+v", plan.Retention)
+	}
+
+	ctr.Labels[model.LabelSpec] = `{"paths": {"includes": ["/data"]}}`
+	if _, err := b.Build(ctr); err == nil || !strings.Contains(err.Error(), `unknown field "includes"`) {
+		t.Fatalf("expected an unknown spec field to fail, got %v", err)
+	}
+}
+
+func TestPlanBuilderBuildsNamedPlansOverSharedLabels(t *testing.T) {
+	b := NewPlanBuilder(PlanBuilderOptions{
+		DockerRoot:      "/var/lib/docker",
+		DefaultRepo:     "default-repo",
+		DefaultSchedule: "0 2 * * *",
+		PlanIDPrefix:    "backrest_sidecar_",
+	})
+	ctr := docker.Container{
+		Name: "db",
+		Labels: map[string]string{
+			model.LabelRepo:                              "shared-repo",
+			model.LabelRetentionKeep:                     "daily=7",
+			model.LabelPlanPrefix + "dump.paths.include": "/data/dump",
+			model.LabelPlanPrefix + "dump.schedule":      "0 * * * *",
+			model.LabelPlanPrefix + "full.repo":          "offsite",
+			model.LabelPlanPrefix + "full.keep":          "weekly=4",
+		},
+		Mounts: []dockertypes.MountPoint{{Type: mount.TypeVolume, Name: "db-data", Destination: "/data"}},
+	}
+	built := b.BuildAll(ctr)
+	if len(built) != 2 {
+		t.Fatalf("expected two named plans, got %+v", built)
+	}
+	dump, full := built[0], built[1]
+	if dump.Err != nil || full.Err != nil {
+		t.Fatalf("build plans: %v, %v", dump.Err, full.Err)
+	}
+	if dump.Name != "dump" || dump.ID != "backrest_sidecar_db_dump" || full.ID != "backrest_sidecar_db_full" {
+		t.Fatalf("unexpected plan ids %s, %s", dump.ID, full.ID)
+	}
+	if dump.Plan.Repo != "shared-repo" || dump.Plan.Schedule.Cron != "0 * * * *" {
+		t.Fatalf("expected the shared repo and the named schedule, got repo=%s cron=%s", dump.Plan.Repo, dump.Plan.Schedule.Cron)
+	}
+	if got := strings.Join(dump.Plan.Paths, "|"); got != "/var/lib/docker/volumes/db-data/_data/dump" {
+		t.Fatalf("unexpected dump paths %q", got)
+	}
+	if full.Plan.Repo != "offsite" || full.Plan.Schedule.Cron != "0 2 * * *" {
+		t.Fatalf("expected the named repo and the default schedule, got repo=%s cron=%s", full.Plan.Repo, full.Plan.Schedule.Cron)
+	}
+	if got := strings.Join(full.Plan.Paths, "|"); got != "/var/lib/docker/volumes/db-data/_data" {
+		t.Fatalf("unexpected full paths %q", got)
+	}
+	if r := dump.Plan.Retention.PolicyTimeBucketed; r == nil || r.Daily != 7 {
+		t.Fatalf("expected the shared keep on the dump plan, got %+v", dump.Plan.Retention)
+	}
+	if r := full.Plan.Retention.PolicyTimeBucketed; r == nil || r.Weekly != 4 || r.Daily != 0 {
+		t.Fatalf("expected the named keep on the full plan, got %+v", full.Plan.Retention)
+	}
+}
+
+func TestPlanBuilderNamedSpecBeatsSharedLabels(t *testing.T) {
+	b := NewPlanBuilder(PlanBuilderOptions{
+		DockerRoot:      "/var/lib/docker",
+		DefaultSchedule: "0 2 * * *",
+		PlanIDPrefix:    "backrest_sidecar_",
+	})
+	ctr := docker.Container{
+		Name: "db",
+		Labels: map[string]string{
+			model.LabelRepo:                         "shared-repo",
+			model.LabelSchedule:                     "0 3 * * *",
+			model.LabelPathsExclude:                 "*.tmp",
+			model.LabelPlanPrefix + "dump.spec":     `{"schedule": "0 * * * *", "paths": {"exclude": ["*.log"]}}`,
+			model.LabelPlanPrefix + "dump.keep":     "daily=2",
+			model.LabelPlanPrefix + "full.schedule": "0 4 * * *",
+		},
+		Mounts: []dockertypes.MountPoint{{Type: mount.TypeVolume, Name: "db-data", Destination: "/data"}},
+	}
+	built := b.BuildAll(ctr)
+	if len(built) != 2 || built[0].Err != nil || built[1].Err != nil {
+		t.Fatalf("expected two named plans, got %+v", built)
+	}
+	dump := built[0].Plan
+	if dump.Schedule.Cron != "0 * * * *" || strings.Join(dump.PathsExclude, "|") != "*.log" {
+		t.Fatalf("expected the named spec over the shared labels, got cron=%s exclude=%v", dump.Schedule.Cron, dump.PathsExclude)
+	}
+	if dump.Repo != "shared-repo" {
+		t.Fatalf("expected the shared repo where the named spec is silent, got %s", dump.Repo)
+	}
+	if full := built[1].Plan; full.Schedule.Cron != "0 4 * * *" || strings.Join(full.PathsExclude, "|") != "*.tmp" {
+		t.Fatalf("expected the other plan to keep the shared labels, got cron=%s exclude=%v", full.Schedule.Cron, full.PathsExclude)
+	}
+}
+
+func TestPlanBuilderSplitsVolumeGranularityPerMount(t *testing.T) {
+	b := NewPlanBuilder(PlanBuilderOptions{
+		DockerRoot:      "/var/lib/docker",
+		DefaultRepo:     "repo-a",
+		DefaultSchedule: "T 2 * * *",
+		PlanIDPrefix:    "backrest_sidecar_",
+		Granularity:     GranularityVolume,
+	})
+	ctr := docker.Container{
+		Name:    "myapp-web-1",
+		Project: "myapp",
+		Service: "web",
+		Labels:  map[string]string{},
+		Mounts: []dockertypes.MountPoint{
+			{Type: mount.TypeVolume, Name: "myapp_uploads", Destination: "/srv/uploads"},
+			{Type: mount.TypeVolume, Name: "myapp_cache", Destination: "/srv/cache"},
+			{Type: mount.TypeBind, Source: "/opt/myapp/conf", Destination: "/etc/app"},
+		},
+	}
+	built := b.BuildAll(ctr)
+	want := map[string]string{
+		"backrest_sidecar_web_uploads": "/var/lib/docker/volumes/myapp_uploads/_data",
+		"backrest_sidecar_web_cache":   "/var/lib/docker/volumes/myapp_cache/_data",
+		"backrest_sidecar_web_etc_app": "/opt/myapp/conf",
+	}
+	if len(built) != len(want) {
+		t.Fatalf("expected one plan per mount, got %+v", built)
+	}
+	for _, p := range built {
+		if p.Err != nil {
+			t.Fatalf("build %s: %v", p.ID, p.Err)
+		}
+		if got := strings.Join(p.Plan.Paths, "|"); want[p.ID] != got {
+			t.Fatalf("plan %s: unexpected paths %q", p.ID, got)
+		}
+	}
+	if again := b.BuildAll(ctr); again[0].ID != built[0].ID || again[0].Plan.Schedule.Cron != built[0].Plan.Schedule.Cron {
+		t.Fatalf("expected deterministic plans, got %s then %s", built[0].ID, again[0].ID)
+	}
+
+	// labelled includes group by the mount that covers them
+	ctr.Labels[model.LabelPathsInclude] = "/srv/uploads/a,/srv/uploads/b,/etc/app"
+	built = b.BuildAll(ctr)
+	if len(built) != 2 || built[0].ID != "backrest_sidecar_web_uploads" || len(built[0].Plan.Paths) != 2 {
+		t.Fatalf("expected the uploads includes in one plan, got %+v", built)
+	}
+
+	ctr.Labels[model.LabelGranularity] = "container"
+	if built := b.BuildAll(ctr); len(built) != 1 || built[0].ID != "backrest_sidecar_web" {
+		t.Fatalf("expected the label to override --plan-granularity, got %+v", built)
+	}
+	ctr.Labels[model.LabelGranularity] = "disk"
+	if built := b.BuildAll(ctr); len(built) != 1 || built[0].Err == nil || !strings.Contains(built[0].Err.Error(), `invalid plan granularity "disk"`) {
+		t.Fatalf("expected an invalid granularity to fail, got %+v", built)
+	}
+}
+
+func TestPlanBuilderSuffixesCollidingVolumeKeys(t *testing.T) {
+	b := NewPlanBuilder(PlanBuilderOptions{
+		DockerRoot:      "/var/lib/docker",
+		DefaultRepo:     "repo-a",
+		DefaultSchedule: "0 2 * * *",
+		PlanIDPrefix:    "backrest_sidecar_",
+		Granularity:     GranularityVolume,
+	})
+	ctr := docker.Container{
+		Name:    "myapp-web-1",
+		Project: "myapp",
+		Service: "web",
+		Labels:  map[string]string{},
+		Mounts: []dockertypes.MountPoint{
+			{Type: mount.TypeVolume, Name: "myapp_data", Destination: "/srv/data"},
+			{Type: mount.TypeBind, Source: "/opt/myapp/data", Destination: "/data"},
+		},
+	}
+	built := b.BuildAll(ctr)
+	if len(built) != 2 {
+		t.Fatalf("expected the volume and the bind mount in separate plans, got %+v", built)
+	}
+	want := map[string]string{
+		"backrest_sidecar_web_data":   "/var/lib/docker/volumes/myapp_data/_data",
+		"backrest_sidecar_web_data_2": "/opt/myapp/data",
+	}
+	for _, p := range built {
+		if p.Err != nil {
+			t.Fatalf("build %s: %v", p.ID, p.Err)
+		}
+		if got := strings.Join(p.Plan.Paths, "|"); want[p.ID] != got {
+			t.Fatalf("plan %s: unexpected paths %q", p.ID, got)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+)
+
+// planCache remembers the plans each container rendered to, keyed by a
+// fingerprint of everything BuildAll reads, so a pass only rebuilds
+// containers whose labels or mounts moved.
+type planCache struct {
+	options [32]byte // builder options the entries were rendered with
+	entries map[string]cachedPlan
+	// built counts BuildAll calls, for tests.
+	built int
+}
+
+type cachedPlan struct {
+	input [32]byte
+	plans []BuiltPlan
+}
+
+// build returns the container's plans, from the cache when its input is
+// unchanged. Callers get their own copies to normalize or merge into.
+func (c *planCache) build(b *PlanBuilder, ctr docker.Container) []BuiltPlan {
+	if opts := fingerprintJSON(b.opts); opts != c.options || c.entries == nil {
+		// e.g. the default repo fell back to another repo from the config
+		c.options = opts
+		c.entries = make(map[string]cachedPlan)
+	}
+	input := containerFingerprint(ctr)
+	entry, ok := c.entries[ctr.ID]
+	if !ok || entry.input != input {
+		entry = cachedPlan{input: input, plans: b.BuildAll(ctr)}
+		c.built++
+		c.entries[ctr.ID] = entry
+	}
+	out := make([]BuiltPlan, len(entry.plans))
+	for i, built := range entry.plans {
+		out[i] = built
+		if built.Plan != nil {
+			plan := built.Plan.Clone()
+			out[i].Plan = &plan
+		}
+	}
+	return out
+}
+
+// listContainers lists the enabled containers. Given the IDs of containers
+// events named, it lists only those again and takes the rest from the last
+// listing; a container that is gone or no longer enabled drops out. Passes
+// without IDs list everything, catching whatever the events missed.
+func (r *Reconciler) listContainers(ctx context.Context, changed []string) ([]docker.Container, error) {
+	if len(changed) == 0 || r.listed == nil {
+		containers, err := r.client.ListBackrestEnabled(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list containers: %w", err)
+		}
+		r.listed = containers
+		return containers, nil
+	}
+	fresh, err := r.client.ListBackrestEnabledByID(ctx, changed)
+	if err != nil {
+		return nil, fmt.Errorf("list containers %s: %w", strings.Join(changed, ", "), err)
+	}
+	byID := make(map[string]docker.Container, len(fresh))
+	for _, ctr := range fresh {
+		byID[ctr.ID] = ctr
+	}
+	named := make(map[string]struct{}, len(changed))
+	for _, id := range changed {
+		named[id] = struct{}{}
+	}
+	containers := make([]docker.Container, 0, len(r.listed)+len(fresh))
+	for _, ctr := range r.listed {
+		if _, ok := named[ctr.ID]; !ok {
+			containers = append(containers, ctr)
+			continue
+		}
+		if updated, ok := byID[ctr.ID]; ok {
+			containers = append(containers, updated)
+			delete(byID, ctr.ID)
+		}
+	}
+	// new containers, in the order they were listed
+	for _, ctr := range fresh {
+		if _, ok := byID[ctr.ID]; ok {
+			containers = append(containers, ctr)
+		}
+	}
+	r.listed = containers
+	return containers, nil
+}
+
+// prune drops containers that were not listed this pass.
+func (c *planCache) prune(containers []docker.Container) {
+	seen := make(map[string]struct{}, len(containers))
+	for _, ctr := range containers {
+		seen[ctr.ID] = struct{}{}
+	}
+	for id := range c.entries {
+		if _, ok := seen[id]; !ok {
+			delete(c.entries, id)
+		}
+	}
+}
+
+// containerFingerprint hashes the container fields plan synthesis depends on.
+// Run state is left out: stopped containers render the same plan.
+func containerFingerprint(ctr docker.Container) [32]byte {
+	return fingerprintJSON(struct {
+		ID, Name, Project, Service string
+		Labels                     map[string]string
+		Mounts                     any
+	}{ctr.ID, ctr.Name, ctr.Project, ctr.Service, ctr.Labels, ctr.Mounts})
+}
+
+// fingerprintJSON hashes v's JSON encoding; maps encode with sorted keys.
+func fingerprintJSON(v any) [32]byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return [32]byte{}
+	}
+	return sha256.Sum256(data)
+}
+
+// passMemo describes the last pass that changed nothing. While the container
+// list, the builder options and the config and state files are all as they
+// were then, another pass would change nothing either and is skipped without
+// touching the files.
+type passMemo struct {
+	containers [32]byte
+	config     fileStamp
+	state      fileStamp
+	result     ReconcileResult
+}
+
+// fileStamp identifies a file revision without reading it.
+type fileStamp struct {
+	exists  bool
+	size    int64
+	modTime int64
+}
+
+func stampFile(path string) (fileStamp, error) {
+	if path == "" {
+		return fileStamp{}, nil
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileStamp{}, nil
+	}
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{exists: true, size: info.Size(), modTime: info.ModTime().UnixNano()}, nil
+}
+
+// passFingerprint covers the listed containers (in any order) and the
+// builder options.
+func passFingerprint(b *PlanBuilder, containers []docker.Container) [32]byte {
+	sums := make([][32]byte, 0, len(containers))
+	for _, ctr := range containers {
+		sums = append(sums, containerFingerprint(ctr))
+	}
+	sort.Slice(sums, func(i, j int) bool { return bytes.Compare(sums[i][:], sums[j][:]) < 0 })
+	h := sha256.New()
+	for _, sum := range sums {
+		h.Write(sum[:])
+	}
+	opts := fingerprintJSON(b.opts)
+	h.Write(opts[:])
+	var out [32]byte
+	h.Sum(out[:0])
+	return out
+}
+
+// unchanged reports whether the memoized pass still holds.
+func (r *Reconciler) unchanged(key [32]byte) bool {
+	memo := r.memo
+	if memo == nil || memo.containers != key {
+		return false
+	}
+	cfg, err := stampFile(r.cfgPath)
+	if err != nil || cfg != memo.config {
+		return false
+	}
+	st, err := stampFile(r.statePath)
+	return err == nil && st == memo.state
+}
+
+// memoize records a pass that changed nothing. Passes that are waiting on a
+// clock (an orphan grace period) are not memoized.
+func (r *Reconciler) memoize(key [32]byte, out *passOutcome) {
+	r.memo = nil
+	if out.result.Changed || !out.stamped {
+		return
+	}
+	for _, orphan := range out.result.Orphans {
+		if orphan.Action == OrphanActionPending {
+			return
+		}
+	}
+	st, err := stampFile(r.statePath)
+	if err != nil {
+		return
+	}
+	r.memo = &passMemo{containers: key, config: out.configStamp, state: st, result: *out.result}
+}
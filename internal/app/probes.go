@@ -0,0 +1,182 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/zettaio/backrest-sidecar/internal/config"
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+)
+
+// passStatus is what the daemon loop reports about its reconcile passes.
+type passStatus struct {
+	mu       sync.Mutex
+	started  time.Time
+	lastPass time.Time
+	lastErr  error
+	passes   int
+	// last is the most recent successful pass's result.
+	last *ReconcileResult
+}
+
+func newPassStatus(now time.Time) *passStatus {
+	return &passStatus{started: now}
+}
+
+// record notes a finished pass.
+func (s *passStatus) record(now time.Time, result *ReconcileResult, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastPass = now
+	s.lastErr = err
+	s.passes++
+	if err == nil {
+		s.last = result
+	}
+}
+
+func (s *passStatus) result() *ReconcileResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+func (s *passStatus) get() (started, lastPass time.Time, lastErr error, passes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.started, s.lastPass, s.lastErr, s.passes
+}
+
+// probes serves /healthz and /readyz for the daemon.
+//
+// /healthz answers whether the loop is alive: a pass finished (successfully
+// or not) within maxAge. /readyz answers whether the sidecar can do its job:
+// Docker answers, config.json parses and the last pass succeeded.
+type probes struct {
+	status  *passStatus
+	maxAge  time.Duration
+	client  docker.API
+	cfgPath string
+	events  *eventStream // nil without --with-events
+	timeout time.Duration
+	now     func() time.Time
+}
+
+// probeReport is the JSON body of both endpoints.
+type probeReport struct {
+	Status        string            `json:"status"`
+	Checks        map[string]string `json:"checks"`
+	LastReconcile *time.Time        `json:"last_reconcile,omitempty"`
+	AgeSeconds    float64           `json:"last_reconcile_age_seconds"`
+}
+
+func (p *probes) register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", p.healthz)
+	mux.HandleFunc("/readyz", p.readyz)
+}
+
+func (p *probes) healthz(w http.ResponseWriter, _ *http.Request) {
+	report, _ := p.liveness()
+	writeProbe(w, report)
+}
+
+// liveness fills the loop checks shared by both endpoints.
+func (p *probes) liveness() (*probeReport, error) {
+	started, lastPass, lastErr, _ := p.status.get()
+	report := &probeReport{Status: "ok", Checks: map[string]string{}}
+	since := started
+	if !lastPass.IsZero() {
+		report.LastReconcile = &lastPass
+		since = lastPass
+	}
+	age := p.now().Sub(since)
+	report.AgeSeconds = age.Seconds()
+	report.Checks["reconcile_loop"] = "ok"
+	if p.maxAge > 0 && age > p.maxAge {
+		report.fail("reconcile_loop", fmt.Sprintf("no pass finished for %s (limit %s)", age.Round(time.Second), p.maxAge))
+	}
+	return report, lastErr
+}
+
+func (p *probes) readyz(w http.ResponseWriter, r *http.Request) {
+	report, lastErr := p.liveness()
+	_, _, _, passes := p.status.get()
+	switch {
+	case passes == 0:
+		report.fail("last_reconcile", "no pass finished yet")
+	case lastErr != nil:
+		report.fail("last_reconcile", lastErr.Error())
+	default:
+		report.Checks["last_reconcile"] = "ok"
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), p.timeout)
+	defer cancel()
+	if err := p.client.Ping(ctx); err != nil {
+		report.fail("docker", err.Error())
+	} else {
+		report.Checks["docker"] = "ok"
+	}
+	if _, _, err := config.Load(p.cfgPath); err != nil {
+		report.fail("config", err.Error())
+	} else {
+		report.Checks["config"] = "ok"
+	}
+	// the daemon still polls while the stream is down, so this only informs
+	if p.events != nil {
+		if up, err := p.events.Connected(); up {
+			report.Checks["docker_events"] = "ok"
+		} else if err != nil {
+			report.Checks["docker_events"] = "disconnected: " + err.Error()
+		} else {
+			report.Checks["docker_events"] = "disconnected"
+		}
+	}
+	writeProbe(w, report)
+}
+
+func (r *probeReport) fail(check, reason string) {
+	r.Status = "fail"
+	r.Checks[check] = reason
+}
+
+func writeProbe(w http.ResponseWriter, report *probeReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// Probe requests a daemon health endpoint ("/healthz" or "/readyz") and
+// fails unless it answers 200.
+func Probe(ctx context.Context, addr, path string) error {
+	url := "http://" + probeHost(addr) + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var report probeReport
+	_ = json.NewDecoder(resp.Body).Decode(&report)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s %v", path, resp.Status, report.Checks)
+	}
+	return nil
+}
+
+// probeHost turns a listen address such as ":9465" into one to dial.
+func probeHost(addr string) string {
+	if len(addr) > 0 && addr[0] == ':' {
+		return "127.0.0.1" + addr
+	}
+	return addr
+}
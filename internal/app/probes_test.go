@@ -0,0 +1,66 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker/dockertest"
+)
+
+func TestProbesReportLoopAgeAndReadiness(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	path := writeTestConfig(t)
+	now := time.Now()
+	status := newPassStatus(now)
+	p := &probes{
+		status:  status,
+		maxAge:  time.Minute,
+		client:  engine.Client(t),
+		cfgPath: path,
+		timeout: time.Second,
+		now:     func() time.Time { return now },
+	}
+	mux := http.NewServeMux()
+	p.register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	ctx := context.Background()
+
+	if err := Probe(ctx, addr, "/healthz"); err != nil {
+		t.Fatalf("expected a fresh daemon to be alive: %v", err)
+	}
+	if err := Probe(ctx, addr, "/readyz"); err == nil || !strings.Contains(err.Error(), "no pass finished yet") {
+		t.Fatalf("expected not ready before the first pass, got %v", err)
+	}
+
+	status.record(now, nil, nil)
+	if err := Probe(ctx, addr, "/readyz"); err != nil {
+		t.Fatalf("expected ready after a good pass: %v", err)
+	}
+
+	status.record(now, nil, errors.New("list containers: boom"))
+	if err := Probe(ctx, addr, "/readyz"); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected the failed pass to surface, got %v", err)
+	}
+	status.record(now, nil, nil)
+
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatalf("corrupt config: %v", err)
+	}
+	if err := Probe(ctx, addr, "/readyz"); err == nil || !strings.Contains(err.Error(), "parse config") {
+		t.Fatalf("expected the corrupt config to surface, got %v", err)
+	}
+
+	// a loop stuck for longer than maxAge is no longer alive
+	now = now.Add(2 * time.Minute)
+	if err := Probe(ctx, addr, "/healthz"); err == nil || !strings.Contains(err.Error(), "no pass finished for 2m0s") {
+		t.Fatalf("expected a stale loop to fail liveness, got %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+)
+
+// ProjectConflict is a setting the containers of a project plan disagree
+// on. The first container in service order that sets it explicitly wins.
+type ProjectConflict struct {
+	Project string `json:"project"`
+	PlanID  string `json:"plan_id"`
+	Label   string `json:"label"`
+	Value   string `json:"value"`
+	From    string `json:"from"`
+	// Ignored maps each overruled container to its value.
+	Ignored map[string]string `json:"ignored"`
+}
+
+func (c ProjectConflict) String() string {
+	others := make([]string, 0, len(c.Ignored))
+	for _, name := range sortedNames(c.Ignored) {
+		others = append(others, fmt.Sprintf("%s=%q", name, c.Ignored[name]))
+	}
+	return fmt.Sprintf("%s: using %q from %s, ignoring %s", c.Label, c.Value, c.From, strings.Join(others, ", "))
+}
+
+// containerPlans is what BuildAll made of one container.
+type containerPlans struct {
+	ctr   docker.Container
+	plans []BuiltPlan
+}
+
+// projectMember is one container's share of a project plan.
+type projectMember struct {
+	ctr   docker.Container
+	built *BuiltPlan
+}
+
+// aggregateInto returns the compose project whose plan a container merges
+// into, or "" when it renders its own plans. Spec and granularity errors are
+// left for build to report.
+func (b *PlanBuilder) aggregateInto(container docker.Container) (string, error) {
+	spec, err := model.ParseSpec(container.Labels)
+	if err != nil {
+		return "", nil
+	}
+	if g, err := b.granularity(container.Labels, spec, nil); err != nil || g != GranularityProject {
+		return "", nil
+	}
+	project := strings.TrimSpace(container.Project)
+	if project == "" {
+		return "", nil
+	}
+	if len(model.PlanNames(container.Labels)) > 0 {
+		return project, fmt.Errorf("container %s: named plans (%s*) cannot be merged into a project plan", container.Name, model.LabelPlanPrefix)
+	}
+	return project, nil
+}
+
+// projectSettings are resolved by precedence rather than merged.
+var projectSettings = []string{model.LabelRepo, model.LabelSchedule, model.LabelRetentionKeep}
+
+// mergeProjects folds the per-container shares of each project plan into
+// the whole plan, in place: every member ends up with the merged plan, or
+// with the error that kept it from rendering. Paths, excludes and hooks are
+// unioned; repo, schedule and keep come from the first member, in service
+// order, that sets them explicitly, else from the defaults.
+func mergeProjects(builds []containerPlans) []ProjectConflict {
+	groups := map[string][]projectMember{}
+	var ids []string
+	for i := range builds {
+		for j := range builds[i].plans {
+			built := &builds[i].plans[j]
+			if built.Project == "" {
+				continue
+			}
+			if _, ok := groups[built.ID]; !ok {
+				ids = append(ids, built.ID)
+			}
+			groups[built.ID] = append(groups[built.ID], projectMember{ctr: builds[i].ctr, built: built})
+		}
+	}
+	sort.Strings(ids)
+
+	var conflicts []ProjectConflict
+	for _, id := range ids {
+		members := groups[id]
+		sort.SliceStable(members, func(i, j int) bool {
+			a, b := members[i].ctr, members[j].ctr
+			if a.Service != b.Service {
+				return a.Service < b.Service
+			}
+			return a.Name < b.Name
+		})
+		project := members[0].built.Project
+
+		var err error
+		for _, m := range members {
+			if m.built.Err != nil {
+				err = fmt.Errorf("project %s: %w", project, m.built.Err)
+				break
+			}
+		}
+		var merged *model.Plan
+		if err == nil {
+			var found []ProjectConflict
+			merged, found = mergeProjectPlan(id, project, members)
+			conflicts = append(conflicts, found...)
+			if len(merged.Paths) == 0 {
+				merged, err = nil, fmt.Errorf("project %s has no derived paths; add backrest.paths.include", project)
+			}
+		}
+		for _, m := range members {
+			m.built.Err = err
+			m.built.Plan = nil
+			if merged != nil {
+				plan := merged.Clone()
+				m.built.Plan = &plan
+			}
+		}
+	}
+	return conflicts
+}
+
+// mergeProjectPlan merges the members' shares, given in precedence order.
+func mergeProjectPlan(id, project string, members []projectMember) (*model.Plan, []ProjectConflict) {
+	merged := members[0].built.Plan.Clone()
+	merged.Paths, merged.PathsExclude, merged.Hooks = nil, nil, nil
+
+	var conflicts []ProjectConflict
+	for _, label := range projectSettings {
+		var winner *ProjectConflict
+		for _, m := range members {
+			ctr, plan := m.ctr, m.built.Plan
+			spec, _ := model.ParseSpec(ctr.Labels)
+			raw, source := spec.Setting(ctr.Labels, label)
+			if source == "" {
+				continue
+			}
+			if winner == nil {
+				winner = &ProjectConflict{Project: project, PlanID: id, Label: label, Value: raw, From: ctr.Name, Ignored: map[string]string{}}
+				switch label {
+				case model.LabelRepo:
+					merged.Repo = plan.Repo
+				case model.LabelSchedule:
+					merged.Schedule = plan.Schedule
+				case model.LabelRetentionKeep:
+					merged.Retention = plan.Retention
+				}
+				continue
+			}
+			if !sameSetting(label, &merged, plan) {
+				winner.Ignored[ctr.Name] = raw
+			}
+		}
+		if winner != nil && len(winner.Ignored) > 0 {
+			conflicts = append(conflicts, *winner)
+		}
+	}
+
+	hooks := map[[32]byte]struct{}{}
+	for _, m := range members {
+		plan := m.built.Plan
+		merged.Paths = append(merged.Paths, plan.Paths...)
+		merged.PathsExclude = append(merged.PathsExclude, plan.PathsExclude...)
+		for _, hook := range plan.Hooks {
+			key := fingerprintJSON(hook)
+			if _, dup := hooks[key]; !dup {
+				hooks[key] = struct{}{}
+				merged.Hooks = append(merged.Hooks, hook)
+			}
+		}
+	}
+	merged.Normalize()
+	return &merged, conflicts
+}
+
+// sameSetting compares the rendered values, so "daily=7" and
+// {"daily": 7} agree.
+func sameSetting(label string, a, b *model.Plan) bool {
+	switch label {
+	case model.LabelRepo:
+		return a.Repo == b.Repo
+	case model.LabelSchedule:
+		return a.Schedule.Cron == b.Schedule.Cron
+	default:
+		return fingerprintJSON(a.Retention) == fingerprintJSON(b.Retention)
+	}
+}
+
+func sortedNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
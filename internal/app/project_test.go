@@ -0,0 +1,168 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/zettaio/backrest-sidecar/internal/config"
+	"github.com/zettaio/backrest-sidecar/internal/docker/dockertest"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+)
+
+func TestRunMergesProjectGranularityContainersIntoOnePlan(t *testing.T) {
+	cases := []struct {
+		name    string
+		app, db map[string]string // labels added to each service
+		check   func(t *testing.T, env *testEnv, plan *model.Plan)
+	}{
+		{
+			name: "paths and excludes are unioned",
+			app:  map[string]string{model.LabelPathsExclude: "*.log"},
+			db:   map[string]string{model.LabelPathsExclude: "*.tmp"},
+			check: func(t *testing.T, _ *testEnv, plan *model.Plan) {
+				if got := strings.Join(plan.Paths, "|"); got != "/var/lib/docker/volumes/myapp-app-1-data/_data|/var/lib/docker/volumes/myapp-db-1-data/_data" {
+					t.Fatalf("expected the paths of both services, got %q", got)
+				}
+				if got := strings.Join(plan.PathsExclude, "|"); got != "*.log|*.tmp" {
+					t.Fatalf("expected the excludes of both services, got %q", got)
+				}
+			},
+		},
+		{
+			name: "hooks are unioned",
+			app:  map[string]string{model.LabelHooksTemplate: "simple-stop-start"},
+			db:   map[string]string{model.LabelHookSnapshotStart: "pg_dump -f /data/dump.sql"},
+			check: func(t *testing.T, _ *testEnv, plan *model.Plan) {
+				var commands []string
+				for _, hook := range plan.Hooks {
+					commands = append(commands, hook.ActionCommand.Command)
+				}
+				if got := strings.Join(commands, "|"); got != "docker stop myapp-app-1|pg_dump -f /data/dump.sql|docker start myapp-app-1" {
+					t.Fatalf("expected the hooks of both services, got %q", got)
+				}
+			},
+		},
+		{
+			name: "the first service wins a conflict",
+			app:  map[string]string{model.LabelSchedule: "0 4 * * *"},
+			db:   map[string]string{model.LabelSchedule: "0 3 * * *"},
+			check: func(t *testing.T, env *testEnv, plan *model.Plan) {
+				if plan.Schedule.Cron != "0 4 * * *" {
+					t.Fatalf("expected the app schedule, got %s", plan.Schedule.Cron)
+				}
+				report, err := env.r.Lint(context.Background())
+				if err != nil {
+					t.Fatalf("lint: %v", err)
+				}
+				want := LintFinding{
+					Container: "myapp-db-1",
+					Label:     model.LabelSchedule,
+					Message:   `"0 3 * * *" is ignored; project plan backrest_sidecar_project_myapp uses "0 4 * * *" from myapp-app-1`,
+				}
+				if len(report.Findings) != 1 || report.Findings[0] != want {
+					t.Fatalf("expected the schedule conflict, got %+v", report.Findings)
+				}
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, db := testProjectContainer("myapp", "app"), testProjectContainer("myapp", "db")
+			for k, v := range tc.app {
+				app.Labels[k] = v
+			}
+			for k, v := range tc.db {
+				db.Labels[k] = v
+			}
+			env := newTestEnv(t, nil, app, db, testAppContainer("solo"))
+			result, err := env.r.Run(context.Background())
+			if err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			if result.PlansSeen != 2 {
+				t.Fatalf("expected the project plan and the solo plan, got %+v", result)
+			}
+			cfg, _, err := config.Load(env.path)
+			if err != nil {
+				t.Fatalf("load config: %v", err)
+			}
+			plan := cfg.FindPlan("backrest_sidecar_project_myapp")
+			if plan == nil || cfg.FindPlan("backrest_sidecar_solo") == nil {
+				t.Fatalf("expected plans for myapp and solo, got %+v", cfg.Plans)
+			}
+			shared := 0
+			for _, render := range env.r.renders.get() {
+				if render.PlanID == plan.ID && render.Plan != nil {
+					shared++
+				}
+			}
+			if shared != 2 {
+				t.Fatalf("expected both containers to render the project plan, got %+v", env.r.renders.get())
+			}
+			tc.check(t, env, plan)
+		})
+	}
+}
+
+func TestProjectPlanIsSkippedWhenAMemberHasNamedPlans(t *testing.T) {
+	db := testProjectContainer("myapp", "db")
+	db.Labels[model.LabelPlanPrefix+"dump.schedule"] = "@hourly"
+	env := newTestEnv(t, nil, testProjectContainer("myapp", "app"), db)
+
+	diff, err := env.r.Diff(context.Background())
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	if len(diff.Skipped) != 2 || !strings.Contains(diff.Skipped[0].Skipped, "cannot be merged into a project plan") {
+		t.Fatalf("expected a member's error to skip the project plan for both, got %+v", diff.Skipped)
+	}
+}
+
+func TestProjectPlanIDsDoNotCollideWithServicePlans(t *testing.T) {
+	// service myapp of project myapp renders backrest_sidecar_myapp itself
+	own := testProjectContainer("myapp", "myapp")
+	own.Labels[model.LabelGranularity] = "container"
+	// and a container named project-myapp derives the project plan's ID
+	env := newTestEnv(t, nil, testProjectContainer("myapp", "app"), own, testAppContainer("project-myapp"))
+
+	result, err := env.r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if result.PlansSeen != 2 {
+		t.Fatalf("expected the project plan and the service plan, got %+v", result)
+	}
+	cfg, _, err := config.Load(env.path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if plan := cfg.FindPlan("backrest_sidecar_myapp"); plan == nil || strings.Join(plan.Paths, "|") != "/var/lib/docker/volumes/myapp-myapp-1-data/_data" {
+		t.Fatalf("expected the service's own plan, got %+v", plan)
+	}
+	if plan := cfg.FindPlan("backrest_sidecar_project_myapp"); plan == nil || strings.Join(plan.Paths, "|") != "/var/lib/docker/volumes/myapp-app-1-data/_data" {
+		t.Fatalf("expected the project plan to keep its ID, got %+v", plan)
+	}
+
+	report, err := env.r.Lint(context.Background())
+	if err != nil {
+		t.Fatalf("lint: %v", err)
+	}
+	want := LintFinding{
+		Container: "project-myapp",
+		Message:   "plan id backrest_sidecar_project_myapp is already taken by project myapp; this plan is skipped",
+	}
+	if len(report.Findings) != 1 || report.Findings[0] != want {
+		t.Fatalf("expected the ID collision, got %+v", report.Findings)
+	}
+}
+
+// testProjectContainer is a compose service container that opts into
+// project granularity.
+func testProjectContainer(project, service string) dockertest.Container {
+	ctr := testAppContainer(project + "-" + service + "-1")
+	ctr.Labels[model.LabelComposeProject] = project
+	ctr.Labels[model.LabelComposeService] = service
+	ctr.Labels[model.LabelGranularity] = "project"
+	return ctr
+}
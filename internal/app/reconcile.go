@@ -2,18 +2,22 @@ package app
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/docker/docker/api/types/filters"
+	dockerevents "github.com/docker/docker/api/types/events"
 
+	"github.com/zettaio/backrest-sidecar/internal/backrest"
 	"github.com/zettaio/backrest-sidecar/internal/config"
 	"github.com/zettaio/backrest-sidecar/internal/docker"
 	"github.com/zettaio/backrest-sidecar/internal/model"
+	"github.com/zettaio/backrest-sidecar/internal/state"
 )
 
 // ReconcileOptions captures CLI flags for reconcile/daemon.
@@ -32,21 +36,56 @@ type ReconcileOptions struct {
 	PlanIDPrefix        string
 	IncludeProjectName  bool
 	ExcludeBindMounts   bool
+	PlanGranularity     Granularity
 	Logger              *slog.Logger
 	RestartTimeout      time.Duration
+	StatePath           string
+	OrphanPolicy        OrphanPolicy
+	OrphanGrace         time.Duration
+	OverridePolicy      OverridePolicy
+	ApplyMode           ApplyMode
+	BackrestAPI         BackrestAPIOptions
+	// VerifyTimeout bounds how long to wait for Backrest to come back healthy
+	// after a restart; zero skips verification and rollback.
+	VerifyTimeout time.Duration
+	// HealthURL is an optional HTTP endpoint probed when Backrest has no Docker healthcheck.
+	HealthURL string
+	// HistoryLimit is how many config snapshots to keep next to the config; zero disables history.
+	HistoryLimit int
 }
 
 // Reconciler runs the main discovery/merge flow.
 type Reconciler struct {
 	opts                ReconcileOptions
-	client              *docker.Client
+	client              docker.API
+	control             containerControl
+	backrest            *backrest.Client
+	history             *config.History
+	idleProbe           operationsProbe
+	deferApply          bool
+	pending             *pendingChange
+	healthPoll          time.Duration
+	healthSettle        time.Duration
 	builder             *PlanBuilder
+	plans               planCache
+	memo                *passMemo
+	renders             renderLog
 	log                 *slog.Logger
 	cfgPath             string
+	statePath           string
+	stateDirty          bool
 	dryRun              bool
 	defaultRepoProvided bool
 	defaultRepoLogged   bool
-	restarts            struct {
+	defaultRepoSource   string
+	orphanPrefixWarned  bool
+	// overrides remembers the kept outside edits per plan so they are logged once.
+	overrides map[string]string
+	// listed is the container listing the last pass used; see listContainers.
+	listed []docker.Container
+	// lastWritten is the hash of the config bytes this reconciler last wrote.
+	lastWritten [32]byte
+	restarts    struct {
 		container string
 		timeout   time.Duration
 	}
@@ -71,18 +110,36 @@ func NewReconciler(opts ReconcileOptions) (*Reconciler, error) {
 		PlanIDPrefix:       opts.PlanIDPrefix,
 		IncludeProjectName: opts.IncludeProjectName,
 		ExcludeBindMounts:  opts.ExcludeBindMounts,
+		Granularity:        opts.PlanGranularity,
 	})
 	if opts.RestartTimeout == 0 {
 		opts.RestartTimeout = 15 * time.Second
 	}
+	if opts.StatePath == "" {
+		opts.StatePath = state.DefaultPath(opts.ConfigPath)
+	}
+	var api *backrest.Client
+	if opts.ApplyMode == ApplyAPI {
+		api, err = newBackrestClient(opts.BackrestAPI)
+		if err != nil {
+			_ = client.Close()
+			return nil, fmt.Errorf("backrest api client: %w", err)
+		}
+	}
 	return &Reconciler{
 		opts:                opts,
 		client:              client,
+		control:             client,
+		backrest:            api,
+		history:             config.NewHistory(opts.ConfigPath, opts.HistoryLimit),
 		builder:             builder,
 		log:                 opts.Logger,
 		cfgPath:             opts.ConfigPath,
+		statePath:           opts.StatePath,
 		dryRun:              opts.DryRun,
 		defaultRepoProvided: opts.DefaultRepoProvided,
+		healthPoll:          time.Second,
+		healthSettle:        5 * time.Second,
 		restarts: struct {
 			container string
 			timeout   time.Duration
@@ -100,41 +157,193 @@ func (r *Reconciler) Close() {
 	}
 }
 
+// maxWriteAttempts bounds how often one pass merges again after the config
+// file changed underneath it (e.g. a save in the Backrest UI).
+const maxWriteAttempts = 3
+
+// passOutcome is what merging the rendered plans into the config produced.
+type passOutcome struct {
+	result     *ReconcileResult
+	change     *pendingChange // nil when nothing was written
+	changedIDs []string
+	rendered   int
+	skipped    int
+	// merged is the config as the pass left it (written or, on dry-run, not).
+	merged *model.Config
+	// configStamp identifies the config revision the pass started from.
+	configStamp fileStamp
+	stamped     bool
+}
+
 // Run executes a single reconcile pass.
 func (r *Reconciler) Run(ctx context.Context) (*ReconcileResult, error) {
-	cfg, _, err := config.Load(r.cfgPath)
+	return r.RunChanged(ctx, nil)
+}
+
+// RunChanged is Run for a pass triggered by events naming the containers
+// with the given IDs: only those are listed again. With no IDs it lists
+// every container, as Run does.
+func (r *Reconciler) RunChanged(ctx context.Context, changed []string) (*ReconcileResult, error) {
+	started := time.Now()
+	result, err := r.run(ctx, changed)
+	observePass(started, result, err)
+	return result, err
+}
+
+func (r *Reconciler) run(ctx context.Context, changed []string) (*ReconcileResult, error) {
+	containers, err := r.listContainers(ctx, changed)
+	if err != nil {
+		return nil, err
+	}
+	key := passFingerprint(r.builder, containers)
+	if r.unchanged(key) {
+		result := r.memo.result
+		result.Cached = true
+		r.log.Debug("reconcile.unchanged", slog.Int("containers", len(containers)))
+		return &result, nil
+	}
+	r.memo = nil
+
+	var out *passOutcome
+	for attempt := 1; ; attempt++ {
+		out, err = r.mergeAndWrite(ctx, containers)
+		if errors.Is(err, config.ErrConflict) && attempt < maxWriteAttempts {
+			r.log.Warn("config.conflict", slog.String("path", r.cfgPath), slog.Int("attempt", attempt), slog.String("action", "merging again"))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+	r.memoize(key, out)
+	result, change := out.result, out.change
+	if change == nil {
+		return result, nil
+	}
+
+	if r.opts.Apply && (r.restarts.container != "" || r.opts.ApplyMode == ApplyAPI) {
+		if r.deferApply {
+			r.pending = change
+			result.ApplyPending = true
+			r.log.Info("backrest.apply_deferred", slog.Any("plans_changed", out.changedIDs))
+		} else {
+			applied, err := r.applyChange(ctx, change)
+			result.Applied = applied
+			var unhealthy *UnhealthyApplyError
+			if errors.As(err, &unhealthy) {
+				result.RolledBack = unhealthy.RolledBack
+				result.FailedPlanIDs = unhealthy.PlanIDs
+			}
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+
+	r.log.Info("reconcile.complete", slog.Int("rendered", out.rendered), slog.Int("skipped", out.skipped), slog.Int("orphaned", len(result.Orphans)), slog.Bool("changed", true))
+	return result, nil
+}
+
+// mergeAndWrite loads the config, merges the rendered plans into it and writes
+// it back while holding the config lock. The write only succeeds if the file
+// still matches what was loaded; otherwise it returns config.ErrConflict and
+// leaves the file alone.
+func (r *Reconciler) mergeAndWrite(ctx context.Context, containers []docker.Container) (*passOutcome, error) {
+	if !r.dryRun {
+		lock, err := config.Lock(ctx, r.cfgPath)
+		if err != nil {
+			return nil, fmt.Errorf("config lock: %w", err)
+		}
+		defer lock.Release()
+	}
+
+	out := &passOutcome{}
+	stamp, err := stampFile(r.cfgPath)
+	out.configStamp, out.stamped = stamp, err == nil
+	cfg, previous, err := config.Load(r.cfgPath)
 	if err != nil {
 		return nil, err
 	}
 	r.setDefaultRepoFromConfig(cfg)
 
-	containers, err := r.client.ListBackrestEnabled(ctx)
+	st, err := state.Load(r.statePath)
 	if err != nil {
-		return nil, fmt.Errorf("list containers: %w", err)
+		return nil, err
 	}
+	r.stateDirty = false
 
-	rendered := 0
-	skipped := 0
+	live := make(map[string]struct{}, len(containers))
 	plans := make([]model.Plan, 0, len(containers))
 	renderedPlans := make([]model.Plan, 0, len(containers))
+	renders := make([]ContainerRender, 0, len(containers))
+	builds := make([]containerPlans, 0, len(containers))
 	for _, ctr := range containers {
-		plan, err := r.builder.Build(ctr)
-		if err != nil {
-			r.log.Warn("plan skipped", slog.String("container", ctr.Name), slog.String("id", ctr.ID[:12]), slog.String("error", err.Error()))
-			skipped++
-			continue
-		}
-		if !cfg.RepoExists(plan.Repo) {
-			r.log.Warn("plan skipped - repo missing", slog.String("plan_id", plan.ID), slog.String("repo", plan.Repo))
-			skipped++
-			continue
+		builds = append(builds, containerPlans{ctr: ctr, plans: r.plans.build(r.builder, ctr)})
+	}
+	for _, c := range mergeProjects(builds) {
+		r.log.Warn("project.conflict", slog.String("project", c.Project), slog.String("plan_id", c.PlanID), slog.String("label", c.Label), slog.String("value", c.Value), slog.String("from", c.From), slog.Any("ignored", c.Ignored))
+	}
+	// a plan whose ID another plan took is skipped below, with a warning
+	claimPlanIDs(builds)
+	// project plans are shared by their containers: checked and counted once
+	projectSkips := map[string]string{}
+	for _, cb := range builds {
+		ctr := cb.ctr
+		for _, built := range cb.plans {
+			render := newContainerRender(ctr).forPlan(built)
+			if built.ID != "" {
+				live[built.ID] = struct{}{}
+			}
+			plan := built.Plan
+			if reason, seen := projectSkips[built.ID]; seen && built.Project != "" {
+				if reason != "" {
+					render = render.skip(reason)
+				} else {
+					rendered := plan.Clone()
+					render.Plan = &rendered
+				}
+				renders = append(renders, render)
+				continue
+			}
+			var reason string
+			switch {
+			case built.Err != nil:
+				r.log.Warn("plan skipped", slog.String("container", ctr.Name), slog.String("id", ctr.ID[:12]), slog.String("plan_id", built.ID), slog.String("error", built.Err.Error()))
+				mPlansSkipped.Inc("invalid")
+				reason = built.Err.Error()
+			case !cfg.RepoExists(plan.Repo):
+				r.log.Warn("plan skipped - repo missing", slog.String("plan_id", plan.ID), slog.String("repo", plan.Repo))
+				mPlansSkipped.Inc("repo_missing")
+				reason = fmt.Sprintf("repo %q missing from config", plan.Repo)
+			case r.quarantined(st, plan):
+				mPlansSkipped.Inc("quarantined")
+				reason = "quarantined: render previously left backrest unhealthy"
+			}
+			if built.Project != "" {
+				projectSkips[built.ID] = reason
+			}
+			if reason != "" {
+				out.skipped++
+				renders = append(renders, render.skip(reason))
+				continue
+			}
+			rendered := plan.Clone()
+			render.Plan = &rendered
+			renders = append(renders, render)
+			// copies: merging into plans must not reach the recorded render
+			plans = append(plans, plan.Clone())
+			renderedPlans = append(renderedPlans, plan.Clone())
+			out.rendered++
 		}
-		plans = append(plans, *plan)
-		renderedPlans = append(renderedPlans, *plan)
-		rendered++
 	}
 
-	changed, changedIDs := cfg.UpsertPlans(plans)
+	r.plans.prune(containers)
+	r.renders.set(renders)
+	mPlansRendered.Set(float64(out.rendered))
+	r.releaseQuarantine(st, live)
+	drift := r.detectDrift(cfg, st, plans)
+	_, changedIDs := cfg.UpsertPlans(plans)
 
 	changedSet := make(map[string]struct{}, len(changedIDs))
 	for _, id := range changedIDs {
@@ -154,31 +363,179 @@ func (r *Reconciler) Run(ctx context.Context) (*ReconcileResult, error) {
 		}
 	}
 
-	if !changed {
-		r.log.Debug("reconcile.complete", slog.Int("rendered", rendered), slog.Int("skipped", skipped), slog.Bool("changed", false))
-		return &ReconcileResult{PlansSeen: rendered, PlansChanged: 0, Changed: false}, nil
+	labelChanges := len(changedIDs)
+	orphans, orphanIDs := r.resolveOrphans(cfg, live, st, time.Now())
+	changedIDs = append(changedIDs, orphanIDs...)
+	r.recordRendered(st, renderedPlans, live)
+	out.merged = cfg
+	out.changedIDs = changedIDs
+	out.result = &ReconcileResult{
+		PlansSeen:     out.rendered,
+		PlansChanged:  len(changedIDs),
+		PlansOrphaned: len(orphans),
+		Orphans:       orphans,
+		Drift:         drift,
+		Changed:       len(changedIDs) > 0,
+		DryRun:        r.dryRun,
+	}
+
+	if !out.result.Changed {
+		if err := r.saveState(st); err != nil {
+			return nil, err
+		}
+		r.log.Debug("reconcile.complete", slog.Int("rendered", out.rendered), slog.Int("skipped", out.skipped), slog.Int("orphaned", len(orphans)), slog.Bool("changed", false))
+		return out, nil
 	}
 
 	cfg.Normalize()
 	if r.dryRun {
-		r.log.Info("dry-run.complete", slog.Int("plans_seen", rendered), slog.Int("plans_changed", len(changedIDs)), slog.String("config", r.cfgPath))
-		return &ReconcileResult{PlansSeen: rendered, PlansChanged: len(changedIDs), Changed: true, DryRun: true}, nil
+		r.log.Info("dry-run.complete", slog.Int("plans_seen", out.rendered), slog.Int("plans_changed", len(changedIDs)), slog.Int("plans_orphaned", len(orphans)), slog.String("config", r.cfgPath))
+		return out, nil
 	}
 
-	if _, err := config.Write(r.cfgPath, cfg); err != nil {
+	change := r.pending
+	if change == nil {
+		change = &pendingChange{rendered: make(map[string]string)}
+		if previous != nil && r.opts.Apply {
+			if err := config.SaveBackup(r.cfgPath, previous); err != nil {
+				return nil, err
+			}
+			change.backedUp = true
+		}
+	}
+	written, err := config.WriteIfUnchanged(r.cfgPath, cfg, previous)
+	if err != nil {
+		if errors.Is(err, config.ErrConflict) {
+			mConfigWrites.Inc("conflict")
+		} else {
+			mConfigWrites.Inc("failed")
+		}
 		return nil, err
 	}
+	mConfigWrites.Inc("written")
+	mPlansChanged.Add(float64(labelChanges), "labels")
+	mPlansChanged.Add(float64(len(orphanIDs)), "orphan")
+	change.data = written
+	r.lastWritten = sha256.Sum256(append(written, '\n'))
+	// fingerprints of the label renders, before any merge, so a quarantine
+	// matches what the next pass renders
+	for _, plan := range renderedPlans {
+		if _, ok := changedSet[plan.ID]; ok {
+			change.rendered[plan.ID] = plan.Fingerprint()
+		}
+	}
+	out.change = change
 	r.log.Info("config.write", slog.String("path", r.cfgPath), slog.Int("plans_total", len(cfg.Plans)), slog.Any("plans_changed", changedIDs))
+	r.recordHistory(previous, append(written, '\n'), changedIDs, "reconcile")
+	if err := r.saveState(st); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
 
-	if r.opts.Apply && r.restarts.container != "" {
-		if err := r.client.RestartContainer(ctx, r.restarts.container, r.restarts.timeout); err != nil {
-			return nil, fmt.Errorf("restart backrest container: %w", err)
+// quarantined reports whether this exact render previously broke Backrest.
+func (r *Reconciler) quarantined(st *state.State, plan *model.Plan) bool {
+	fp, ok := st.Quarantined[plan.ID]
+	if !ok {
+		return false
+	}
+	if fp != plan.Fingerprint() {
+		// labels changed since the failure; give the new render a chance
+		delete(st.Quarantined, plan.ID)
+		r.stateDirty = true
+		r.log.Info("plan.quarantine_released", slog.String("plan_id", plan.ID))
+		return false
+	}
+	r.log.Warn("plan skipped - quarantined", slog.String("plan_id", plan.ID), slog.String("reason", "render previously left backrest unhealthy"))
+	return true
+}
+
+// releaseQuarantine forgets quarantined renders whose containers are gone.
+func (r *Reconciler) releaseQuarantine(st *state.State, live map[string]struct{}) {
+	for id := range st.Quarantined {
+		if _, ok := live[id]; !ok {
+			delete(st.Quarantined, id)
+			r.stateDirty = true
 		}
-		r.log.Info("backrest.restart", slog.String("container", r.restarts.container))
 	}
+}
 
-	r.log.Info("reconcile.complete", slog.Int("rendered", rendered), slog.Int("skipped", skipped), slog.Bool("changed", true))
-	return &ReconcileResult{PlansSeen: rendered, PlansChanged: len(changedIDs), Changed: true}, nil
+// recordHistory snapshots a written config. The first snapshot also captures
+// the config it replaced so the pre-sidecar state can be restored. Failures
+// are logged but never fail the pass.
+func (r *Reconciler) recordHistory(previous, written []byte, changed []string, note string) {
+	if !r.history.Enabled() {
+		return
+	}
+	if previous != nil {
+		if snaps, err := r.history.List(); err == nil && len(snaps) == 0 {
+			if _, err := r.history.Record(previous, nil, "baseline", time.Now()); err != nil {
+				r.log.Warn("config.history_failed", slog.String("error", err.Error()))
+				return
+			}
+		}
+	}
+	snap, err := r.history.Record(written, changed, note, time.Now())
+	if err != nil {
+		r.log.Warn("config.history_failed", slog.String("error", err.Error()))
+		return
+	}
+	r.log.Debug("config.history", slog.String("snapshot", snap.ID))
+}
+
+// Rollback restores a recorded config snapshot and, with Apply set, applies it
+// to Backrest the same way a reconcile write would be.
+func (r *Reconciler) Rollback(ctx context.Context, id string) (config.Snapshot, error) {
+	snap, change, err := r.restoreSnapshot(ctx, id)
+	if err != nil {
+		return config.Snapshot{}, err
+	}
+	if r.opts.Apply && (r.restarts.container != "" || r.opts.ApplyMode == ApplyAPI) {
+		if _, err := r.applyChange(ctx, change); err != nil {
+			return snap, err
+		}
+	}
+	return snap, nil
+}
+
+// restoreSnapshot writes a snapshot back to the config path under the config lock.
+func (r *Reconciler) restoreSnapshot(ctx context.Context, id string) (config.Snapshot, *pendingChange, error) {
+	lock, err := config.Lock(ctx, r.cfgPath)
+	if err != nil {
+		return config.Snapshot{}, nil, fmt.Errorf("config lock: %w", err)
+	}
+	defer lock.Release()
+
+	_, previous, err := config.Load(r.cfgPath)
+	if err != nil {
+		// a corrupt config is a reason to roll back, not a blocker
+		previous = nil
+	}
+	if previous != nil && r.opts.Apply {
+		if err := config.SaveBackup(r.cfgPath, previous); err != nil {
+			return config.Snapshot{}, nil, err
+		}
+	}
+	snap, data, err := config.Rollback(r.cfgPath, r.history, id)
+	if err != nil {
+		return config.Snapshot{}, nil, err
+	}
+	r.lastWritten = sha256.Sum256(data)
+	r.log.Info("config.rollback", slog.String("path", r.cfgPath), slog.String("snapshot", snap.ID))
+	r.recordHistory(previous, data, nil, "rollback to "+snap.ID)
+	return snap, &pendingChange{data: data, rendered: map[string]string{}, backedUp: previous != nil && r.opts.Apply}, nil
+}
+
+// saveState persists sidecar state when the pass modified it (never during dry-run).
+func (r *Reconciler) saveState(st *state.State) error {
+	if !r.stateDirty || r.dryRun {
+		return nil
+	}
+	if err := state.Write(r.statePath, st); err != nil {
+		return err
+	}
+	r.stateDirty = false
+	return nil
 }
 
 func (r *Reconciler) setDefaultRepoFromConfig(cfg *model.Config) {
@@ -223,6 +580,7 @@ func firstRepoFromConfig(cfg *model.Config) (string, string) {
 }
 
 func (r *Reconciler) logDefaultRepo(source, repo string) {
+	r.defaultRepoSource = source
 	if r.defaultRepoLogged {
 		return
 	}
@@ -246,10 +604,20 @@ func sourceOrDefault(src, fallback string) string {
 
 // ReconcileResult summarises the reconcile run.
 type ReconcileResult struct {
-	PlansSeen    int
-	PlansChanged int
-	Changed      bool
-	DryRun       bool
+	PlansSeen     int              `json:"plans_seen"`
+	PlansChanged  int              `json:"plans_changed"`
+	PlansOrphaned int              `json:"plans_orphaned"`
+	Orphans       []OrphanDecision `json:"orphans,omitempty"`
+	Drift         []PlanDrift      `json:"drift,omitempty"`
+	Changed       bool             `json:"changed"`
+	// Cached is set when the pass was skipped because neither the containers
+	// nor the config and state files moved since a pass that changed nothing.
+	Cached        bool      `json:"cached,omitempty"`
+	DryRun        bool      `json:"dry_run,omitempty"`
+	Applied       ApplyMode `json:"applied,omitempty"`
+	ApplyPending  bool      `json:"apply_pending,omitempty"`
+	RolledBack    bool      `json:"rolled_back,omitempty"`
+	FailedPlanIDs []string  `json:"failed_plan_ids,omitempty"`
 }
 
 // DaemonOptions extends reconcile options with scheduling knobs.
@@ -257,6 +625,35 @@ type DaemonOptions struct {
 	ReconcileOptions
 	Interval   time.Duration
 	WithEvents bool
+	// EventActions limits which container events trigger a pass; empty uses
+	// DefaultEventActions.
+	EventActions []string
+	// EventDebounce waits until no relevant event arrived for this long before
+	// reconciling, so a compose up of many services causes one pass. Zero
+	// reconciles on every event.
+	EventDebounce time.Duration
+	// ApplyQuietWindow delays applying a changed config until no further
+	// changes were written for this long. Zero applies after every pass.
+	ApplyQuietWindow time.Duration
+	// ApplyMaxDelay caps how long a pending apply may be postponed.
+	ApplyMaxDelay time.Duration
+	// ApplyWaitIdle postpones applying while Backrest reports running operations.
+	ApplyWaitIdle bool
+	// WatchConfig triggers a pass when the config file is changed by anything
+	// other than the sidecar.
+	WatchConfig bool
+	// MetricsAddr, when set, serves Prometheus metrics at /metrics.
+	MetricsAddr string
+	// HealthAddr, when set, serves /healthz and /readyz. It may equal
+	// MetricsAddr to share one listener.
+	HealthAddr string
+	// HealthMaxAge is how long /healthz tolerates no finished pass; zero
+	// derives it from the interval and the restart/verify timeouts.
+	HealthMaxAge time.Duration
+	// APIAddr, when set, serves the JSON API under /api/v1/.
+	APIAddr string
+	// APIToken guards POST /api/v1/reconcile; without it the trigger is off.
+	APIToken string
 }
 
 // RunDaemon loops reconcile on a timer (and optionally on docker events).
@@ -267,61 +664,230 @@ func RunDaemon(ctx context.Context, opts DaemonOptions) error {
 	}
 	defer reconciler.Close()
 
+	scheduler := newApplyScheduler(opts.ApplyQuietWindow, opts.ApplyMaxDelay)
+	if opts.Apply && opts.ApplyQuietWindow > 0 {
+		reconciler.deferApply = true
+		if opts.ApplyWaitIdle {
+			if reconciler.backrest != nil {
+				reconciler.idleProbe = reconciler.backrest
+			} else if probe, err := newBackrestClient(opts.BackrestAPI); err == nil {
+				reconciler.idleProbe = probe
+			} else {
+				reconciler.log.Warn("backrest.idle_check_disabled", slog.String("error", err.Error()))
+			}
+		}
+	}
+	applyTimer := time.NewTimer(time.Hour)
+	applyTimer.Stop()
+	defer applyTimer.Stop()
+	var applyDue <-chan time.Time
+
 	ticker := time.NewTicker(opts.Interval)
 	defer ticker.Stop()
 
 	trigger := make(chan struct{}, 1)
 	trigger <- struct{}{} // run immediately
 
+	poke := func() {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+
+	// API requests force a full pass; the memo is cleared by the loop below
+	// rather than from the HTTP goroutine
+	force := make(chan struct{}, 1)
+	forcePass := func() {
+		select {
+		case force <- struct{}{}:
+		default:
+		}
+	}
+
+	var configEvents <-chan struct{}
+	if opts.WatchConfig {
+		if ch, err := config.Watch(ctx, reconciler.cfgPath); err != nil {
+			reconciler.log.Warn("config.watch_disabled", slog.String("error", err.Error()))
+		} else {
+			configEvents = ch
+		}
+	}
+
+	// relevant events are batched by a debounce timer owned by the loop below;
+	// maxDelay keeps a steady event stream from postponing passes forever
+	eventSeen := make(chan struct{}, 1)
+	eventBatch := newApplyScheduler(opts.EventDebounce, opts.Interval)
+	eventTimer := time.NewTimer(time.Hour)
+	eventTimer.Stop()
+	defer eventTimer.Stop()
+	var eventDue <-chan time.Time
+
 	var (
-		eventCtx context.Context
-		cancel   context.CancelFunc
+		events  *eventStream
+		targets eventTargets
 	)
-
 	if opts.WithEvents {
-		eventCtx, cancel = context.WithCancel(ctx)
-		filterArgs := filters.NewArgs()
-		filterArgs.Add("type", "container")
-		msgCh, errCh := reconciler.client.Events(eventCtx, filterArgs)
-		go func() {
-			for {
-				select {
-				case <-eventCtx.Done():
-					return
-				case _, ok := <-msgCh:
-					if !ok {
-						return
-					}
-					select {
-					case trigger <- struct{}{}:
-					default:
-					}
-				case err := <-errCh:
-					if err != nil && !errors.Is(err, context.Canceled) {
-						reconciler.log.Warn("docker events", slog.String("error", err.Error()))
-					}
-					return
-				}
+		actions := opts.EventActions
+		if len(actions) == 0 {
+			actions = DefaultEventActions
+		}
+		events = newEventStream(reconciler.client, containerEventFilter(actions), reconciler.log)
+		events.onEvent = func(msg dockerevents.Message) {
+			if !hasBackrestLabels(msg) {
+				return
+			}
+			reconciler.log.Debug("docker.event", slog.String("action", string(msg.Action)), slog.String("container", msg.Actor.Attributes["name"]))
+			targets.add(msg.Actor.ID)
+			select {
+			case eventSeen <- struct{}{}:
+			default:
 			}
-		}()
+		}
+		// events may have been missed while dockerd was unreachable
+		events.onReconnect = poke
+		eventCtx, cancel := context.WithCancel(ctx)
 		defer cancel()
+		go events.run(eventCtx)
+	}
+
+	status := newPassStatus(time.Now())
+	if err := reconciler.serveDaemonHTTP(ctx, opts, status, events, forcePass); err != nil {
+		return err
+	}
+
+	// pass runs a full pass when changed is empty, else an event pass over
+	// the named containers
+	pass := func(changed []string) {
+		result, err := reconciler.RunChanged(ctx, changed)
+		status.record(time.Now(), result, err)
+		if err != nil {
+			reconciler.log.Error("reconcile failed", slog.String("error", err.Error()))
+			return
+		}
+		if result.ApplyPending {
+			applyTimer.Reset(scheduler.note(time.Now()))
+			applyDue = applyTimer.C
+		}
+	}
+	eventPass := func() {
+		if changed := targets.take(); len(changed) > 0 {
+			pass(changed)
+		}
 	}
 
 	for {
 		select {
 		case <-ctx.Done():
+			if reconciler.pending != nil {
+				reconciler.log.Warn("backrest.apply_abandoned", slog.String("reason", "shutdown"))
+			}
 			return ctx.Err()
 		case <-ticker.C:
-			select {
-			case trigger <- struct{}{}:
-			default:
-			}
+			poke()
+		case <-force:
+			reconciler.memo = nil
+			poke()
 		case <-trigger:
-			if _, err := reconciler.Run(ctx); err != nil {
-				reconciler.log.Error("reconcile failed", slog.String("error", err.Error()))
+			// a full pass covers whatever events named so far
+			targets.take()
+			pass(nil)
+		case <-eventSeen:
+			if opts.EventDebounce <= 0 {
+				eventPass()
+				continue
+			}
+			eventTimer.Reset(eventBatch.note(time.Now()))
+			eventDue = eventTimer.C
+		case <-eventDue:
+			if fire, wait, _ := eventBatch.decide(time.Now(), nil); !fire {
+				eventTimer.Reset(wait)
+				continue
+			}
+			eventDue = nil
+			eventBatch.done()
+			eventPass()
+		case _, ok := <-configEvents:
+			if !ok {
+				configEvents = nil
+				continue
+			}
+			if !reconciler.externalConfigChange() {
+				continue
+			}
+			reconciler.log.Info("config.external_change", slog.String("path", reconciler.cfgPath))
+			// the edit may not have moved size or mtime on coarse filesystems
+			reconciler.memo = nil
+			poke()
+		case <-applyDue:
+			apply, wait, reason := scheduler.decide(time.Now(), func() int {
+				return reconciler.runningOperations(ctx)
+			})
+			if !apply {
+				reconciler.log.Debug("backrest.apply_waiting", slog.String("reason", reason), slog.Duration("wait", wait))
+				applyTimer.Reset(wait)
+				continue
 			}
+			applyDue = nil
+			scheduler.done()
+			mode, err := reconciler.applyPending(ctx)
+			if err != nil {
+				reconciler.log.Error("backrest.apply_failed", slog.String("error", err.Error()))
+				// an unhealthy change is dropped; anything else stays pending
+				// and is retried after another quiet window
+				if reconciler.pending != nil {
+					applyTimer.Reset(scheduler.note(time.Now()))
+					applyDue = applyTimer.C
+				}
+				continue
+			}
+			if mode != "" {
+				reconciler.log.Info("backrest.apply_batched", slog.String("mode", string(mode)), slog.String("reason", reason))
+			}
+		}
+	}
+}
+
+// serveDaemonHTTP starts the metrics, probe and API listeners; endpoints that
+// share an address share a listener.
+func (r *Reconciler) serveDaemonHTTP(ctx context.Context, opts DaemonOptions, status *passStatus, events *eventStream, trigger func()) error {
+	muxes := make(map[string]*http.ServeMux)
+	muxFor := func(addr string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
+	if opts.MetricsAddr != "" {
+		muxFor(opts.MetricsAddr).Handle("/metrics", registry.Handler())
+	}
+	if opts.HealthAddr != "" {
+		maxAge := opts.HealthMaxAge
+		if maxAge == 0 {
+			// a pass may legitimately block on a restart and its verification
+			maxAge = 3*opts.Interval + r.opts.RestartTimeout + r.opts.VerifyTimeout
+		}
+		p := &probes{
+			status:  status,
+			maxAge:  maxAge,
+			client:  r.client,
+			cfgPath: r.cfgPath,
+			events:  events,
+			timeout: 5 * time.Second,
+			now:     time.Now,
+		}
+		p.register(muxFor(opts.HealthAddr))
+	}
+	if opts.APIAddr != "" {
+		api := &apiServer{renders: &r.renders, status: status, token: opts.APIToken, trigger: trigger}
+		api.register(muxFor(opts.APIAddr))
+	}
+	for addr, mux := range muxes {
+		if err := serveHTTP(ctx, addr, mux, r.log); err != nil {
+			return fmt.Errorf("http listener %s: %w", addr, err)
 		}
 	}
+	return nil
 }
 
 func dockerHostFromSocket(sock string) string {
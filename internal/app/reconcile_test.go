@@ -1,10 +1,20 @@
 package app
 
 import (
+	"context"
 	"io"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+
+	"github.com/zettaio/backrest-sidecar/internal/config"
+	"github.com/zettaio/backrest-sidecar/internal/docker/dockertest"
 	"github.com/zettaio/backrest-sidecar/internal/model"
 )
 
@@ -68,3 +78,477 @@ func testReconcilerWithDefault(defaultRepo string, provided bool) *Reconciler {
 		log:                 logger,
 	}
 }
+
+func TestRunRendersPlansAndRestartsBackrest(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	engine.Add(dockertest.Container{Name: "backrest"})
+	engine.Add(testAppContainer("app"))
+	path := writeTestConfig(t)
+
+	r, err := NewReconciler(testEngineOptions(engine, path))
+	if err != nil {
+		t.Fatalf("new reconciler: %v", err)
+	}
+	defer r.Close()
+
+	result, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !result.Changed || result.Applied != ApplyRestart {
+		t.Fatalf("expected a write and restart, got %+v", result)
+	}
+	cfg, _, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	plan := cfg.FindPlan("backrest_sidecar_app")
+	if plan == nil || plan.Repo != "repo-a" || strings.Join(plan.Paths, ",") != "/var/lib/docker/volumes/app-data/_data" {
+		t.Fatalf("unexpected plans %+v", cfg.Plans)
+	}
+
+	// a second pass over the same containers is a no-op
+	result, err = r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if result.Changed {
+		t.Fatalf("expected idempotent second pass, got %+v", result)
+	}
+	if calls := engine.Calls(); len(calls) != 1 || calls[0] != "restart backrest" {
+		t.Fatalf("expected exactly one restart, got %v", calls)
+	}
+	var exposition strings.Builder
+	if err := registry.WriteText(&exposition); err != nil {
+		t.Fatalf("metrics: %v", err)
+	}
+	for _, series := range []string{
+		`backrest_sidecar_reconcile_passes_total{outcome="changed"}`,
+		`backrest_sidecar_config_writes_total{outcome="written"}`,
+		`backrest_sidecar_backrest_restarts_total{reason="apply"}`,
+	} {
+		if !strings.Contains(exposition.String(), series) {
+			t.Fatalf("expected %s in metrics:\n%s", series, exposition.String())
+		}
+	}
+}
+
+func TestRunRollsBackWhenBackrestCrashLoops(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	engine.Add(dockertest.Container{Name: "backrest"})
+	engine.Add(testAppContainer("app"))
+	engine.OnRestart(func(c *dockertest.Container) {
+		if c.Name == "backrest" && c.RestartCount == 0 {
+			c.State = "restarting"
+			c.RestartCount = 1
+		}
+	})
+	path := writeTestConfig(t)
+
+	opts := testEngineOptions(engine, path)
+	opts.VerifyTimeout = 5 * time.Second
+	r, err := NewReconciler(opts)
+	if err != nil {
+		t.Fatalf("new reconciler: %v", err)
+	}
+	defer r.Close()
+	r.healthPoll = 10 * time.Millisecond
+	r.healthSettle = 0
+
+	result, err := r.Run(context.Background())
+	if err == nil || !result.RolledBack {
+		t.Fatalf("expected a rollback, got result=%+v err=%v", result, err)
+	}
+	cfg, _, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if len(cfg.Plans) != 0 {
+		t.Fatalf("expected the previous config to be restored, got %+v", cfg.Plans)
+	}
+	if r.externalConfigChange() {
+		t.Fatalf("expected the config watcher to recognise the rollback as the sidecar's own write")
+	}
+	if calls := engine.Calls(); len(calls) != 2 {
+		t.Fatalf("expected restart and rollback restart, got %v", calls)
+	}
+}
+
+func TestRunKeepsARenderQuarantinedAcrossPasses(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	engine.Add(dockertest.Container{Name: "backrest"})
+	app := testAppContainer("app")
+	app.Labels[model.LabelHookSnapshotStart] = "echo start"
+	engine.Add(app)
+	crash := false
+	engine.OnRestart(func(c *dockertest.Container) {
+		if c.Name == "backrest" && crash {
+			crash = false
+			c.State = "restarting"
+			c.RestartCount++
+		}
+	})
+	path := writeTestConfig(t)
+
+	opts := testEngineOptions(engine, path)
+	opts.VerifyTimeout = 5 * time.Second
+	r, err := NewReconciler(opts)
+	if err != nil {
+		t.Fatalf("new reconciler: %v", err)
+	}
+	defer r.Close()
+	r.healthPoll = 10 * time.Millisecond
+	r.healthSettle = 0
+	ctx := context.Background()
+	if _, err := r.Run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	// a hook field edited in the Backrest UI, then a label change that breaks Backrest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	edited := strings.Replace(string(data), `"actionCommand"`, `"onError": "ON_ERROR_CANCEL", "actionCommand"`, 1)
+	if err := os.WriteFile(path, []byte(edited), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	engine.Update("app", func(c *dockertest.Container) { c.Labels[model.LabelSchedule] = "0 5 * * *" })
+	crash = true
+	if result, err := r.Run(ctx); err == nil || !result.RolledBack {
+		t.Fatalf("expected a rollback, got result=%+v err=%v", result, err)
+	}
+
+	for pass := 1; pass <= 2; pass++ {
+		if _, err := r.Run(ctx); err != nil {
+			t.Fatalf("pass %d: %v", pass, err)
+		}
+		cfg, _, err := config.Load(path)
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if plan := cfg.FindPlan("backrest_sidecar_app"); plan == nil || plan.Schedule.Cron != "0 2 * * *" {
+			t.Fatalf("pass %d: expected the quarantined render to stay out, got %+v", pass, cfg.Plans)
+		}
+		if r.renders.get()[0].Skipped == "" {
+			t.Fatalf("pass %d: expected the render skipped as quarantined, got %+v", pass, r.renders.get())
+		}
+	}
+}
+
+func TestRunDaemonReconcilesOnDockerEvents(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	engine.Add(testAppContainer("app"))
+	path := writeTestConfig(t)
+
+	opts := testEngineOptions(engine, path)
+	opts.Apply = false
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- RunDaemon(ctx, DaemonOptions{ReconcileOptions: opts, Interval: time.Hour, WithEvents: true})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitForPlan(t, path, "backrest_sidecar_app", nil)
+	engine.Add(testAppContainer("db"))
+	// the interval is an hour, so only the event stream can bring the new plan in;
+	// re-emit in case the subscription was not registered yet
+	waitForPlan(t, path, "backrest_sidecar_db", func() { engine.Emit("create", "db") })
+}
+
+func TestRunRebuildsOnlyChangedContainersAndSkipsIdlePasses(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	engine.Add(testAppContainer("app"))
+	engine.Add(testAppContainer("db"))
+	path := writeTestConfig(t)
+
+	opts := testEngineOptions(engine, path)
+	opts.Apply = false
+	r, err := NewReconciler(opts)
+	if err != nil {
+		t.Fatalf("new reconciler: %v", err)
+	}
+	defer r.Close()
+	ctx := context.Background()
+
+	run := func(step string) *ReconcileResult {
+		t.Helper()
+		result, err := r.Run(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		return result
+	}
+	if result := run("first run"); !result.Changed || r.plans.built != 2 {
+		t.Fatalf("expected both plans built and written, got %+v built=%d", result, r.plans.built)
+	}
+	// nothing moved: the pass is verified once, then skipped without rebuilding
+	if result := run("second run"); result.Changed || result.Cached || r.plans.built != 2 {
+		t.Fatalf("expected a full no-op pass from the cache, got %+v built=%d", result, r.plans.built)
+	}
+	if result := run("third run"); !result.Cached || result.PlansSeen != 2 {
+		t.Fatalf("expected a skipped pass, got %+v", result)
+	}
+
+	engine.Update("db", func(c *dockertest.Container) { c.Labels[model.LabelSchedule] = "0 5 * * *" })
+	if result := run("after label change"); !result.Changed || result.PlansChanged != 1 || r.plans.built != 3 {
+		t.Fatalf("expected only db rebuilt, got %+v built=%d", result, r.plans.built)
+	}
+
+	// an edit to the file invalidates the skip even though no container moved
+	run("settle")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	edited := strings.Replace(string(data), "0 5 * * *", "0 6 * * *", 1)
+	if err := os.WriteFile(path, []byte(edited+"\n\n"), 0o644); err != nil {
+		t.Fatalf("edit config: %v", err)
+	}
+	if result := run("after edit"); result.Cached || len(result.Drift) != 1 {
+		t.Fatalf("expected the edit to be noticed, got %+v", result)
+	}
+	if r.plans.built != 3 {
+		t.Fatalf("expected no rebuilds for a config edit, built=%d", r.plans.built)
+	}
+}
+
+func TestRunChangedListsOnlyTheContainersEventsNamed(t *testing.T) {
+	env := newTestEnv(t, nil, testAppContainer("app"))
+	ctx := context.Background()
+	if _, err := env.r.Run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	cron := func() string {
+		t.Helper()
+		cfg, _, err := config.Load(env.path)
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		plan := cfg.FindPlan("backrest_sidecar_app")
+		if plan == nil {
+			t.Fatalf("expected the app plan, got %+v", cfg.Plans)
+		}
+		return plan.Schedule.Cron
+	}
+
+	// app changes without an event; an event names db only
+	env.engine.Update("app", func(c *dockertest.Container) { c.Labels[model.LabelSchedule] = "0 5 * * *" })
+	db := env.engine.Add(testAppContainer("db"))
+	result, err := env.r.RunChanged(ctx, []string{db})
+	if err != nil {
+		t.Fatalf("event pass: %v", err)
+	}
+	if result.PlansSeen != 2 || cron() != "0 2 * * *" {
+		t.Fatalf("expected db added and app taken from the last listing, got %+v", result)
+	}
+
+	env.engine.Remove("db")
+	if result, err := env.r.RunChanged(ctx, []string{db}); err != nil || result.PlansSeen != 1 {
+		t.Fatalf("expected the destroyed db dropped, got %+v (err %v)", result, err)
+	}
+
+	// the periodic pass lists everything
+	if _, err := env.r.Run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if cron() != "0 5 * * *" {
+		t.Fatalf("expected a full pass to pick up the app change")
+	}
+}
+
+func TestRunRendersNamedPlansWithoutOrphaningThem(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	ctr := testAppContainer("db")
+	ctr.Labels[model.LabelPlanPrefix+"dump.paths.include"] = "/data/dump"
+	ctr.Labels[model.LabelPlanPrefix+"full.schedule"] = "0 3 * * 0"
+	engine.Add(ctr)
+	path := writeTestConfig(t)
+
+	opts := testEngineOptions(engine, path)
+	opts.Apply = false
+	opts.OrphanPolicy = OrphanDelete
+	r, err := NewReconciler(opts)
+	if err != nil {
+		t.Fatalf("new reconciler: %v", err)
+	}
+	defer r.Close()
+	ctx := context.Background()
+
+	if _, err := r.Run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	cfg, _, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.FindPlan("backrest_sidecar_db") != nil || cfg.FindPlan("backrest_sidecar_db_dump") == nil || cfg.FindPlan("backrest_sidecar_db_full") == nil {
+		t.Fatalf("expected the named plans only, got %+v", cfg.Plans)
+	}
+	if diff, err := r.Diff(ctx); err != nil || diff.Changed {
+		t.Fatalf("expected the named plans to stay put, got %+v (err %v)", diff, err)
+	}
+
+	engine.Update("db", func(c *dockertest.Container) { delete(c.Labels, model.LabelPlanPrefix+"full.schedule") })
+	diff, err := r.Diff(ctx)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	if len(diff.Plans) != 1 || diff.Plans[0].PlanID != "backrest_sidecar_db_full" || diff.Plans[0].Status != PlanOrphaned {
+		t.Fatalf("expected the dropped named plan orphaned, got %+v", diff.Plans)
+	}
+}
+
+func TestRunSkipsPlansWhoseIDIsTaken(t *testing.T) {
+	cases := []struct {
+		name       string
+		containers func() []dockertest.Container
+		plans      []string // plan IDs written, in config order
+		finding    LintFinding
+	}{
+		{
+			name: "named plan and service plan",
+			containers: func() []dockertest.Container {
+				app := testAppContainer("app")
+				app.Labels[model.LabelPlanPrefix+"db.schedule"] = "0 3 * * *"
+				return []dockertest.Container{app, testAppContainer("app-db")}
+			},
+			plans: []string{"backrest_sidecar_app_db"},
+			finding: LintFinding{
+				Container: "app-db",
+				Message:   "plan id backrest_sidecar_app_db is already taken by container app; this plan is skipped",
+			},
+		},
+		{
+			name: "volume plan and service plan",
+			containers: func() []dockertest.Container {
+				web := testAppContainer("web")
+				web.Labels[model.LabelGranularity] = "volume"
+				web.Mounts[0].Name = "uploads"
+				return []dockertest.Container{testAppContainer("web-uploads"), web}
+			},
+			plans: []string{"backrest_sidecar_web_uploads"},
+			finding: LintFinding{
+				Container: "web-uploads",
+				Message:   "plan id backrest_sidecar_web_uploads is already taken by container web; this plan is skipped",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t, nil, tc.containers()...)
+			result, err := env.r.Run(context.Background())
+			if err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			if result.PlansSeen != len(tc.plans) {
+				t.Fatalf("expected %d plans, got %+v", len(tc.plans), result)
+			}
+			cfg, _, err := config.Load(env.path)
+			if err != nil {
+				t.Fatalf("load config: %v", err)
+			}
+			var ids []string
+			for _, plan := range cfg.Plans {
+				ids = append(ids, plan.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tc.plans, ",") {
+				t.Fatalf("expected plans %v, got %v", tc.plans, ids)
+			}
+			report, err := env.r.Lint(context.Background())
+			if err != nil {
+				t.Fatalf("lint: %v", err)
+			}
+			if len(report.Findings) != 1 || report.Findings[0] != tc.finding {
+				t.Fatalf("expected the ID collision, got %+v", report.Findings)
+			}
+		})
+	}
+}
+
+func testAppContainer(name string) dockertest.Container {
+	return dockertest.Container{
+		Name: name,
+		Labels: map[string]string{
+			model.LabelEnable: "true",
+			model.LabelRepo:   "repo-a",
+		},
+		Mounts: []dockertypes.MountPoint{{
+			Type:        mount.TypeVolume,
+			Name:        name + "-data",
+			Destination: "/data",
+		}},
+	}
+}
+
+func testEngineOptions(engine *dockertest.Engine, path string) ReconcileOptions {
+	return ReconcileOptions{
+		ConfigPath:        path,
+		Apply:             true,
+		BackrestContainer: "backrest",
+		DockerSocket:      engine.Host(),
+		DockerRoot:        "/var/lib/docker",
+		DefaultSchedule:   "0 2 * * *",
+		PlanIDPrefix:      "backrest_sidecar_",
+		Logger:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+		ApplyMode:         ApplyRestart,
+	}
+}
+
+func writeTestConfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	seed := `{"modno": 1, "repos": [{"id": "repo-a", "uri": "/repos/a"}], "plans": []}`
+	if err := os.WriteFile(path, []byte(seed), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+// testEnv is a fake engine running containers, a seeded config.json and a
+// reconciler over both that writes the config but never applies it.
+type testEnv struct {
+	engine *dockertest.Engine
+	path   string
+	r      *Reconciler
+}
+
+// newTestEnv builds a testEnv; tune, when set, adjusts the options first.
+func newTestEnv(t *testing.T, tune func(*ReconcileOptions), containers ...dockertest.Container) *testEnv {
+	t.Helper()
+	env := &testEnv{engine: dockertest.NewEngine(t), path: writeTestConfig(t)}
+	for _, ctr := range containers {
+		env.engine.Add(ctr)
+	}
+	opts := testEngineOptions(env.engine, env.path)
+	opts.Apply = false
+	if tune != nil {
+		tune(&opts)
+	}
+	r, err := NewReconciler(opts)
+	if err != nil {
+		t.Fatalf("new reconciler: %v", err)
+	}
+	t.Cleanup(r.Close)
+	env.r = r
+	return env
+}
+
+func waitForPlan(t *testing.T, path, id string, poke func()) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cfg, _, err := config.Load(path); err == nil && cfg.FindPlan(id) != nil {
+			return
+		}
+		if poke != nil {
+			poke()
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("plan %s never appeared in %s", id, path)
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/zettaio/backrest-sidecar/internal/config"
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+)
+
+// RenderResult is what Render made of a set of containers.
+type RenderResult struct {
+	Plans   []model.Plan      `json:"plans"`
+	Skipped []ContainerRender `json:"skipped,omitempty"`
+	// Conflicts are the settings project plans resolved by precedence.
+	Conflicts []ProjectConflict `json:"conflicts,omitempty"`
+	// Config is config.json with the plans merged in; orphans are left alone.
+	Config *model.Config `json:"-"`
+}
+
+// RenderOptions configures Render.
+type RenderOptions struct {
+	ConfigPath string
+	Builder    PlanBuilderOptions
+	// DefaultRepoProvided marks Builder.DefaultRepo as set explicitly, as in
+	// ReconcileOptions.
+	DefaultRepoProvided bool
+	Logger              *slog.Logger
+}
+
+// Render builds plans for containers that did not come from Docker, such as
+// the services of a compose project, and merges them into a copy of
+// config.json. As in discovery, only containers with backrest.enable=true
+// render. Repos are checked against config.json only when it exists.
+//
+// Render needs no Docker daemon and reads no state file, so the merge is
+// plainer than a reconcile pass: every plan is upserted as its labels render
+// it, as under --override-policy=labels-win, and no orphan policy applies.
+func Render(opts RenderOptions, containers []docker.Container) (*RenderResult, error) {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	r := &Reconciler{
+		builder:             NewPlanBuilder(opts.Builder),
+		log:                 opts.Logger,
+		cfgPath:             opts.ConfigPath,
+		defaultRepoProvided: opts.DefaultRepoProvided,
+	}
+	return r.render(containers)
+}
+
+func (r *Reconciler) render(containers []docker.Container) (*RenderResult, error) {
+	cfg, loaded, err := config.Load(r.cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	r.setDefaultRepoFromConfig(cfg)
+
+	result := &RenderResult{Plans: []model.Plan{}, Config: cfg}
+	var builds []containerPlans
+	for _, ctr := range containers {
+		if ctr.Labels[model.LabelEnable] == "true" {
+			builds = append(builds, containerPlans{ctr: ctr, plans: r.builder.BuildAll(ctr)})
+		}
+	}
+	result.Conflicts = mergeProjects(builds)
+	claimPlanIDs(builds)
+	projects := map[string]bool{}
+	for _, cb := range builds {
+		for _, built := range cb.plans {
+			render := newContainerRender(cb.ctr).forPlan(built)
+			switch {
+			case built.Err != nil:
+				result.Skipped = append(result.Skipped, render.skip(built.Err.Error()))
+			case loaded != nil && !cfg.RepoExists(built.Plan.Repo):
+				result.Skipped = append(result.Skipped, render.skip(fmt.Sprintf("repo %q missing from config", built.Plan.Repo)))
+			case built.Project != "" && projects[built.ID]:
+				// already rendered for another container of the project
+			default:
+				projects[built.ID] = built.Project != ""
+				result.Plans = append(result.Plans, *built.Plan)
+			}
+		}
+	}
+	cfg.UpsertPlans(result.Plans)
+	return result, nil
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+)
+
+func TestRenderBuildsPlansForContainersWithoutDocker(t *testing.T) {
+	containers := []docker.Container{
+		testRenderContainer("app", "repo-a", true),
+		testRenderContainer("db", "repo-z", true),
+		testRenderContainer("off", "repo-a", false),
+	}
+	cases := []struct {
+		name     string
+		noConfig bool
+		plans    string
+		skipped  int
+	}{
+		{name: "repos checked against config.json", plans: "backrest_sidecar_app", skipped: 1},
+		// without a config.json there is nothing to check repos against
+		{name: "no config.json", noConfig: true, plans: "backrest_sidecar_app,backrest_sidecar_db"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if !tc.noConfig {
+				path = writeTestConfig(t)
+			}
+			result, err := Render(RenderOptions{
+				ConfigPath: path,
+				Builder: PlanBuilderOptions{
+					DockerRoot:      "/var/lib/docker",
+					DefaultSchedule: "0 2 * * *",
+					PlanIDPrefix:    "backrest_sidecar_",
+				},
+				Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			}, containers)
+			if err != nil {
+				t.Fatalf("render: %v", err)
+			}
+			var ids []string
+			for _, plan := range result.Plans {
+				ids = append(ids, plan.ID)
+				if result.Config.FindPlan(plan.ID) == nil {
+					t.Fatalf("expected %s merged into the config", plan.ID)
+				}
+			}
+			if strings.Join(ids, ",") != tc.plans || len(result.Skipped) != tc.skipped {
+				t.Fatalf("expected plans %s and %d skipped, got %+v", tc.plans, tc.skipped, result)
+			}
+		})
+	}
+}
+
+// testRenderContainer is a container as render builds it from a compose
+// service, with one named volume at /data.
+func testRenderContainer(name, repo string, enabled bool) docker.Container {
+	labels := map[string]string{model.LabelRepo: repo}
+	if enabled {
+		labels[model.LabelEnable] = "true"
+	}
+	return docker.Container{
+		ID:      name,
+		Name:    name,
+		Service: name,
+		Labels:  labels,
+		Mounts:  []dockertypes.MountPoint{{Type: mount.TypeVolume, Name: "stack_" + name, Destination: "/data"}},
+	}
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// applyScheduler coalesces config changes in the daemon so Backrest is applied
+// once per burst, ideally while it has no running operations. Without a busy
+// probe it doubles as the debounce for Docker events.
+type applyScheduler struct {
+	quiet    time.Duration
+	maxDelay time.Duration
+	poll     time.Duration
+
+	pending bool
+	first   time.Time
+	last    time.Time
+}
+
+func newApplyScheduler(quiet, maxDelay time.Duration) *applyScheduler {
+	return &applyScheduler{quiet: quiet, maxDelay: maxDelay, poll: 15 * time.Second}
+}
+
+// note records a change that needs applying and returns how long to wait
+// before the next decision.
+func (s *applyScheduler) note(now time.Time) time.Duration {
+	if !s.pending {
+		s.pending = true
+		s.first = now
+	}
+	s.last = now
+	return s.capped(now, s.quiet)
+}
+
+// decide reports whether the pending change should be applied now. When it
+// should not, wait is the delay until the next check. busy is only consulted
+// once the quiet window has elapsed.
+func (s *applyScheduler) decide(now time.Time, busy func() int) (apply bool, wait time.Duration, reason string) {
+	if !s.pending {
+		return false, 0, ""
+	}
+	if s.maxDelay > 0 && now.Sub(s.first) >= s.maxDelay {
+		return true, 0, "max-delay"
+	}
+	if left := s.quiet - now.Sub(s.last); left > 0 {
+		return false, s.capped(now, left), "quiet-window"
+	}
+	if busy != nil && busy() > 0 {
+		return false, s.capped(now, s.poll), "operations-running"
+	}
+	return true, 0, "idle"
+}
+
+// done clears the pending change after it was applied.
+func (s *applyScheduler) done() {
+	s.pending = false
+	s.first = time.Time{}
+	s.last = time.Time{}
+}
+
+// capped shortens d so the next check never lands after the max delay.
+func (s *applyScheduler) capped(now time.Time, d time.Duration) time.Duration {
+	if s.maxDelay > 0 {
+		if left := s.maxDelay - now.Sub(s.first); left < d {
+			d = left
+		}
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// runningOperations asks Backrest how many operations are in progress. Errors
+// are treated as idle so an unreachable API never blocks applying.
+func (r *Reconciler) runningOperations(ctx context.Context) int {
+	if r.idleProbe == nil {
+		return 0
+	}
+	running, err := r.idleProbe.RunningOperations(ctx, 0)
+	if err != nil {
+		r.log.Debug("backrest.operations_unknown", slog.String("error", err.Error()))
+		return 0
+	}
+	return running
+}
+
+// applyPending applies a config written by an earlier deferred pass.
+func (r *Reconciler) applyPending(ctx context.Context) (ApplyMode, error) {
+	if r.pending == nil {
+		return "", nil
+	}
+	mode, err := r.applyChange(ctx, r.pending)
+	var unhealthy *UnhealthyApplyError
+	if err != nil && !errors.As(err, &unhealthy) {
+		return "", err
+	}
+	// an unhealthy change was rolled back (or cannot be); retrying it would not help
+	r.pending = nil
+	return mode, err
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplySchedulerCoalescesBurstUntilQuiet(t *testing.T) {
+	s := newApplyScheduler(10*time.Second, time.Minute)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if wait := s.note(start); wait != 10*time.Second {
+		t.Fatalf("expected full quiet window, got %s", wait)
+	}
+	s.note(start.Add(5 * time.Second))
+
+	apply, wait, reason := s.decide(start.Add(10*time.Second), nil)
+	if apply || wait != 5*time.Second || reason != "quiet-window" {
+		t.Fatalf("expected to wait out the quiet window after the second change, got apply=%v wait=%s reason=%s", apply, wait, reason)
+	}
+	apply, _, reason = s.decide(start.Add(15*time.Second), func() int { return 0 })
+	if !apply || reason != "idle" {
+		t.Fatalf("expected apply once quiet and idle, got apply=%v reason=%s", apply, reason)
+	}
+}
+
+func TestApplySchedulerWaitsForRunningOperationsUpToMaxDelay(t *testing.T) {
+	s := newApplyScheduler(time.Second, 20*time.Second)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.note(start)
+	busy := func() int { return 1 }
+
+	apply, wait, reason := s.decide(start.Add(2*time.Second), busy)
+	if apply || reason != "operations-running" || wait != 15*time.Second {
+		t.Fatalf("expected to poll while busy, got apply=%v wait=%s reason=%s", apply, wait, reason)
+	}
+	apply, wait, _ = s.decide(start.Add(17*time.Second), busy)
+	if apply || wait != 3*time.Second {
+		t.Fatalf("expected poll to be capped by max delay, got apply=%v wait=%s", apply, wait)
+	}
+	apply, _, reason = s.decide(start.Add(20*time.Second), busy)
+	if !apply || reason != "max-delay" {
+		t.Fatalf("expected max delay to force apply, got apply=%v reason=%s", apply, reason)
+	}
+
+	s.done()
+	if apply, _, _ := s.decide(start.Add(time.Hour), nil); apply {
+		t.Fatalf("expected nothing to apply after done")
+	}
+}
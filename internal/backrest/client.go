@@ -0,0 +1,210 @@
+package backrest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnreachable marks failures where the Backrest API could not be reached at
+// all (connection refused, timeouts, gateway errors), as opposed to requests
+// Backrest received and rejected.
+var ErrUnreachable = errors.New("backrest api unreachable")
+
+// Options configures the Backrest API client.
+type Options struct {
+	URL        string
+	Username   string
+	Password   string
+	Token      string
+	Timeout    time.Duration
+	HTTPClient *http.Client
+}
+
+// Client talks to Backrest's Connect JSON API.
+type Client struct {
+	base     string
+	username string
+	password string
+	http     *http.Client
+
+	mu    sync.Mutex
+	token string
+	// static tokens are never refreshed through the login endpoint.
+	static bool
+}
+
+// APIError is a non-success response from Backrest.
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("backrest api %d %s: %s", e.Status, e.Code, e.Message)
+	}
+	return fmt.Sprintf("backrest api %d", e.Status)
+}
+
+// New validates options and returns a client.
+func New(opts Options) (*Client, error) {
+	raw := strings.TrimSpace(opts.URL)
+	if raw == "" {
+		return nil, errors.New("backrest url required")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid backrest url %q", raw)
+	}
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		timeout := opts.Timeout
+		if timeout == 0 {
+			timeout = 15 * time.Second
+		}
+		httpClient = &http.Client{Timeout: timeout}
+	}
+	return &Client{
+		base:     strings.TrimSuffix(u.String(), "/"),
+		username: opts.Username,
+		password: opts.Password,
+		http:     httpClient,
+		token:    opts.Token,
+		static:   opts.Token != "",
+	}, nil
+}
+
+// GetConfig returns Backrest's in-memory config.
+func (c *Client) GetConfig(ctx context.Context) (json.RawMessage, error) {
+	var out json.RawMessage
+	if err := c.call(ctx, "/v1.Backrest/GetConfig", json.RawMessage("{}"), &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SetConfig replaces Backrest's config without a restart. Backrest validates the
+// payload's modno against its in-memory copy and persists the result itself.
+func (c *Client) SetConfig(ctx context.Context, cfg json.RawMessage) error {
+	return c.call(ctx, "/v1.Backrest/SetConfig", cfg, nil)
+}
+
+// RunningOperations returns how many of the most recent operations Backrest
+// reports as in progress. Only the latest lastN operations are inspected since
+// running operations are always among the newest.
+func (c *Client) RunningOperations(ctx context.Context, lastN int) (int, error) {
+	if lastN <= 0 {
+		lastN = 100
+	}
+	req, err := json.Marshal(map[string]any{"selector": map[string]any{}, "lastN": lastN})
+	if err != nil {
+		return 0, err
+	}
+	var resp struct {
+		Operations []struct {
+			Status string `json:"status"`
+		} `json:"operations"`
+	}
+	if err := c.call(ctx, "/v1.Backrest/GetOperations", req, &resp); err != nil {
+		return 0, err
+	}
+	running := 0
+	for _, op := range resp.Operations {
+		if op.Status == "STATUS_INPROGRESS" {
+			running++
+		}
+	}
+	return running, nil
+}
+
+func (c *Client) call(ctx context.Context, procedure string, body json.RawMessage, out any) error {
+	token, err := c.ensureToken(ctx)
+	if err != nil {
+		return err
+	}
+	err = c.post(ctx, procedure, body, out, token)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized && !c.static && c.username != "" {
+		// token expired; log in again once
+		c.mu.Lock()
+		c.token = ""
+		c.mu.Unlock()
+		if token, err = c.ensureToken(ctx); err != nil {
+			return err
+		}
+		return c.post(ctx, procedure, body, out, token)
+	}
+	return err
+}
+
+func (c *Client) ensureToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" || c.username == "" {
+		return c.token, nil
+	}
+	creds, err := json.Marshal(map[string]string{"username": c.username, "password": c.password})
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := c.post(ctx, "/v1.Authentication/Login", creds, &resp, ""); err != nil {
+		return "", fmt.Errorf("backrest login: %w", err)
+	}
+	if resp.Token == "" {
+		return "", errors.New("backrest login: empty token")
+	}
+	c.token = resp.Token
+	return c.token, nil
+}
+
+func (c *Client) post(ctx context.Context, procedure string, body json.RawMessage, out any, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+procedure, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return fmt.Errorf("%w: read response: %v", ErrUnreachable, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &APIError{Status: resp.StatusCode}
+		_ = json.Unmarshal(data, apiErr)
+		switch resp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return fmt.Errorf("%w: %w", ErrUnreachable, apiErr)
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decode %s response: %w", procedure, err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package backrest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// standIn is a minimal Backrest Connect API used by the tests.
+type standIn struct {
+	logins    int
+	lastAuth  string
+	lastBody  []byte
+	rejectFor int
+}
+
+func (s *standIn) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.Authentication/Login", func(w http.ResponseWriter, r *http.Request) {
+		var creds struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&creds)
+		if creds.Username != "admin" || creds.Password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"code":"unauthenticated","message":"bad credentials"}`))
+			return
+		}
+		s.logins++
+		_, _ = w.Write([]byte(`{"token":"jwt-` + strconv.Itoa(s.logins) + `"}`))
+	})
+	mux.HandleFunc("/v1.Backrest/SetConfig", func(w http.ResponseWriter, r *http.Request) {
+		s.lastAuth = r.Header.Get("Authorization")
+		if s.rejectFor > 0 {
+			s.rejectFor--
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"code":"unauthenticated","message":"token expired"}`))
+			return
+		}
+		s.lastBody, _ = io.ReadAll(r.Body)
+		_, _ = w.Write(s.lastBody)
+	})
+	return mux
+}
+
+func TestSetConfigLogsInAndSendsBearerToken(t *testing.T) {
+	stand := &standIn{}
+	srv := httptest.NewServer(stand.handler())
+	defer srv.Close()
+
+	client, err := New(Options{URL: srv.URL, Username: "admin", Password: "secret"})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	payload := json.RawMessage(`{"modno":3,"plans":[]}`)
+	if err := client.SetConfig(context.Background(), payload); err != nil {
+		t.Fatalf("set config: %v", err)
+	}
+	if stand.lastAuth != "Bearer jwt-1" {
+		t.Fatalf("unexpected authorization header %q", stand.lastAuth)
+	}
+	if string(stand.lastBody) != string(payload) {
+		t.Fatalf("unexpected body %s", stand.lastBody)
+	}
+}
+
+func TestSetConfigReauthenticatesOnExpiredToken(t *testing.T) {
+	stand := &standIn{rejectFor: 1}
+	srv := httptest.NewServer(stand.handler())
+	defer srv.Close()
+
+	client, err := New(Options{URL: srv.URL, Username: "admin", Password: "secret"})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	if err := client.SetConfig(context.Background(), json.RawMessage(`{}`)); err != nil {
+		t.Fatalf("set config: %v", err)
+	}
+	if stand.logins != 2 || stand.lastAuth != "Bearer jwt-2" {
+		t.Fatalf("expected a second login, got logins=%d auth=%q", stand.logins, stand.lastAuth)
+	}
+}
+
+func TestSetConfigClassifiesErrors(t *testing.T) {
+	stand := &standIn{}
+	srv := httptest.NewServer(stand.handler())
+
+	client, err := New(Options{URL: srv.URL, Username: "admin", Password: "wrong"})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	err = client.SetConfig(context.Background(), json.RawMessage(`{}`))
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnauthorized || errors.Is(err, ErrUnreachable) {
+		t.Fatalf("expected auth failure to be an APIError, got %v", err)
+	}
+
+	srv.Close()
+	client, err = New(Options{URL: srv.URL, Token: "static"})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	if err := client.SetConfig(context.Background(), json.RawMessage(`{}`)); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("expected closed server to be unreachable, got %v", err)
+	}
+}
+
+func TestRunningOperationsCountsInProgress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1.Backrest/GetOperations" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"operations":[{"status":"STATUS_SUCCESS"},{"status":"STATUS_INPROGRESS"},{"status":"STATUS_INPROGRESS"}]}`))
+	}))
+	defer srv.Close()
+
+	client, err := New(Options{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	running, err := client.RunningOperations(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("running operations: %v", err)
+	}
+	if running != 2 {
+		t.Fatalf("expected 2 running operations, got %d", running)
+	}
+}
@@ -0,0 +1,391 @@
+// Package compose reads Docker Compose files into the containers the stack
+// would create, so plans can be rendered without a Docker daemon.
+package compose
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"gopkg.in/yaml.v3"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+	"github.com/zettaio/backrest-sidecar/internal/model"
+)
+
+// Options selects the compose files and project name, like docker compose's
+// -f and -p flags.
+type Options struct {
+	Files   []string
+	Project string
+	// LookupEnv resolves ${VAR} references; nil uses the process environment
+	// and the .env file next to the first compose file.
+	LookupEnv func(key string) (string, bool)
+}
+
+// Project is a parsed compose project.
+type Project struct {
+	Name     string
+	Dir      string
+	Services []Service
+}
+
+// Service is one compose service with its labels and mounts resolved the way
+// Docker would see them. An x-backrest block is in Labels as backrest.spec.
+type Service struct {
+	Name          string
+	ContainerName string
+	Labels        map[string]string
+	Mounts        []dockertypes.MountPoint
+}
+
+type file struct {
+	Name     string                 `yaml:"name"`
+	Services map[string]serviceFile `yaml:"services"`
+	Volumes  map[string]volumeFile  `yaml:"volumes"`
+}
+
+type serviceFile struct {
+	ContainerName string      `yaml:"container_name"`
+	Labels        yaml.Node   `yaml:"labels"`
+	Volumes       []yaml.Node `yaml:"volumes"`
+	// Backrest is the x-backrest extension, read into the backrest.spec label.
+	Backrest yaml.Node `yaml:"x-backrest"`
+}
+
+type volumeFile struct {
+	Name     string    `yaml:"name"`
+	External yaml.Node `yaml:"external"`
+}
+
+// volumeMount is a service volume entry in either short or long syntax.
+type volumeMount struct {
+	Type   string `yaml:"type"`
+	Source string `yaml:"source"`
+	Target string `yaml:"target"`
+}
+
+// Load parses and merges the compose files in order. Later files override
+// the project name, container names and volume definitions, merge labels
+// key by key and replace mounts with the same target.
+func Load(opts Options) (*Project, error) {
+	if len(opts.Files) == 0 {
+		return nil, fmt.Errorf("compose: no files given")
+	}
+	dir, err := filepath.Abs(filepath.Dir(opts.Files[0]))
+	if err != nil {
+		return nil, fmt.Errorf("compose: %w", err)
+	}
+	lookup := opts.LookupEnv
+	if lookup == nil {
+		lookup, err = envLookup(filepath.Join(dir, ".env"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	merged := file{Services: map[string]serviceFile{}, Volumes: map[string]volumeFile{}}
+	services := map[string]*mergedService{}
+	var order []string
+	for _, path := range opts.Files {
+		f, err := readFile(path, lookup)
+		if err != nil {
+			return nil, err
+		}
+		if f.Name != "" {
+			merged.Name = f.Name
+		}
+		for name, vol := range f.Volumes {
+			merged.Volumes[name] = vol
+		}
+		for _, name := range sortedKeys(f.Services) {
+			svc, ok := services[name]
+			if !ok {
+				svc = &mergedService{labels: map[string]string{}}
+				services[name] = svc
+				order = append(order, name)
+			}
+			if err := svc.merge(f.Services[name]); err != nil {
+				return nil, fmt.Errorf("compose: %s: service %s: %w", path, name, err)
+			}
+		}
+	}
+
+	project := &Project{Dir: dir, Name: projectName(opts.Project, merged.Name, lookup, dir)}
+	if project.Name == "" {
+		return nil, fmt.Errorf("compose: cannot derive a project name from %s; pass --project", dir)
+	}
+	sort.Strings(order)
+	for _, name := range order {
+		svc := services[name]
+		mounts := make([]dockertypes.MountPoint, 0, len(svc.mounts))
+		for _, m := range svc.mounts {
+			point, err := project.resolveMount(m, merged.Volumes)
+			if err != nil {
+				return nil, fmt.Errorf("compose: service %s: %w", name, err)
+			}
+			mounts = append(mounts, point)
+		}
+		project.Services = append(project.Services, Service{
+			Name:          name,
+			ContainerName: svc.containerName,
+			Labels:        svc.labels,
+			Mounts:        mounts,
+		})
+	}
+	return project, nil
+}
+
+func readFile(path string, lookup func(string) (string, bool)) (file, error) {
+	var f file
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return f, fmt.Errorf("compose: %w", err)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return f, fmt.Errorf("compose: %s: %w", path, err)
+	}
+	if err := interpolateNode(&root, lookup); err != nil {
+		return f, fmt.Errorf("compose: %s: %w", path, err)
+	}
+	if err := root.Decode(&f); err != nil {
+		return f, fmt.Errorf("compose: %s: %w", path, err)
+	}
+	return f, nil
+}
+
+type mergedService struct {
+	containerName string
+	labels        map[string]string
+	mounts        []volumeMount
+}
+
+func (s *mergedService) merge(svc serviceFile) error {
+	if svc.ContainerName != "" {
+		s.containerName = svc.ContainerName
+	}
+	labels, err := decodeLabels(&svc.Labels)
+	if err != nil {
+		return err
+	}
+	for k, v := range labels {
+		s.labels[k] = v
+	}
+	if svc.Backrest.Kind != 0 {
+		spec, err := specLabel(&svc.Backrest)
+		if err != nil {
+			return err
+		}
+		s.labels[model.LabelSpec] = spec
+	}
+	for i := range svc.Volumes {
+		m, err := decodeVolume(&svc.Volumes[i])
+		if err != nil {
+			return err
+		}
+		replaced := false
+		for j := range s.mounts {
+			if s.mounts[j].Target == m.Target {
+				s.mounts[j], replaced = m, true
+			}
+		}
+		if !replaced {
+			s.mounts = append(s.mounts, m)
+		}
+	}
+	return nil
+}
+
+// decodeLabels accepts both the mapping and the "key=value" list form.
+func decodeLabels(node *yaml.Node) (map[string]string, error) {
+	labels := map[string]string{}
+	switch node.Kind {
+	case 0:
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			labels[node.Content[i].Value] = node.Content[i+1].Value
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			key, value, _ := strings.Cut(item.Value, "=")
+			labels[key] = value
+		}
+	default:
+		return nil, fmt.Errorf("labels must be a mapping or a list (line %d)", node.Line)
+	}
+	return labels, nil
+}
+
+// specLabel renders an x-backrest block as the JSON backrest.spec label.
+func specLabel(node *yaml.Node) (string, error) {
+	if node.Kind != yaml.MappingNode {
+		return "", fmt.Errorf("x-backrest must be a mapping (line %d)", node.Line)
+	}
+	var block map[string]any
+	if err := node.Decode(&block); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(block)
+	if err != nil {
+		return "", fmt.Errorf("x-backrest: %w", err)
+	}
+	return string(data), nil
+}
+
+// decodeVolume accepts "source:target[:mode]", a bare target (an anonymous
+// volume) or the long syntax.
+func decodeVolume(node *yaml.Node) (volumeMount, error) {
+	var m volumeMount
+	if node.Kind == yaml.MappingNode {
+		if err := node.Decode(&m); err != nil {
+			return m, err
+		}
+		if m.Type == "" {
+			m.Type = string(mount.TypeVolume)
+		}
+	} else {
+		parts := strings.Split(node.Value, ":")
+		switch len(parts) {
+		case 1:
+			m.Target = parts[0]
+		case 2, 3:
+			m.Source, m.Target = parts[0], parts[1]
+		default:
+			return m, fmt.Errorf("cannot parse volume %q (line %d)", node.Value, node.Line)
+		}
+		m.Type = string(mount.TypeVolume)
+		if isPath(m.Source) {
+			m.Type = string(mount.TypeBind)
+		}
+	}
+	if m.Target == "" {
+		return m, fmt.Errorf("volume without a target (line %d)", node.Line)
+	}
+	return m, nil
+}
+
+func isPath(source string) bool {
+	return strings.HasPrefix(source, "/") || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~")
+}
+
+// resolveMount turns a service volume into the mount Docker would report:
+// named volumes get their project-scoped name, bind sources an absolute path.
+func (p *Project) resolveMount(m volumeMount, volumes map[string]volumeFile) (dockertypes.MountPoint, error) {
+	point := dockertypes.MountPoint{Type: mount.Type(m.Type), Destination: m.Target}
+	switch point.Type {
+	case mount.TypeBind:
+		source := m.Source
+		if strings.HasPrefix(source, "~") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return point, err
+			}
+			source = home + source[1:]
+		}
+		if !filepath.IsAbs(source) {
+			source = filepath.Join(p.Dir, source)
+		}
+		point.Source = filepath.Clean(source)
+	case mount.TypeVolume:
+		if m.Source == "" {
+			// anonymous volumes get a random name at create time
+			return point, nil
+		}
+		vol, ok := volumes[m.Source]
+		if !ok {
+			return point, fmt.Errorf("volume %q is not declared under top-level volumes", m.Source)
+		}
+		point.Name = vol.dockerName(p.Name, m.Source)
+	}
+	return point, nil
+}
+
+// dockerName is the name compose gives the volume: its explicit name,
+// the key for external volumes, else "<project>_<key>".
+func (v volumeFile) dockerName(project, key string) string {
+	if v.Name != "" {
+		return v.Name
+	}
+	var external bool
+	if v.External.Kind == yaml.ScalarNode && v.External.Decode(&external) == nil && external {
+		return key
+	}
+	if v.External.Kind == yaml.MappingNode {
+		var ext struct {
+			Name string `yaml:"name"`
+		}
+		if v.External.Decode(&ext) == nil && ext.Name != "" {
+			return ext.Name
+		}
+		return key
+	}
+	return project + "_" + key
+}
+
+// projectName applies compose's precedence: -p, the top-level name,
+// COMPOSE_PROJECT_NAME, then the directory of the first file.
+func projectName(flag, fromFile string, lookup func(string) (string, bool), dir string) string {
+	name := flag
+	if name == "" {
+		name = fromFile
+	}
+	if name == "" {
+		name, _ = lookup("COMPOSE_PROJECT_NAME")
+	}
+	if name == "" {
+		name = filepath.Base(dir)
+	}
+	return NormalizeProjectName(name)
+}
+
+var projectNameChars = regexp.MustCompile(`[a-z0-9_-]`)
+
+// NormalizeProjectName lowercases name and drops what compose does not allow.
+func NormalizeProjectName(name string) string {
+	name = strings.Join(projectNameChars.FindAllString(strings.ToLower(name), -1), "")
+	return strings.TrimLeft(name, "_-")
+}
+
+// Containers returns the containers the project would create, named the way
+// compose names them and labelled with the compose project and service.
+func (p *Project) Containers() []docker.Container {
+	out := make([]docker.Container, 0, len(p.Services))
+	for _, svc := range p.Services {
+		name := svc.ContainerName
+		if name == "" {
+			name = fmt.Sprintf("%s-%s-1", p.Name, svc.Name)
+		}
+		labels := make(map[string]string, len(svc.Labels)+2)
+		for k, v := range svc.Labels {
+			labels[k] = v
+		}
+		labels[model.LabelComposeProject] = p.Name
+		labels[model.LabelComposeService] = svc.Name
+		out = append(out, docker.Container{
+			ID:      name,
+			Name:    name,
+			Labels:  labels,
+			Mounts:  svc.Mounts,
+			Project: p.Name,
+			Service: svc.Name,
+			State:   "created",
+		})
+	}
+	return out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
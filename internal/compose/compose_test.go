@@ -0,0 +1,139 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestLoadResolvesNamesMountsAndOverrides(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "My Stack")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	base := `
+services:
+  db:
+    labels:
+      backrest.enable: true
+      backrest.schedule: ${SCHEDULE:-0 3 * * *}
+      backrest.snapshot-start: echo $$HOME
+    volumes:
+      - pgdata:/var/lib/postgresql/data
+      - ./init:/init:ro
+      - /cache
+  web:
+    container_name: web
+    labels: ["backrest.enable=true"]
+    volumes:
+      - type: volume
+        source: uploads
+        target: /uploads
+volumes:
+  pgdata:
+  uploads:
+    external: true
+`
+	override := `
+services:
+  db:
+    labels:
+      backrest.keep: daily=3
+    volumes:
+      - type: bind
+        source: /srv/init
+        target: /init
+`
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return path
+	}
+	files := []string{write("compose.yaml", base), write("compose.override.yaml", override)}
+	env := map[string]string{}
+	lookup := func(key string) (string, bool) { v, ok := env[key]; return v, ok }
+
+	project, err := Load(Options{Files: files, LookupEnv: lookup})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if project.Name != "mystack" {
+		t.Fatalf("expected the directory name normalized, got %q", project.Name)
+	}
+	containers := project.Containers()
+	if len(containers) != 2 {
+		t.Fatalf("expected two containers, got %+v", containers)
+	}
+	db, web := containers[0], containers[1]
+	if db.Name != "mystack-db-1" || db.Project != "mystack" || db.Labels["com.docker.compose.service"] != "db" {
+		t.Fatalf("unexpected db container %+v", db)
+	}
+	if db.Labels["backrest.schedule"] != "0 3 * * *" || db.Labels["backrest.keep"] != "daily=3" || db.Labels["backrest.snapshot-start"] != "echo $HOME" {
+		t.Fatalf("unexpected db labels %v", db.Labels)
+	}
+	if len(db.Mounts) != 3 {
+		t.Fatalf("expected three db mounts, got %+v", db.Mounts)
+	}
+	if m := db.Mounts[0]; m.Type != mount.TypeVolume || m.Name != "mystack_pgdata" {
+		t.Fatalf("expected a project-scoped volume, got %+v", m)
+	}
+	if m := db.Mounts[1]; m.Type != mount.TypeBind || m.Source != "/srv/init" {
+		t.Fatalf("expected the override to replace the bind mount, got %+v", m)
+	}
+	if m := db.Mounts[2]; m.Type != mount.TypeVolume || m.Name != "" || m.Destination != "/cache" {
+		t.Fatalf("expected an anonymous volume, got %+v", m)
+	}
+	if web.Name != "web" || web.Labels["backrest.enable"] != "true" || web.Mounts[0].Name != "uploads" {
+		t.Fatalf("unexpected web container %+v", web)
+	}
+
+	env["SCHEDULE"] = "0 4 * * *"
+	project, err = Load(Options{Files: files[:1], Project: "Prod", LookupEnv: lookup})
+	if err != nil {
+		t.Fatalf("load with project: %v", err)
+	}
+	db = project.Containers()[0]
+	if db.Name != "prod-db-1" || db.Mounts[0].Name != "prod_pgdata" || db.Mounts[1].Source != filepath.Join(dir, "init") {
+		t.Fatalf("unexpected db container with --project %+v", db)
+	}
+	if db.Labels["backrest.schedule"] != "0 4 * * *" {
+		t.Fatalf("expected the variable to be substituted, got %q", db.Labels["backrest.schedule"])
+	}
+
+	bad := write("bad.yaml", "services:\n  app:\n    volumes: [\"missing:/data\"]\n")
+	if _, err := Load(Options{Files: []string{bad}, LookupEnv: lookup}); err == nil {
+		t.Fatalf("expected an undeclared volume to fail")
+	}
+}
+
+func TestLoadReadsXBackrestIntoTheSpecLabel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compose.yaml")
+	data := `
+services:
+  db:
+    labels:
+      backrest.enable: "true"
+      backrest.spec: '{"repo": "from-label"}'
+    x-backrest:
+      repo: repo-a
+      keep: {daily: 7}
+      hooks:
+        snapshot-start:
+          - pg_dump -t a,b -f /dump.sql
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	project, err := Load(Options{Files: []string{path}, Project: "app", LookupEnv: func(string) (string, bool) { return "", false }})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := `{"hooks":{"snapshot-start":["pg_dump -t a,b -f /dump.sql"]},"keep":{"daily":7},"repo":"repo-a"}`
+	if got := project.Services[0].Labels["backrest.spec"]; got != want {
+		t.Fatalf("expected x-backrest to replace the spec label:\n got %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,166 @@
+package compose
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envLookup resolves variables from the environment first, then from the
+// project's .env file, as compose does.
+func envLookup(dotenv string) (func(string) (string, bool), error) {
+	vars := map[string]string{}
+	f, err := os.Open(dotenv)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("compose: %w", err)
+	default:
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+			if !ok {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+				value = value[1 : len(value)-1]
+			}
+			vars[strings.TrimSpace(key)] = value
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("compose: %s: %w", dotenv, err)
+		}
+	}
+	return func(key string) (string, bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			return v, true
+		}
+		v, ok := vars[key]
+		return v, ok
+	}, nil
+}
+
+// interpolateNode substitutes variables in every scalar value (not keys).
+func interpolateNode(node *yaml.Node, lookup func(string) (string, bool)) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		value, err := interpolate(node.Value, lookup)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		node.Value = value
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := interpolateNode(node.Content[i], lookup); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, child := range node.Content {
+			if err := interpolateNode(child, lookup); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// interpolate expands $VAR, ${VAR}, ${VAR:-default}, ${VAR-default},
+// ${VAR:?error} and ${VAR?error}; "$$" is a literal dollar.
+func interpolate(s string, lookup func(string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			out.WriteByte(s[i])
+			continue
+		}
+		next := s[i+1]
+		switch {
+		case next == '$':
+			out.WriteByte('$')
+			i++
+		case next == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable in %q", s)
+			}
+			value, err := expand(s[i+2:i+end], lookup)
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(value)
+			i += end
+		case isNameChar(next, true):
+			j := i + 1
+			for j < len(s) && isNameChar(s[j], false) {
+				j++
+			}
+			value, _ := lookup(s[i+1 : j])
+			out.WriteString(value)
+			i = j - 1
+		default:
+			out.WriteByte('$')
+		}
+	}
+	return out.String(), nil
+}
+
+func expand(expr string, lookup func(string) (string, bool)) (string, error) {
+	for _, op := range []string{":-", ":?", "-", "?"} {
+		name, arg, ok := strings.Cut(expr, op)
+		if !ok || !validName(name) {
+			continue
+		}
+		value, set := lookup(name)
+		empty := !set || (op[0] == ':' && value == "")
+		switch {
+		case !empty:
+			return value, nil
+		case strings.HasSuffix(op, "-"):
+			return arg, nil
+		default:
+			return "", fmt.Errorf("required variable %s is missing: %s", name, arg)
+		}
+	}
+	if !validName(expr) {
+		return "", fmt.Errorf("invalid variable ${%s}", expr)
+	}
+	value, _ := lookup(expr)
+	return value, nil
+}
+
+func validName(name string) bool {
+	if name == "" || !isNameChar(name[0], true) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isNameChar(name[i], false) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNameChar(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
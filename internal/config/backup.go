@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	fsutil "github.com/zettaio/backrest-sidecar/internal/util/fs"
+)
+
+// BackupPath is where the last known-good config is kept while a change is applied.
+func BackupPath(path string) string {
+	return path + ".bak"
+}
+
+// SaveBackup stores the config bytes that are about to be replaced.
+func SaveBackup(path string, data []byte) error {
+	if err := fsutil.AtomicWrite(BackupPath(path), data, 0o644); err != nil {
+		return fmt.Errorf("save config backup: %w", err)
+	}
+	return nil
+}
+
+// RestoreBackup atomically puts the saved copy back in place and returns the
+// restored bytes.
+func RestoreBackup(path string) ([]byte, error) {
+	data, err := os.ReadFile(BackupPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("read config backup: %w", err)
+	}
+	if err := fsutil.AtomicWrite(path, data, 0o644); err != nil {
+		return nil, fmt.Errorf("restore config backup: %w", err)
+	}
+	return data, nil
+}
@@ -1,11 +1,14 @@
 package config
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
 	"os"
+	"strconv"
 
 	"github.com/zettaio/backrest-sidecar/internal/model"
 	fsutil "github.com/zettaio/backrest-sidecar/internal/util/fs"
@@ -18,6 +21,7 @@ func Load(path string) (*model.Config, []byte, error) {
 		if errors.Is(err, fs.ErrNotExist) {
 			cfg := &model.Config{}
 			cfg.EnsureNonNil()
+			cfg.MarkLoaded()
 			return cfg, nil, nil
 		}
 		return nil, nil, fmt.Errorf("read config: %w", err)
@@ -42,36 +46,103 @@ func Load(path string) (*model.Config, []byte, error) {
 	}
 	cfg.SetExtras(raw)
 	cfg.EnsureNonNil()
+	cfg.MarkLoaded()
 	return cfg, data, nil
 }
 
-// Write writes the config atomically.
+// ErrConflict reports that the config file changed since it was loaded.
+var ErrConflict = errors.New("config changed since it was loaded")
+
+// LockPath returns the advisory lock file guarding writes to a config path.
+func LockPath(path string) string {
+	return path + ".lock"
+}
+
+// Lock takes the advisory lock shared by sidecar processes writing path.
+func Lock(ctx context.Context, path string) (*fsutil.Lock, error) {
+	return fsutil.AcquireLock(ctx, LockPath(path))
+}
+
+// Write writes the config atomically. When the plans differ from the ones
+// loaded, Backrest's modno is incremented so it treats the file as a new
+// revision; an unchanged config keeps its modno.
 func Write(path string, cfg *model.Config) ([]byte, error) {
+	data, modno, err := marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := fsutil.AtomicWrite(path, append(data, '\n'), 0o644); err != nil {
+		return nil, err
+	}
+	written(cfg, modno)
+	return data, nil
+}
+
+// WriteIfUnchanged writes the config only if the file still holds loaded, the
+// bytes returned by Load (nil when the file did not exist). Otherwise it
+// returns ErrConflict so the caller can merge against the fresh file.
+func WriteIfUnchanged(path string, cfg *model.Config, loaded []byte) ([]byte, error) {
+	data, modno, err := marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	current, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	if !bytes.Equal(current, loaded) || (current == nil) != (loaded == nil) {
+		return nil, ErrConflict
+	}
+	if err := fsutil.AtomicWrite(path, append(data, '\n'), 0o644); err != nil {
+		return nil, err
+	}
+	written(cfg, modno)
+	return data, nil
+}
+
+// written makes the just-written config the new baseline so writing it again
+// does not bump modno twice.
+func written(cfg *model.Config, modno int64) {
+	if modno != cfg.Modno() {
+		cfg.SetModno(modno)
+	}
+	cfg.MarkLoaded()
+}
+
+// Marshal renders cfg exactly as Write would write it, without writing.
+func Marshal(cfg *model.Config) ([]byte, error) {
+	data, _, err := marshal(cfg)
+	return data, err
+}
+
+func marshal(cfg *model.Config) ([]byte, int64, error) {
 	cfg.EnsureNonNil()
 	out := make(map[string]json.RawMessage, len(cfg.Extras())+2)
 	for k, v := range cfg.Extras() {
 		out[k] = v
 	}
+	modno := cfg.Modno()
+	if cfg.PlansChanged() {
+		modno++
+		out["modno"] = json.RawMessage(strconv.FormatInt(modno, 10))
+	}
 	plansBytes, err := json.Marshal(cfg.Plans)
 	if err != nil {
-		return nil, fmt.Errorf("marshal plans: %w", err)
+		return nil, 0, fmt.Errorf("marshal plans: %w", err)
 	}
 	if rawRepos := cfg.RawRepos(); len(rawRepos) > 0 {
 		out["repos"] = rawRepos
 	} else {
 		reposBytes, err := json.Marshal(cfg.Repos)
 		if err != nil {
-			return nil, fmt.Errorf("marshal repos: %w", err)
+			return nil, 0, fmt.Errorf("marshal repos: %w", err)
 		}
 		out["repos"] = reposBytes
 	}
 	out["plans"] = plansBytes
 	data, err := json.MarshalIndent(out, "", "  ")
 	if err != nil {
-		return nil, fmt.Errorf("marshal config: %w", err)
+		return nil, 0, fmt.Errorf("marshal config: %w", err)
 	}
-	if err := fsutil.AtomicWrite(path, append(data, '\n'), 0o644); err != nil {
-		return nil, err
-	}
-	return data, nil
+	return data, modno, nil
 }
@@ -1,10 +1,15 @@
 package config
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/zettaio/backrest-sidecar/internal/model"
 )
@@ -52,3 +57,216 @@ func TestWritePreservesRepoExtras(t *testing.T) {
 		t.Fatalf("expected auto_initialize field to be preserved, got: %s", output)
 	}
 }
+
+func TestWritePreservesUnknownPlanAndHookFields(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	manual := `{"id":"manual","repo":"repo","paths":["/b","/a"],"schedule":{"maxFrequencyDays":1,"clock":"CLOCK_LOCAL"},"backup_flags":["--one-file-system"],"skipIfUnchanged":true,"hooks":[{"conditions":["CONDITION_SNAPSHOT_ERROR"],"actionWebhook":{"webhookUrl":"https://example.invalid"}},{"conditions":["CONDITION_SNAPSHOT_START"],"onError":"ON_ERROR_FATAL","actionCommand":{"command":"echo hi"}}]}`
+	seed := `{"repos":[{"id":"repo"}],"plans":[` + manual + `]}`
+	if err := os.WriteFile(path, []byte(seed), 0o644); err != nil {
+		t.Fatalf("write seed config: %v", err)
+	}
+
+	cfg, _, err := Load(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	cfg.Plans = append(cfg.Plans, model.Plan{ID: "added", Repo: "repo", Paths: []string{"/data"}})
+	cfg.Normalize()
+	if _, err := Write(path, cfg); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	var written struct {
+		Plans []json.RawMessage `json:"plans"`
+	}
+	if err := json.Unmarshal(out, &written); err != nil {
+		t.Fatalf("parse written config: %v", err)
+	}
+	if len(written.Plans) != 2 {
+		t.Fatalf("expected 2 plans, got %d", len(written.Plans))
+	}
+	var got bytes.Buffer
+	if err := json.Compact(&got, written.Plans[1]); err != nil {
+		t.Fatalf("compact plan: %v", err)
+	}
+	if got.String() != manual {
+		t.Fatalf("manual plan was rewritten:\n got: %s\nwant: %s", got.String(), manual)
+	}
+}
+
+func TestRoundTripKeepsHookOnError(t *testing.T) {
+	cfg, _, err := Load(filepath.Join("..", "..", "testdata", "example-backrest.config.json"))
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if _, err := Write(path, cfg); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	if got := strings.Count(string(out), "ON_ERROR_FATAL"); got != 2 {
+		t.Fatalf("expected both onError fields to survive, found %d: %s", got, out)
+	}
+}
+
+func TestWriteIfUnchangedRejectsConcurrentEdit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	seed := `{"modno": 1, "repos": [], "plans": []}`
+	if err := os.WriteFile(path, []byte(seed), 0o644); err != nil {
+		t.Fatalf("write seed config: %v", err)
+	}
+	cfg, loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+
+	uiEdit := `{"modno": 2, "repos": [], "plans": [{"id": "manual", "repo": "r", "paths": ["/x"], "schedule": {"clock": "CLOCK_LOCAL"}}]}`
+	if err := os.WriteFile(path, []byte(uiEdit), 0o644); err != nil {
+		t.Fatalf("simulate ui save: %v", err)
+	}
+
+	cfg.Plans = []model.Plan{{ID: "plan-alpha", Repo: "r", Paths: []string{"/data"}}}
+	if _, err := WriteIfUnchanged(path, cfg, loaded); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	onDisk, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	if string(onDisk) != uiEdit {
+		t.Fatalf("ui edit was clobbered: %s", onDisk)
+	}
+
+	cfg, loaded, err = Load(path)
+	if err != nil {
+		t.Fatalf("reload config: %v", err)
+	}
+	if _, err := WriteIfUnchanged(path, cfg, loaded); err != nil {
+		t.Fatalf("write after reload: %v", err)
+	}
+}
+
+func TestWriteIfUnchangedTreatsCreatedFileAsConflict(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	cfg, loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("load missing config: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(`{}`), 0o644); err != nil {
+		t.Fatalf("create config: %v", err)
+	}
+	if _, err := WriteIfUnchanged(path, cfg, loaded); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+}
+
+func TestLockIsExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	lock, err := Lock(context.Background(), path)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := Lock(ctx, path); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected second lock to time out, got %v", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	again, err := Lock(context.Background(), path)
+	if err != nil {
+		t.Fatalf("lock after release: %v", err)
+	}
+	again.Release()
+}
+
+func TestWriteBumpsModnoOnlyWhenPlansChange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	seed, err := os.ReadFile("../../testdata/example-backrest.config.json")
+	if err != nil {
+		t.Fatalf("read testdata: %v", err)
+	}
+	if err := os.WriteFile(path, seed, 0o644); err != nil {
+		t.Fatalf("write seed config: %v", err)
+	}
+
+	// idempotent pass: re-upserting the same plans leaves modno alone
+	cfg, _, err := Load(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	start := cfg.Modno()
+	same := append([]model.Plan(nil), cfg.Plans...)
+	cfg.UpsertPlans(same)
+	cfg.Normalize()
+	if _, err := Write(path, cfg); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if got := modnoOnDisk(t, path); got != start {
+		t.Fatalf("idempotent write bumped modno %d -> %d", start, got)
+	}
+
+	// changed plans bump once, even when the same config is written twice
+	cfg, _, err = Load(path)
+	if err != nil {
+		t.Fatalf("reload config: %v", err)
+	}
+	cfg.UpsertPlans([]model.Plan{{ID: "plan-new", Repo: cfg.Repos[0].ID, Paths: []string{"/data"}}})
+	cfg.Normalize()
+	if _, err := Write(path, cfg); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if _, err := Write(path, cfg); err != nil {
+		t.Fatalf("rewrite config: %v", err)
+	}
+	if got := modnoOnDisk(t, path); got != start+1 {
+		t.Fatalf("expected modno %d after plan change, got %d", start+1, got)
+	}
+}
+
+func TestWriteLeavesMissingModnoAloneWithoutChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"repos": [], "plans": []}`), 0o644); err != nil {
+		t.Fatalf("write seed config: %v", err)
+	}
+	cfg, _, err := Load(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if _, err := Write(path, cfg); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	if strings.Contains(string(data), "modno") {
+		t.Fatalf("unchanged write introduced modno: %s", data)
+	}
+}
+
+func modnoOnDisk(t *testing.T, path string) int64 {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	var top struct {
+		Modno int64 `json:"modno"`
+	}
+	if err := json.Unmarshal(data, &top); err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+	return top.Modno
+}
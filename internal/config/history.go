@@ -0,0 +1,183 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	fsutil "github.com/zettaio/backrest-sidecar/internal/util/fs"
+)
+
+const (
+	snapshotIDLayout = "20060102T150405.000000000Z"
+	snapshotExt      = ".json"
+	snapshotMetaExt  = ".meta.json"
+)
+
+// Snapshot describes one recorded config version.
+type Snapshot struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	Changed []string  `json:"changed,omitempty"`
+	Note    string    `json:"note,omitempty"`
+}
+
+// History keeps the last N versions of a config file in a directory next to it.
+type History struct {
+	dir   string
+	limit int
+}
+
+// HistoryDir returns the snapshot directory for a config path.
+func HistoryDir(path string) string {
+	return path + ".history"
+}
+
+// NewHistory returns the history for a config path; limit <= 0 disables recording.
+func NewHistory(path string, limit int) *History {
+	return &History{dir: HistoryDir(path), limit: limit}
+}
+
+// Enabled reports whether snapshots are recorded.
+func (h *History) Enabled() bool {
+	return h != nil && h.limit > 0
+}
+
+// Record stores data as a new snapshot and prunes the oldest beyond the limit.
+func (h *History) Record(data []byte, changed []string, note string, now time.Time) (Snapshot, error) {
+	if !h.Enabled() {
+		return Snapshot{}, nil
+	}
+	now = now.UTC()
+	// keep IDs unique even when the clock is coarse
+	for {
+		if _, err := os.Stat(filepath.Join(h.dir, now.Format(snapshotIDLayout)+snapshotMetaExt)); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		now = now.Add(time.Nanosecond)
+	}
+	snap := Snapshot{
+		ID:      now.Format(snapshotIDLayout),
+		Time:    now,
+		Changed: append([]string(nil), changed...),
+		Note:    note,
+	}
+	meta, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("marshal snapshot meta: %w", err)
+	}
+	if err := fsutil.AtomicWrite(filepath.Join(h.dir, snap.ID+snapshotExt), data, 0o600); err != nil {
+		return Snapshot{}, fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := fsutil.AtomicWrite(filepath.Join(h.dir, snap.ID+snapshotMetaExt), append(meta, '\n'), 0o600); err != nil {
+		return Snapshot{}, fmt.Errorf("write snapshot meta: %w", err)
+	}
+	return snap, h.prune()
+}
+
+// List returns the recorded snapshots, newest first.
+func (h *History) List() ([]Snapshot, error) {
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	snaps := make([]Snapshot, 0, len(entries)/2)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, snapshotMetaExt) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(h.dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("read snapshot meta: %w", err)
+		}
+		var snap Snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("parse snapshot meta %s: %w", name, err)
+		}
+		snaps = append(snaps, snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool {
+		return snaps[i].ID > snaps[j].ID
+	})
+	return snaps, nil
+}
+
+// Find resolves a snapshot by ID or unique ID prefix.
+func (h *History) Find(id string) (Snapshot, error) {
+	snaps, err := h.List()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	var matches []Snapshot
+	for _, snap := range snaps {
+		if snap.ID == id {
+			return snap, nil
+		}
+		if id != "" && strings.HasPrefix(snap.ID, id) {
+			matches = append(matches, snap)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return Snapshot{}, fmt.Errorf("snapshot %q not found", id)
+	case 1:
+		return matches[0], nil
+	default:
+		return Snapshot{}, fmt.Errorf("snapshot %q is ambiguous (%d matches)", id, len(matches))
+	}
+}
+
+// Read returns the config bytes stored in a snapshot.
+func (h *History) Read(snap Snapshot) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(h.dir, snap.ID+snapshotExt))
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot: %w", err)
+	}
+	return data, nil
+}
+
+// Rollback atomically restores the config at path to the given snapshot and
+// returns the restored bytes. The snapshot must parse as a config.
+func Rollback(path string, h *History, id string) (Snapshot, []byte, error) {
+	snap, err := h.Find(id)
+	if err != nil {
+		return Snapshot{}, nil, err
+	}
+	data, err := h.Read(snap)
+	if err != nil {
+		return Snapshot{}, nil, err
+	}
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return Snapshot{}, nil, fmt.Errorf("snapshot %s is not valid JSON: %w", snap.ID, err)
+	}
+	if err := fsutil.AtomicWrite(path, data, 0o644); err != nil {
+		return Snapshot{}, nil, err
+	}
+	return snap, data, nil
+}
+
+func (h *History) prune() error {
+	snaps, err := h.List()
+	if err != nil {
+		return err
+	}
+	for i := h.limit; i < len(snaps); i++ {
+		for _, ext := range []string{snapshotExt, snapshotMetaExt} {
+			if err := os.Remove(filepath.Join(h.dir, snaps[i].ID+ext)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("prune snapshot: %w", err)
+			}
+		}
+	}
+	return nil
+}
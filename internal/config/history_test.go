@@ -0,0 +1,104 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistoryRecordPrunesToLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	h := NewHistory(path, 2)
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, body := range []string{`{"v":1}`, `{"v":2}`, `{"v":3}`} {
+		if _, err := h.Record([]byte(body), []string{"plan-a"}, "reconcile", base.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+	}
+	snaps, err := h.List()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(snaps) != 2 {
+		t.Fatalf("expected 2 snapshots after prune, got %d", len(snaps))
+	}
+	if !snaps[0].Time.Equal(base.Add(2 * time.Minute)) {
+		t.Fatalf("expected newest first, got %v", snaps[0].Time)
+	}
+	data, err := h.Read(snaps[1])
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(data) != `{"v":2}` {
+		t.Fatalf("unexpected oldest kept snapshot %s", data)
+	}
+	if len(snaps[0].Changed) != 1 || snaps[0].Changed[0] != "plan-a" {
+		t.Fatalf("changed plans not recorded: %v", snaps[0].Changed)
+	}
+}
+
+func TestHistoryRecordKeepsSameInstantDistinct(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	h := NewHistory(path, 5)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	first, err := h.Record([]byte(`{}`), nil, "", now)
+	if err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	second, err := h.Record([]byte(`{}`), nil, "", now)
+	if err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if first.ID == second.ID {
+		t.Fatalf("expected distinct IDs, both %s", first.ID)
+	}
+}
+
+func TestHistoryDisabledRecordsNothing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	h := NewHistory(path, 0)
+	if _, err := h.Record([]byte(`{}`), nil, "", time.Now()); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if _, err := os.Stat(HistoryDir(path)); !os.IsNotExist(err) {
+		t.Fatalf("expected no history dir, stat err=%v", err)
+	}
+}
+
+func TestRollbackRestoresSnapshotByPrefix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"modno":3}`), 0o644); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	h := NewHistory(path, 5)
+	snap, err := h.Record([]byte(`{"modno":1}`), nil, "baseline", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if _, err := h.Record([]byte(`{"modno":2}`), nil, "", time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+
+	restored, data, err := Rollback(path, h, snap.ID[:8])
+	if err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if restored.ID != snap.ID || string(data) != `{"modno":1}` {
+		t.Fatalf("restored wrong snapshot %s: %s", restored.ID, data)
+	}
+	onDisk, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	if string(onDisk) != `{"modno":1}` {
+		t.Fatalf("config not restored: %s", onDisk)
+	}
+
+	if _, _, err := Rollback(path, h, "2024"); err == nil {
+		t.Fatalf("expected ambiguous prefix to fail")
+	}
+	if _, _, err := Rollback(path, h, "1999"); err == nil {
+		t.Fatalf("expected unknown snapshot to fail")
+	}
+}
@@ -0,0 +1,76 @@
+//go:build linux
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Watch reports changes to the config file at path until ctx is done. It
+// watches the parent directory with inotify so atomic replacements (a rename
+// over the file, as Backrest and the sidecar both do) are seen as well as
+// in-place writes and deletions. Bursts collapse into a single notification.
+func Watch(ctx context.Context, path string) (<-chan struct{}, error) {
+	dir, name := filepath.Dir(path), filepath.Base(path)
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init: %w", err)
+	}
+	mask := uint32(syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_CREATE | syscall.IN_DELETE)
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("watch %s: %w", dir, err)
+	}
+	// a non-blocking fd goes through the runtime poller, so Close unblocks Read
+	f := os.NewFile(uintptr(fd), "inotify")
+	events := make(chan struct{}, 1)
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	go func() {
+		defer close(events)
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			if touches(buf[:n], name) {
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// touches reports whether a batch of inotify events names the file (or the
+// kernel dropped events, in which case it might have).
+func touches(buf []byte, name string) bool {
+	for off := 0; off+syscall.SizeofInotifyEvent <= len(buf); {
+		mask := binary.NativeEndian.Uint32(buf[off+4:])
+		nameLen := int(binary.NativeEndian.Uint32(buf[off+12:]))
+		start := off + syscall.SizeofInotifyEvent
+		end := start + nameLen
+		if end > len(buf) {
+			return false
+		}
+		if mask&syscall.IN_Q_OVERFLOW != 0 {
+			return true
+		}
+		if string(bytes.TrimRight(buf[start:end], "\x00")) == name {
+			return true
+		}
+		off = end
+	}
+	return false
+}
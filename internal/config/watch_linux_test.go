@@ -0,0 +1,55 @@
+//go:build linux
+
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	fsutil "github.com/zettaio/backrest-sidecar/internal/util/fs"
+)
+
+func TestWatchSeesAtomicReplaceButNotSiblings(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{}`), 0o644); err != nil {
+		t.Fatalf("seed config: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := Watch(ctx, path)
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "config.json.sidecar-state.json"), []byte(`{}`), 0o644); err != nil {
+		t.Fatalf("write sibling: %v", err)
+	}
+	select {
+	case <-events:
+		t.Fatalf("unexpected event for a sibling file")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := fsutil.AtomicWrite(path, []byte(`{"modno":2}`), 0o644); err != nil {
+		t.Fatalf("replace config: %v", err)
+	}
+	select {
+	case <-events:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected an event for the replaced config")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		for ok {
+			_, ok = <-events
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the event channel to close after cancel")
+	}
+}
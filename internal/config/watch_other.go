@@ -0,0 +1,13 @@
+//go:build !linux
+
+package config
+
+import (
+	"context"
+	"errors"
+)
+
+// Watch is only implemented on Linux (inotify).
+func Watch(ctx context.Context, path string) (<-chan struct{}, error) {
+	return nil, errors.New("config watching requires linux")
+}
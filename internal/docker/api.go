@@ -0,0 +1,27 @@
+package docker
+
+import (
+	"context"
+	"time"
+
+	dockerevents "github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// API is the slice of the Docker Engine the sidecar uses. *Client implements
+// it against a real daemon; dockertest.Engine serves the same endpoints for tests.
+type API interface {
+	Ping(ctx context.Context) error
+	ListBackrestEnabled(ctx context.Context) ([]Container, error)
+	ListBackrestEnabledByID(ctx context.Context, ids []string) ([]Container, error)
+	ListByLabel(ctx context.Context, selector string) ([]Container, error)
+	ListAll(ctx context.Context) ([]Container, error)
+	InspectState(ctx context.Context, name string) (ContainerState, error)
+	RestartContainer(ctx context.Context, name string, timeout time.Duration) error
+	StopContainer(ctx context.Context, id string, timeout time.Duration) error
+	StartContainer(ctx context.Context, id string) error
+	Events(ctx context.Context, filter filters.Args, since time.Time) (<-chan dockerevents.Message, <-chan error)
+	Close() error
+}
+
+var _ API = (*Client)(nil)
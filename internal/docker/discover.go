@@ -3,7 +3,9 @@ package docker
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	dockertypes "github.com/docker/docker/api/types"
@@ -22,6 +24,12 @@ type Options struct {
 // Client wraps the Docker API client.
 type Client struct {
 	cli *client.Client
+
+	// The Docker client negotiates its API version lazily on first use, which
+	// races when the daemon's goroutines make their first calls concurrently,
+	// and a failed ping pins it to an ancient version. Negotiate once, up front.
+	versionMu  sync.Mutex
+	negotiated bool
 }
 
 // New creates a new Docker client using the provided options.
@@ -44,6 +52,32 @@ func New(opts Options) (*Client, error) {
 	return &Client{cli: cli}, nil
 }
 
+// ready negotiates the API version before the first request. It fails, and
+// is retried on the next call, while the daemon cannot be reached.
+func (c *Client) ready(ctx context.Context) error {
+	c.versionMu.Lock()
+	defer c.versionMu.Unlock()
+	if c.negotiated {
+		return nil
+	}
+	ping, err := c.cli.Ping(ctx)
+	if err != nil {
+		return err
+	}
+	c.cli.NegotiateAPIVersionPing(ping)
+	c.negotiated = true
+	return nil
+}
+
+// Ping checks that the daemon answers.
+func (c *Client) Ping(ctx context.Context) error {
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
+	_, err := c.cli.Ping(ctx)
+	return err
+}
+
 // Close releases underlying resources.
 func (c *Client) Close() error {
 	if c == nil || c.cli == nil {
@@ -68,37 +102,36 @@ type Container struct {
 func (c *Client) ListBackrestEnabled(ctx context.Context) ([]Container, error) {
 	filterArgs := filters.NewArgs()
 	filterArgs.Add("label", "backrest.enable=true")
+	return c.list(ctx, filterArgs)
+}
 
-	list, err := c.cli.ContainerList(ctx, dockertypes.ContainerListOptions{
-		All:     true,
-		Filters: filterArgs,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	containers := make([]Container, 0, len(list))
-	for _, ctr := range list {
-		name := strings.TrimPrefix(first(ctr.Names), "/")
-		containers = append(containers, Container{
-			ID:      ctr.ID,
-			Name:    name,
-			Labels:  ctr.Labels,
-			Mounts:  ctr.Mounts,
-			Project: ctr.Labels["com.docker.compose.project"],
-			Service: ctr.Labels["com.docker.compose.service"],
-			State:   ctr.State,
-			Status:  ctr.Status,
-		})
+// ListBackrestEnabledByID is ListBackrestEnabled for the given container IDs
+// only; IDs of containers that are gone or not opted in are left out.
+func (c *Client) ListBackrestEnabledByID(ctx context.Context, ids []string) ([]Container, error) {
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("label", "backrest.enable=true")
+	for _, id := range ids {
+		filterArgs.Add("id", id)
 	}
-	return containers, nil
+	return c.list(ctx, filterArgs)
 }
 
 // ListByLabel returns containers matching an arbitrary label selector (key=value).
 func (c *Client) ListByLabel(ctx context.Context, selector string) ([]Container, error) {
 	filterArgs := filters.NewArgs()
 	filterArgs.Add("label", selector)
+	return c.list(ctx, filterArgs)
+}
+
+// ListAll returns every container, running or not.
+func (c *Client) ListAll(ctx context.Context) ([]Container, error) {
+	return c.list(ctx, filters.NewArgs())
+}
 
+func (c *Client) list(ctx context.Context, filterArgs filters.Args) ([]Container, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
 	list, err := c.cli.ContainerList(ctx, dockertypes.ContainerListOptions{
 		All:     true,
 		Filters: filterArgs,
@@ -129,13 +162,60 @@ func (c *Client) RestartContainer(ctx context.Context, name string, timeout time
 	if name == "" {
 		return errors.New("container name required")
 	}
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
 	return c.cli.ContainerRestart(ctx, name, container.StopOptions{
 		Timeout: durationToSecondsPtr(timeout),
 	})
 }
 
+// ContainerState is the runtime status reported by docker inspect.
+type ContainerState struct {
+	Status       string
+	Running      bool
+	Restarting   bool
+	Health       string
+	RestartCount int
+	ExitCode     int
+	Error        string
+	StartedAt    time.Time
+}
+
+// InspectState returns the runtime state of the container name/ID.
+func (c *Client) InspectState(ctx context.Context, name string) (ContainerState, error) {
+	if err := c.ready(ctx); err != nil {
+		return ContainerState{}, err
+	}
+	info, err := c.cli.ContainerInspect(ctx, name)
+	if err != nil {
+		return ContainerState{}, err
+	}
+	var st ContainerState
+	if info.ContainerJSONBase != nil {
+		st.RestartCount = info.RestartCount
+		if s := info.State; s != nil {
+			st.Status = s.Status
+			st.Running = s.Running
+			st.Restarting = s.Restarting
+			st.ExitCode = s.ExitCode
+			st.Error = s.Error
+			if s.Health != nil {
+				st.Health = s.Health.Status
+			}
+			if started, err := time.Parse(time.RFC3339Nano, s.StartedAt); err == nil {
+				st.StartedAt = started
+			}
+		}
+	}
+	return st, nil
+}
+
 // StopContainer stops the container with timeout.
 func (c *Client) StopContainer(ctx context.Context, id string, timeout time.Duration) error {
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
 	return c.cli.ContainerStop(ctx, id, container.StopOptions{
 		Timeout: durationToSecondsPtr(timeout),
 	})
@@ -143,14 +223,30 @@ func (c *Client) StopContainer(ctx context.Context, id string, timeout time.Dura
 
 // StartContainer starts a container.
 func (c *Client) StartContainer(ctx context.Context, id string) error {
+	if err := c.ready(ctx); err != nil {
+		return err
+	}
 	return c.cli.ContainerStart(ctx, id, dockertypes.ContainerStartOptions{})
 }
 
-// Events subscribes to Docker events with the provided filters.
-func (c *Client) Events(ctx context.Context, filter filters.Args) (<-chan dockerevents.Message, <-chan error) {
-	return c.cli.Events(ctx, dockertypes.EventsOptions{
-		Filters: filter,
-	})
+// Events subscribes to Docker events with the provided filters. A non-zero
+// since replays events from that time on, so a resubscription misses nothing.
+func (c *Client) Events(ctx context.Context, filter filters.Args, since time.Time) (<-chan dockerevents.Message, <-chan error) {
+	if err := c.ready(ctx); err != nil {
+		errs := make(chan error, 1)
+		errs <- err
+		return make(chan dockerevents.Message), errs
+	}
+	opts := dockertypes.EventsOptions{Filters: filter}
+	if !since.IsZero() {
+		opts.Since = FormatEventTime(since)
+	}
+	return c.cli.Events(ctx, opts)
+}
+
+// FormatEventTime renders t the way the events API expects since/until.
+func FormatEventTime(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
 }
 
 func first(items []string) string {
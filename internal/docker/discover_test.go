@@ -0,0 +1,90 @@
+package docker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/filters"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker/dockertest"
+)
+
+func TestListBackrestEnabledFiltersByLabel(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	engine.Add(dockertest.Container{Name: "app", Labels: map[string]string{"backrest.enable": "true", "com.docker.compose.project": "demo", "com.docker.compose.service": "app"}})
+	stopped := engine.Add(dockertest.Container{Name: "stopped", State: "exited", Labels: map[string]string{"backrest.enable": "true"}})
+	other := engine.Add(dockertest.Container{Name: "other", Labels: map[string]string{"backrest.enable": "false"}})
+
+	client := engine.Client(t)
+	got, err := client.ListBackrestEnabled(context.Background())
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(got) != 2 || got[0].Name != "app" || got[1].Name != "stopped" {
+		t.Fatalf("unexpected containers %+v", got)
+	}
+	if got[0].Project != "demo" || got[0].Service != "app" || got[1].State != "exited" {
+		t.Fatalf("metadata not mapped: %+v", got)
+	}
+
+	got, err = client.ListBackrestEnabledByID(context.Background(), []string{stopped, other, "gone"})
+	if err != nil {
+		t.Fatalf("list by id: %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "stopped" {
+		t.Fatalf("expected only the enabled container asked for, got %+v", got)
+	}
+}
+
+func TestInspectStateAndRestart(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	engine.Add(dockertest.Container{Name: "backrest", Health: "healthy", RestartCount: 2})
+	client := engine.Client(t)
+	ctx := context.Background()
+
+	if err := client.RestartContainer(ctx, "backrest", time.Second); err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	st, err := client.InspectState(ctx, "backrest")
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if !st.Running || st.Health != "healthy" || st.RestartCount != 2 || st.StartedAt.IsZero() {
+		t.Fatalf("unexpected state %+v", st)
+	}
+	if calls := engine.Calls(); len(calls) != 1 || calls[0] != "restart backrest" {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+	if _, err := client.InspectState(ctx, "missing"); err == nil {
+		t.Fatalf("expected an error for a missing container")
+	}
+}
+
+func TestEventsStreamContainerActions(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	client := engine.Client(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	args := filters.NewArgs()
+	args.Add("type", "container")
+	msgs, errs := client.Events(ctx, args, time.Time{})
+	// the subscription is registered asynchronously; keep adding until one arrives
+	deadline := time.After(5 * time.Second)
+	for {
+		engine.Emit("start", "app")
+		select {
+		case msg := <-msgs:
+			if msg.Action != "start" || msg.Actor.Attributes["name"] != "app" {
+				t.Fatalf("unexpected event %+v", msg)
+			}
+			return
+		case err := <-errs:
+			t.Fatalf("events: %v", err)
+		case <-deadline:
+			t.Fatalf("no event received")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,477 @@
+// Package dockertest serves a fake Docker Engine API over httptest so the
+// sidecar's real Docker client can be exercised without a daemon.
+package dockertest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	dockerevents "github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+
+	"github.com/zettaio/backrest-sidecar/internal/docker"
+)
+
+// APIVersion is the Engine API version the fake reports.
+const APIVersion = "1.43"
+
+// Container is a fake container. State defaults to "running".
+type Container struct {
+	ID           string
+	Name         string
+	Labels       map[string]string
+	Mounts       []dockertypes.MountPoint
+	State        string
+	Health       string
+	RestartCount int
+	ExitCode     int
+	StartedAt    time.Time
+}
+
+// Engine is an in-process fake of the Docker Engine API covering what the
+// sidecar calls: container list/inspect, stop/start/restart and the event
+// stream. Lifecycle calls change the fake's state and emit matching events.
+type Engine struct {
+	srv *httptest.Server
+
+	mu         sync.Mutex
+	containers []*Container
+	calls      []string
+	subs       map[chan dockerevents.Message]chan struct{}
+	history    []dockerevents.Message
+	onRestart  func(*Container)
+	closed     chan struct{}
+	closedOnce sync.Once
+}
+
+var versionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+// NewEngine starts a fake engine that is shut down when the test ends.
+func NewEngine(t testing.TB) *Engine {
+	t.Helper()
+	e := &Engine{
+		subs:   make(map[chan dockerevents.Message]chan struct{}),
+		closed: make(chan struct{}),
+	}
+	e.srv = httptest.NewServer(http.HandlerFunc(e.serve))
+	t.Cleanup(e.Close)
+	return e
+}
+
+// Host is the Docker host URL (tcp://...) of the fake engine.
+func (e *Engine) Host() string {
+	return "tcp://" + e.srv.Listener.Addr().String()
+}
+
+// Client returns a real Docker client pointed at the fake engine.
+func (e *Engine) Client(t testing.TB) *docker.Client {
+	t.Helper()
+	client, err := docker.New(docker.Options{Host: e.Host()})
+	if err != nil {
+		t.Fatalf("docker client: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+// Close ends open event streams and stops the server.
+func (e *Engine) Close() {
+	e.closedOnce.Do(func() {
+		close(e.closed)
+		e.srv.Close()
+	})
+}
+
+// Add registers a container (emitting "create", then "start" when it is
+// running) and returns its ID.
+func (e *Engine) Add(c Container) string {
+	if c.ID == "" {
+		sum := sha256.Sum256([]byte(c.Name))
+		c.ID = hex.EncodeToString(sum[:])
+	}
+	if c.State == "" {
+		c.State = "running"
+	}
+	if c.State == "running" && c.StartedAt.IsZero() {
+		c.StartedAt = time.Now()
+	}
+	e.mu.Lock()
+	e.containers = append(e.containers, &c)
+	e.mu.Unlock()
+	e.Emit("create", c.Name)
+	if c.State == "running" {
+		e.Emit("start", c.Name)
+	}
+	return c.ID
+}
+
+// Remove deletes a container and emits "destroy".
+func (e *Engine) Remove(name string) {
+	e.mu.Lock()
+	var gone *Container
+	for i, c := range e.containers {
+		if c.Name == name || c.ID == name {
+			gone = c
+			e.containers = append(e.containers[:i], e.containers[i+1:]...)
+			break
+		}
+	}
+	e.mu.Unlock()
+	if gone != nil {
+		e.emit("destroy", gone)
+	}
+}
+
+// Update mutates a container in place.
+func (e *Engine) Update(name string, fn func(*Container)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if c := e.find(name); c != nil {
+		fn(c)
+	}
+}
+
+// Get returns a copy of a container.
+func (e *Engine) Get(name string) (Container, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if c := e.find(name); c != nil {
+		return *c, true
+	}
+	return Container{}, false
+}
+
+// OnRestart runs fn on the container after every restart call, e.g. to
+// simulate a crash loop.
+func (e *Engine) OnRestart(fn func(*Container)) {
+	e.mu.Lock()
+	e.onRestart = fn
+	e.mu.Unlock()
+}
+
+// Calls lists lifecycle calls in order as "<action> <name>".
+func (e *Engine) Calls() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.calls...)
+}
+
+// DropEventStreams ends every open event stream, as a dockerd restart would.
+// Clients may subscribe again right away.
+func (e *Engine) DropEventStreams() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch, drop := range e.subs {
+		close(drop)
+		delete(e.subs, ch)
+	}
+}
+
+// Subscribers reports how many event streams are open.
+func (e *Engine) Subscribers() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.subs)
+}
+
+// Emit sends a container event for name to all event subscribers.
+func (e *Engine) Emit(action, name string) {
+	e.mu.Lock()
+	c := e.find(name)
+	var snapshot Container
+	if c != nil {
+		snapshot = *c
+	} else {
+		snapshot = Container{Name: name, ID: name}
+	}
+	e.mu.Unlock()
+	e.emit(action, &snapshot)
+}
+
+func (e *Engine) emit(action string, c *Container) {
+	attrs := map[string]string{"name": c.Name}
+	for k, v := range c.Labels {
+		attrs[k] = v
+	}
+	msg := dockerevents.Message{
+		Type:     dockerevents.ContainerEventType,
+		Action:   dockerevents.Action(action),
+		Actor:    dockerevents.Actor{ID: c.ID, Attributes: attrs},
+		Time:     time.Now().Unix(),
+		TimeNano: time.Now().UnixNano(),
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.history = append(e.history, msg)
+	for ch := range e.subs {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+func (e *Engine) find(name string) *Container {
+	name = strings.TrimPrefix(name, "/")
+	for _, c := range e.containers {
+		if c.Name == name || c.ID == name || (len(name) >= 12 && strings.HasPrefix(c.ID, name)) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (e *Engine) serve(w http.ResponseWriter, r *http.Request) {
+	path := versionPrefix.ReplaceAllString(r.URL.Path, "")
+	w.Header().Set("API-Version", APIVersion)
+	switch {
+	case path == "/_ping":
+		_, _ = w.Write([]byte("OK"))
+	case path == "/containers/json" && r.Method == http.MethodGet:
+		e.serveList(w, r)
+	case path == "/events" && r.Method == http.MethodGet:
+		e.serveEvents(w, r)
+	case strings.HasPrefix(path, "/containers/"):
+		parts := strings.Split(strings.TrimPrefix(path, "/containers/"), "/")
+		if len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		if parts[1] == "json" && r.Method == http.MethodGet {
+			e.serveInspect(w, parts[0])
+			return
+		}
+		if r.Method == http.MethodPost {
+			e.serveLifecycle(w, parts[0], parts[1])
+			return
+		}
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (e *Engine) serveList(w http.ResponseWriter, r *http.Request) {
+	args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	all := r.URL.Query().Get("all") == "1" || r.URL.Query().Get("all") == "true"
+	e.mu.Lock()
+	out := make([]dockertypes.Container, 0, len(e.containers))
+	for _, c := range e.containers {
+		if !all && c.State != "running" {
+			continue
+		}
+		if !args.MatchKVList("label", c.Labels) || !matchID(args, c.ID) {
+			continue
+		}
+		out = append(out, dockertypes.Container{
+			ID:     c.ID,
+			Names:  []string{"/" + c.Name},
+			Labels: c.Labels,
+			Mounts: c.Mounts,
+			State:  c.State,
+			Status: c.State,
+		})
+	}
+	e.mu.Unlock()
+	writeJSON(w, out)
+}
+
+func (e *Engine) serveInspect(w http.ResponseWriter, name string) {
+	e.mu.Lock()
+	c := e.find(name)
+	if c == nil {
+		e.mu.Unlock()
+		writeError(w, http.StatusNotFound, "No such container: "+name)
+		return
+	}
+	state := &dockertypes.ContainerState{
+		Status:     c.State,
+		Running:    c.State == "running",
+		Restarting: c.State == "restarting",
+		ExitCode:   c.ExitCode,
+	}
+	if !c.StartedAt.IsZero() {
+		state.StartedAt = c.StartedAt.UTC().Format(time.RFC3339Nano)
+	}
+	if c.Health != "" {
+		state.Health = &dockertypes.Health{Status: c.Health}
+	}
+	info := dockertypes.ContainerJSON{
+		ContainerJSONBase: &dockertypes.ContainerJSONBase{
+			ID:           c.ID,
+			Name:         "/" + c.Name,
+			RestartCount: c.RestartCount,
+			State:        state,
+		},
+		Mounts: c.Mounts,
+		Config: &container.Config{Labels: c.Labels},
+	}
+	e.mu.Unlock()
+	writeJSON(w, info)
+}
+
+func (e *Engine) serveLifecycle(w http.ResponseWriter, name, action string) {
+	e.mu.Lock()
+	c := e.find(name)
+	if c == nil {
+		e.mu.Unlock()
+		writeError(w, http.StatusNotFound, "No such container: "+name)
+		return
+	}
+	switch action {
+	case "stop":
+		c.State = "exited"
+	case "start":
+		c.State = "running"
+		c.StartedAt = time.Now()
+	case "restart":
+		c.State = "running"
+		c.StartedAt = time.Now()
+		if e.onRestart != nil {
+			e.onRestart(c)
+		}
+	default:
+		e.mu.Unlock()
+		writeError(w, http.StatusNotFound, "unsupported action "+action)
+		return
+	}
+	e.calls = append(e.calls, action+" "+c.Name)
+	snapshot := *c
+	e.mu.Unlock()
+	e.emit(action, &snapshot)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Engine) serveEvents(w http.ResponseWriter, r *http.Request) {
+	args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var since time.Time
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		if since, err = parseEventTime(raw); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	ch := make(chan dockerevents.Message, 64)
+	drop := make(chan struct{})
+	e.mu.Lock()
+	var replay []dockerevents.Message
+	if !since.IsZero() {
+		for _, msg := range e.history {
+			if msg.TimeNano >= since.UnixNano() {
+				replay = append(replay, msg)
+			}
+		}
+	}
+	e.subs[ch] = drop
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.subs, ch)
+		e.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	send := func(msg dockerevents.Message) bool {
+		if !matchEvent(args, msg) {
+			return true
+		}
+		if err := enc.Encode(msg); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for _, msg := range replay {
+		if !send(msg) {
+			return
+		}
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-e.closed:
+			return
+		case <-drop:
+			return
+		case msg := <-ch:
+			if !send(msg) {
+				return
+			}
+		}
+	}
+}
+
+// matchID applies id filters, which match ID prefixes; several are ORed.
+func matchID(args filters.Args, id string) bool {
+	if !args.Contains("id") {
+		return true
+	}
+	for _, want := range args.Get("id") {
+		if strings.HasPrefix(id, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchEvent(args filters.Args, msg dockerevents.Message) bool {
+	if args.Contains("type") && !args.ExactMatch("type", string(msg.Type)) {
+		return false
+	}
+	if args.Contains("event") && !args.ExactMatch("event", string(msg.Action)) {
+		return false
+	}
+	if args.Contains("label") && !args.MatchKVList("label", msg.Actor.Attributes) {
+		return false
+	}
+	return true
+}
+
+func parseEventTime(raw string) (time.Time, error) {
+	var sec, nsec int64
+	if _, err := fmt.Sscanf(raw, "%d.%d", &sec, &nsec); err != nil {
+		if _, err := fmt.Sscanf(raw, "%d", &sec); err != nil {
+			return time.Time{}, fmt.Errorf("invalid since %q", raw)
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "{\"message\":%q}\n", msg)
+}
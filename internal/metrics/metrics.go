@@ -0,0 +1,237 @@
+// Package metrics is a minimal Prometheus exporter: counters, gauges and
+// histograms rendered in the text exposition format, without the client
+// library's dependency tree.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	fsutil "github.com/zettaio/backrest-sidecar/internal/util/fs"
+)
+
+// Registry holds metric families in registration order.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	counts []uint64 // per bucket, histograms only
+	count  uint64
+}
+
+func (r *Registry) register(name, help, kind string, labels []string, buckets []float64) *family {
+	f := &family{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.families {
+		if existing.name == name {
+			panic("metrics: duplicate metric " + name)
+		}
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+// get returns the series for the label values; r.mu must be held.
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.buckets != nil {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a monotonically increasing value per label set.
+type Counter struct {
+	r *Registry
+	f *family
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r: r, f: r.register(name, help, "counter", labels, nil)}
+}
+
+// Inc adds one to the series for values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v, which must not be negative, to the series for values.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic("metrics: counter " + c.f.name + " cannot decrease")
+	}
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	c.f.get(values).value += v
+}
+
+// Gauge is a value per label set that can go up and down.
+type Gauge struct {
+	r *Registry
+	f *family
+}
+
+// NewGauge registers a gauge with the given label names.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r: r, f: r.register(name, help, "gauge", labels, nil)}
+}
+
+// Set stores v for values.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.r.mu.Lock()
+	defer g.r.mu.Unlock()
+	g.f.get(values).value = v
+}
+
+// Histogram counts observations into fixed buckets.
+type Histogram struct {
+	r *Registry
+	f *family
+}
+
+// NewHistogram registers a histogram with the given upper bounds.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r: r, f: r.register(name, help, "histogram", labels, buckets)}
+}
+
+// Observe records v for values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.r.mu.Lock()
+	defer h.r.mu.Unlock()
+	s := h.f.get(values)
+	for i, upper := range h.f.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+// WriteText renders every family that has at least one series.
+func (r *Registry) WriteText(w io.Writer) error {
+	var buf bytes.Buffer
+	r.mu.Lock()
+	for _, f := range r.families {
+		f.write(&buf)
+	}
+	r.mu.Unlock()
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (f *family) write(buf *bytes.Buffer) {
+	if len(f.series) == 0 {
+		return
+	}
+	fmt.Fprintf(buf, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != "histogram" {
+			fmt.Fprintf(buf, "%s%s %s\n", f.name, labelPairs(f.labels, s.values, "", 0), formatFloat(s.value))
+			continue
+		}
+		for i, upper := range f.buckets {
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, labelPairs(f.labels, s.values, "le", upper), s.counts[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, labelPairs(f.labels, s.values, "le", math.Inf(1)), s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", f.name, labelPairs(f.labels, s.values, "", 0), formatFloat(s.value))
+		fmt.Fprintf(buf, "%s_count%s %d\n", f.name, labelPairs(f.labels, s.values, "", 0), s.count)
+	}
+}
+
+// labelPairs renders {a="x",b="y"}, appending le when extra is set.
+func labelPairs(names, values []string, extra string, le float64) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeValue(values[i])+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra+`="`+formatFloat(le)+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeValue(s string) string { return valueEscaper.Replace(s) }
+
+// Handler serves the registry at any path.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.WriteText(w)
+	})
+}
+
+// WriteFile atomically writes the registry to path, e.g. for node_exporter's
+// textfile collector.
+func (r *Registry) WriteFile(path string) error {
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		return err
+	}
+	return fsutil.AtomicWrite(path, buf.Bytes(), 0o644)
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteTextRendersExpositionFormat(t *testing.T) {
+	r := NewRegistry()
+	passes := r.NewCounter("passes_total", "Reconcile passes.", "outcome")
+	connected := r.NewGauge("connected", "Whether the stream is up.")
+	duration := r.NewHistogram("duration_seconds", "Pass duration.", []float64{1, 0.1})
+	r.NewCounter("unused_total", "Never touched.")
+
+	passes.Inc("changed")
+	passes.Add(2, `un"changed`)
+	connected.Set(1)
+	duration.Observe(0.05)
+	duration.Observe(0.5)
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	want := strings.Join([]string{
+		`# HELP passes_total Reconcile passes.`,
+		`# TYPE passes_total counter`,
+		`passes_total{outcome="changed"} 1`,
+		`passes_total{outcome="un\"changed"} 2`,
+		`# HELP connected Whether the stream is up.`,
+		`# TYPE connected gauge`,
+		`connected 1`,
+		`# HELP duration_seconds Pass duration.`,
+		`# TYPE duration_seconds histogram`,
+		`duration_seconds_bucket{le="0.1"} 1`,
+		`duration_seconds_bucket{le="1"} 2`,
+		`duration_seconds_bucket{le="+Inf"} 2`,
+		`duration_seconds_sum 0.55`,
+		`duration_seconds_count 2`,
+	}, "\n") + "\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,187 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// DiffPlans lists the JSON fields that differ between two plans as dotted
+// paths (for example "schedule.cron" or "paths"), sorted. Objects are compared
+// key by key, arrays as a whole; unknown fields carried as extras count too.
+func DiffPlans(a, b Plan) []string {
+	left, err := a.normalizedJSON()
+	if err != nil {
+		return nil
+	}
+	right, err := b.normalizedJSON()
+	if err != nil {
+		return nil
+	}
+	var fields []string
+	diffJSON("", left, right, &fields)
+	sort.Strings(fields)
+	return fields
+}
+
+func diffJSON(prefix string, left, right json.RawMessage, out *[]string) {
+	var lobj, robj map[string]json.RawMessage
+	if json.Unmarshal(left, &lobj) != nil || json.Unmarshal(right, &robj) != nil || lobj == nil || robj == nil {
+		if !jsonEqual(left, right) {
+			*out = append(*out, prefix)
+		}
+		return
+	}
+	keys := make(map[string]struct{}, len(lobj)+len(robj))
+	for k := range lobj {
+		keys[k] = struct{}{}
+	}
+	for k := range robj {
+		keys[k] = struct{}{}
+	}
+	for k := range keys {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		lv, lok := lobj[k]
+		rv, rok := robj[k]
+		if lok != rok {
+			*out = append(*out, path)
+			continue
+		}
+		diffJSON(path, lv, rv, out)
+	}
+}
+
+func jsonEqual(a, b json.RawMessage) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
+
+// MergeMode decides how a field edited outside the sidecar fares against the
+// label render in MergePlans.
+type MergeMode int
+
+const (
+	// MergeLabels overwrites every outside edit with the label render.
+	MergeLabels MergeMode = iota
+	// MergeFields keeps outside edits unless the labels changed the same field.
+	MergeFields
+	// MergeTheirs keeps outside edits even when the labels changed the field too.
+	MergeTheirs
+)
+
+// MergePlans three-way merges a plan: base is the previous label render, ours
+// the current label render and theirs the plan found in the config. Fields
+// theirs did not change since base follow ours; fields it did change are kept
+// or reverted per mode and reported as dotted paths.
+func MergePlans(base, ours, theirs Plan, mode MergeMode) (merged Plan, kept, reverted []string, err error) {
+	b, err := base.normalizedJSON()
+	if err != nil {
+		return Plan{}, nil, nil, err
+	}
+	o, err := ours.normalizedJSON()
+	if err != nil {
+		return Plan{}, nil, nil, err
+	}
+	t, err := theirs.normalizedJSON()
+	if err != nil {
+		return Plan{}, nil, nil, err
+	}
+	m := &planMerge{mode: mode}
+	out, keep := m.merge("", b, o, t, true, true, true)
+	if !keep {
+		return ours, nil, nil, nil
+	}
+	if err := json.Unmarshal(out, &merged); err != nil {
+		return Plan{}, nil, nil, err
+	}
+	merged.ID = ours.ID
+	sort.Strings(m.kept)
+	sort.Strings(m.reverted)
+	return merged, m.kept, m.reverted, nil
+}
+
+type planMerge struct {
+	mode     MergeMode
+	kept     []string
+	reverted []string
+}
+
+// merge returns the merged value for one field and whether it is present.
+func (m *planMerge) merge(path string, b, o, t json.RawMessage, hasB, hasO, hasT bool) (json.RawMessage, bool) {
+	same := func(x, y json.RawMessage, hx, hy bool) bool {
+		return hx == hy && (!hx || jsonEqual(x, y))
+	}
+	if same(t, b, hasT, hasB) || same(o, t, hasO, hasT) {
+		return o, hasO
+	}
+	var bo, oo, to map[string]json.RawMessage
+	if hasB && hasO && hasT && !m.switchedOneof(path, b, t) && json.Unmarshal(b, &bo) == nil && json.Unmarshal(o, &oo) == nil && json.Unmarshal(t, &to) == nil && bo != nil && oo != nil && to != nil {
+		keys := make(map[string]struct{}, len(oo)+len(to))
+		for k := range bo {
+			keys[k] = struct{}{}
+		}
+		for k := range oo {
+			keys[k] = struct{}{}
+		}
+		for k := range to {
+			keys[k] = struct{}{}
+		}
+		out := make(map[string]json.RawMessage, len(keys))
+		for k := range keys {
+			child := k
+			if path != "" {
+				child = path + "." + k
+			}
+			bv, hb := bo[k]
+			ov, ho := oo[k]
+			tv, ht := to[k]
+			if v, ok := m.merge(child, bv, ov, tv, hb, ho, ht); ok {
+				out[k] = v
+			}
+		}
+		data, err := json.Marshal(out)
+		if err != nil {
+			return o, hasO
+		}
+		return data, true
+	}
+	// theirs edited this field; labels either kept it (an override) or changed it too (a conflict)
+	labelsChanged := !same(o, b, hasO, hasB)
+	if m.mode == MergeTheirs || (m.mode == MergeFields && !labelsChanged) {
+		m.kept = append(m.kept, path)
+		return t, hasT
+	}
+	m.reverted = append(m.reverted, path)
+	return o, hasO
+}
+
+// planOneofs are the plan objects whose members are alternatives in Backrest.
+var planOneofs = map[string][]string{"schedule": scheduleOneof, "retention": retentionOneof}
+
+// switchedOneof reports whether theirs picked a different schedule kind or
+// retention policy than base. Such an edit is merged as a whole, so the
+// result never carries two members of the oneof.
+func (m *planMerge) switchedOneof(path string, b, t json.RawMessage) bool {
+	oneof, ok := planOneofs[path]
+	if !ok {
+		return false
+	}
+	var bo, to map[string]json.RawMessage
+	if json.Unmarshal(b, &bo) != nil || json.Unmarshal(t, &to) != nil {
+		return false
+	}
+	for _, key := range oneof {
+		_, inB := bo[key]
+		_, inT := to[key]
+		if inB != inT {
+			return true
+		}
+	}
+	return false
+}
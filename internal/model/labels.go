@@ -1,6 +1,7 @@
 package model
 
 import (
+	"sort"
 	"strings"
 )
 
@@ -43,3 +44,94 @@ func ComposeMetadata(labels map[string]string) (project, service string) {
 	service = strings.TrimSpace(labels[LabelComposeService])
 	return project, service
 }
+
+// LabelPlanPrefix starts the labels of a named plan:
+// backrest.plan.<name>.<setting>, e.g. backrest.plan.dump.schedule.
+const LabelPlanPrefix = LabelPrefix + "plan."
+
+// NamedPlanSettings are the settings a named plan may override.
+var NamedPlanSettings = []string{
+	LabelRepo,
+	LabelSchedule,
+	LabelRetentionKeep,
+	LabelPathsInclude,
+	LabelPathsExclude,
+	LabelHookSnapshotStart,
+	LabelHookSnapshotEnd,
+	LabelHooksTemplate,
+	LabelSpec,
+	LabelGranularity,
+}
+
+// IsNamedPlanSetting reports whether a named plan may override setting.
+func IsNamedPlanSetting(setting string) bool {
+	for _, known := range NamedPlanSettings {
+		if setting == known {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitPlanLabel splits backrest.plan.<name>.<setting> into the plan name
+// and the label it overrides (backrest.<setting>).
+func SplitPlanLabel(key string) (name, setting string, ok bool) {
+	rest, ok := strings.CutPrefix(key, LabelPlanPrefix)
+	if !ok {
+		return "", "", false
+	}
+	name, suffix, ok := strings.Cut(rest, ".")
+	if !ok || name == "" || suffix == "" {
+		return "", "", false
+	}
+	return name, LabelPrefix + suffix, true
+}
+
+// PlanNames returns the sorted names used in backrest.plan.<name>.* labels.
+func PlanNames(labels map[string]string) []string {
+	seen := map[string]struct{}{}
+	for key := range labels {
+		if name, _, ok := SplitPlanLabel(key); ok {
+			seen[name] = struct{}{}
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NamedPlanLabels returns labels as the named plan sees them: each
+// backrest.plan.<name>.<setting> in NamedPlanSettings replaces
+// backrest.<setting>, and every backrest.plan.* label is dropped. A shared
+// flat label is also dropped when the named plan's own spec sets it, so
+// everything the named plan sets beats everything shared.
+func NamedPlanLabels(labels map[string]string, name string) map[string]string {
+	out := make(map[string]string, len(labels))
+	for key, value := range labels {
+		if !strings.HasPrefix(key, LabelPlanPrefix) {
+			out[key] = value
+		}
+	}
+	named := map[string]struct{}{}
+	for key, value := range labels {
+		if planName, setting, ok := SplitPlanLabel(key); ok && planName == name && IsNamedPlanSetting(setting) {
+			out[setting] = value
+			named[setting] = struct{}{}
+		}
+	}
+	if _, ok := named[LabelSpec]; !ok {
+		return out
+	}
+	// a spec error is left for the plan build to report
+	if spec, err := ParseSpec(out); err == nil {
+		for _, setting := range NamedPlanSettings {
+			if _, ok := named[setting]; !ok && spec.Sets(setting) {
+				delete(out, setting)
+			}
+		}
+	}
+	return out
+}
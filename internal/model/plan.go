@@ -1,6 +1,9 @@
 package model
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"slices"
 	"sort"
@@ -17,18 +20,44 @@ type Plan struct {
 	Schedule     PlanSchedule  `json:"schedule"`
 	Retention    PlanRetention `json:"retention"`
 	Hooks        []PlanHook    `json:"hooks,omitempty"`
+
+	// extras holds plan fields the sidecar does not model (e.g. backup_flags).
+	extras map[string]json.RawMessage
+	// raw/loaded remember the JSON a plan was decoded from so untouched plans
+	// are re-emitted verbatim instead of being re-marshaled.
+	raw    json.RawMessage
+	loaded []byte
 }
 
+var planKnownKeys = []string{"id", "repo", "paths", "pathsExclude", "schedule", "retention", "hooks"}
+
 type PlanSchedule struct {
-	Cron  string `json:"cron"`
-	Clock string `json:"clock"`
+	Disabled bool   `json:"disabled,omitempty"`
+	Cron     string `json:"cron,omitempty"`
+	Clock    string `json:"clock"`
+
+	// extras holds schedule fields the sidecar does not model (e.g. maxFrequencyDays).
+	extras map[string]json.RawMessage
 }
 
+var scheduleKnownKeys = []string{"disabled", "cron", "clock"}
+
+// scheduleOneof lists Backrest's mutually exclusive schedule kinds.
+var scheduleOneof = []string{"disabled", "cron", "maxFrequencyDays", "maxFrequencyHours"}
+
 type PlanRetention struct {
 	PolicyTimeBucketed *RetentionBuckets `json:"policyTimeBucketed,omitempty"`
 	spec               string
+
+	// extras holds retention fields the sidecar does not model (e.g. policyKeepLastN).
+	extras map[string]json.RawMessage
 }
 
+var retentionKnownKeys = []string{"policyTimeBucketed"}
+
+// retentionOneof lists Backrest's mutually exclusive retention policies.
+var retentionOneof = []string{"policyTimeBucketed", "policyKeepLastN", "policyKeepAll"}
+
 type RetentionBuckets struct {
 	Hourly  int `json:"hourly,omitempty"`
 	Daily   int `json:"daily,omitempty"`
@@ -40,12 +69,321 @@ type RetentionBuckets struct {
 type PlanHook struct {
 	Conditions    []string    `json:"conditions"`
 	ActionCommand HookCommand `json:"actionCommand"`
+
+	// extras holds hook fields the sidecar does not model (e.g. onError, actionWebhook).
+	extras map[string]json.RawMessage
 }
 
+var hookKnownKeys = []string{"conditions", "actionCommand"}
+
 type HookCommand struct {
 	Command string `json:"command"`
 }
 
+// UnmarshalJSON decodes the modeled fields and keeps everything else as raw extras.
+func (p *Plan) UnmarshalJSON(data []byte) error {
+	type planFields Plan
+	var fields planFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	extras, err := unknownFields(data, planKnownKeys)
+	if err != nil {
+		return err
+	}
+	*p = Plan(fields)
+	p.extras = extras
+	loaded, err := p.normalizedJSON()
+	if err != nil {
+		return err
+	}
+	p.raw = append(json.RawMessage(nil), data...)
+	p.loaded = loaded
+	return nil
+}
+
+// MarshalJSON emits the original JSON for untouched plans, otherwise the modeled
+// fields followed by any preserved extras.
+func (p Plan) MarshalJSON() ([]byte, error) {
+	if len(p.raw) > 0 {
+		current, err := p.normalizedJSON()
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(current, p.loaded) {
+			return append([]byte(nil), p.raw...), nil
+		}
+	}
+	return p.canonicalJSON()
+}
+
+// Extras returns plan fields outside the sidecar's model.
+func (p *Plan) Extras() map[string]json.RawMessage {
+	return p.extras
+}
+
+// SetExtras replaces the raw extras map.
+func (p *Plan) SetExtras(raw map[string]json.RawMessage) {
+	p.extras = raw
+}
+
+// InheritExtras copies unmodeled plan, schedule, retention and hook fields
+// from an existing plan so rendering a plan does not wipe fields added in
+// Backrest. Hooks are matched by conditions and command. A schedule kind or
+// retention policy is only inherited when the render sets none of its own.
+func (p *Plan) InheritExtras(existing Plan) {
+	if len(existing.extras) > 0 {
+		p.extras = cloneRaw(existing.extras)
+	}
+	p.Schedule.extras = inheritOneof(p.Schedule, p.Schedule.extras, existing.Schedule.extras, scheduleOneof)
+	p.Retention.extras = inheritOneof(p.Retention, p.Retention.extras, existing.Retention.extras, retentionOneof)
+	for i := range p.Hooks {
+		for _, old := range existing.Hooks {
+			if len(old.extras) > 0 && old.sameAction(p.Hooks[i]) {
+				p.Hooks[i].extras = cloneRaw(old.extras)
+				break
+			}
+		}
+	}
+}
+
+// Clone returns a deep copy, so a cached plan survives callers that normalize
+// or merge into the copy they were given.
+func (p Plan) Clone() Plan {
+	out := p
+	out.Paths = slices.Clone(p.Paths)
+	out.PathsExclude = slices.Clone(p.PathsExclude)
+	if p.Retention.PolicyTimeBucketed != nil {
+		buckets := *p.Retention.PolicyTimeBucketed
+		out.Retention.PolicyTimeBucketed = &buckets
+	}
+	out.Schedule.extras = cloneRaw(p.Schedule.extras)
+	out.Retention.extras = cloneRaw(p.Retention.extras)
+	if p.Hooks != nil {
+		out.Hooks = make([]PlanHook, len(p.Hooks))
+		for i, hook := range p.Hooks {
+			hook.Conditions = slices.Clone(hook.Conditions)
+			hook.extras = cloneRaw(hook.extras)
+			out.Hooks[i] = hook
+		}
+	}
+	out.extras = cloneRaw(p.extras)
+	out.raw = slices.Clone(p.raw)
+	out.loaded = slices.Clone(p.loaded)
+	return out
+}
+
+// Fingerprint is a stable hash of the plan's normalized content, extras included.
+func (p Plan) Fingerprint() string {
+	data, err := p.normalizedJSON()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizedJSON is the canonical form of a normalized copy, so sorting a plan
+// for output does not count as modifying it.
+func (p Plan) normalizedJSON() ([]byte, error) {
+	clone := p
+	clone.Paths = slices.Clone(p.Paths)
+	clone.PathsExclude = slices.Clone(p.PathsExclude)
+	clone.Hooks = slices.Clone(p.Hooks)
+	clone.Normalize()
+	return clone.canonicalJSON()
+}
+
+func (p Plan) canonicalJSON() ([]byte, error) {
+	type planFields Plan
+	out, err := json.Marshal(planFields(p))
+	if err != nil {
+		return nil, err
+	}
+	return appendExtras(out, p.extras)
+}
+
+// UnmarshalJSON decodes the modeled fields and keeps everything else as raw extras.
+func (h *PlanHook) UnmarshalJSON(data []byte) error {
+	type hookFields PlanHook
+	var fields hookFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	extras, err := unknownFields(data, hookKnownKeys)
+	if err != nil {
+		return err
+	}
+	*h = PlanHook(fields)
+	h.extras = extras
+	return nil
+}
+
+// MarshalJSON emits the modeled fields followed by any preserved extras. The
+// command action is omitted when empty so hooks using other action types
+// (webhooks, notifications) round-trip unchanged.
+func (h PlanHook) MarshalJSON() ([]byte, error) {
+	fields := struct {
+		Conditions    []string     `json:"conditions"`
+		ActionCommand *HookCommand `json:"actionCommand,omitempty"`
+	}{Conditions: h.Conditions}
+	if h.ActionCommand != (HookCommand{}) {
+		cmd := h.ActionCommand
+		fields.ActionCommand = &cmd
+	}
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return appendExtras(out, h.extras)
+}
+
+// UnmarshalJSON decodes the modeled fields and keeps everything else as raw extras.
+func (s *PlanSchedule) UnmarshalJSON(data []byte) error {
+	type scheduleFields PlanSchedule
+	var fields scheduleFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	extras, err := unknownFields(data, scheduleKnownKeys)
+	if err != nil {
+		return err
+	}
+	*s = PlanSchedule(fields)
+	s.extras = extras
+	return nil
+}
+
+// MarshalJSON emits the modeled fields followed by any preserved extras.
+func (s PlanSchedule) MarshalJSON() ([]byte, error) {
+	type scheduleFields PlanSchedule
+	out, err := json.Marshal(scheduleFields(s))
+	if err != nil {
+		return nil, err
+	}
+	return appendExtras(out, s.extras)
+}
+
+// UnmarshalJSON decodes the modeled fields and keeps everything else as raw extras.
+func (r *PlanRetention) UnmarshalJSON(data []byte) error {
+	type retentionFields PlanRetention
+	var fields retentionFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	extras, err := unknownFields(data, retentionKnownKeys)
+	if err != nil {
+		return err
+	}
+	*r = PlanRetention(fields)
+	r.extras = extras
+	return nil
+}
+
+// MarshalJSON emits the modeled fields followed by any preserved extras.
+func (r PlanRetention) MarshalJSON() ([]byte, error) {
+	type retentionFields PlanRetention
+	out, err := json.Marshal(retentionFields(r))
+	if err != nil {
+		return nil, err
+	}
+	return appendExtras(out, r.extras)
+}
+
+// inheritOneof adds existing extras to a rendered object's own, dropping the
+// members of oneof when the object already sets one: Backrest rejects an
+// object with two schedule kinds or two retention policies.
+func inheritOneof(rendered any, own, existing map[string]json.RawMessage, oneof []string) map[string]json.RawMessage {
+	if len(existing) == 0 {
+		return own
+	}
+	data, err := json.Marshal(rendered)
+	if err != nil {
+		return own
+	}
+	var set map[string]json.RawMessage
+	if err := json.Unmarshal(data, &set); err != nil {
+		return own
+	}
+	inherited := cloneRaw(existing)
+	for _, key := range oneof {
+		if _, ok := set[key]; ok {
+			inherited = withoutKeys(inherited, oneof)
+			break
+		}
+	}
+	for key, value := range own {
+		inherited[key] = value
+	}
+	if len(inherited) == 0 {
+		return nil
+	}
+	return inherited
+}
+
+// withoutKeys deletes keys from m and returns it.
+func withoutKeys(m map[string]json.RawMessage, keys []string) map[string]json.RawMessage {
+	for _, key := range keys {
+		delete(m, key)
+	}
+	return m
+}
+
+func (h PlanHook) sameAction(other PlanHook) bool {
+	return h.ActionCommand == other.ActionCommand && slices.Equal(h.Conditions, other.Conditions)
+}
+
+// unknownFields returns the top-level keys of a JSON object that are not in known.
+func unknownFields(data []byte, known []string) (map[string]json.RawMessage, error) {
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for _, key := range known {
+		delete(raw, key)
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	return raw, nil
+}
+
+// appendExtras splices extras (sorted by key) into a marshaled JSON object.
+func appendExtras(obj []byte, extras map[string]json.RawMessage) ([]byte, error) {
+	if len(extras) == 0 {
+		return obj, nil
+	}
+	keys := make([]string, 0, len(extras))
+	for k := range extras {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	buf.Write(bytes.TrimSuffix(bytes.TrimSpace(obj), []byte("}")))
+	for i, k := range keys {
+		if i > 0 || len(obj) > 2 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(extras[k])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func cloneRaw(in map[string]json.RawMessage) map[string]json.RawMessage {
+	out := make(map[string]json.RawMessage, len(in))
+	for k, v := range in {
+		out[k] = append(json.RawMessage(nil), v...)
+	}
+	return out
+}
+
 // Repo represents a Backrest repo entry.
 type Repo struct {
 	ID   string   `json:"id"`
@@ -61,6 +399,8 @@ type Config struct {
 	Plans    []Plan `json:"plans"`
 	extras   map[string]json.RawMessage
 	rawRepos json.RawMessage
+	// loadedPlans fingerprints the plans as read from disk; nil until MarkLoaded.
+	loadedPlans map[string]string
 }
 
 // EnsureNonNil ensures slices/maps are initialized.
@@ -109,6 +449,50 @@ func (c *Config) ClearRawRepos() {
 	c.rawRepos = nil
 }
 
+// MarkLoaded records the current plans as the on-disk baseline that
+// PlansChanged compares against.
+func (c *Config) MarkLoaded() {
+	c.loadedPlans = make(map[string]string, len(c.Plans))
+	for i := range c.Plans {
+		c.loadedPlans[c.Plans[i].ID] = c.Plans[i].Fingerprint()
+	}
+}
+
+// PlansChanged reports whether the plans differ from the MarkLoaded baseline.
+// A config that was never marked counts as changed once it has plans.
+func (c *Config) PlansChanged() bool {
+	if c.loadedPlans == nil {
+		return len(c.Plans) > 0
+	}
+	if len(c.loadedPlans) != len(c.Plans) {
+		return true
+	}
+	for i := range c.Plans {
+		if fp, ok := c.loadedPlans[c.Plans[i].ID]; !ok || fp != c.Plans[i].Fingerprint() {
+			return true
+		}
+	}
+	return false
+}
+
+// Modno returns Backrest's config modification number (0 when absent).
+func (c *Config) Modno() int64 {
+	raw, ok := c.Extras()["modno"]
+	if !ok {
+		return 0
+	}
+	var n int64
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return 0
+	}
+	return n
+}
+
+// SetModno stores Backrest's config modification number.
+func (c *Config) SetModno(n int64) {
+	c.Extras()["modno"] = json.RawMessage(strconv.FormatInt(n, 10))
+}
+
 // RepoExists returns true if repo ID exists.
 func (c *Config) RepoExists(id string) bool {
 	for _, r := range c.Repos {
@@ -119,6 +503,39 @@ func (c *Config) RepoExists(id string) bool {
 	return false
 }
 
+// FindPlan returns the plan with the given ID, or nil.
+func (c *Config) FindPlan(id string) *Plan {
+	for i := range c.Plans {
+		if c.Plans[i].ID == id {
+			return &c.Plans[i]
+		}
+	}
+	return nil
+}
+
+// DisablePlan swaps the plan's schedule for a disabled one. It returns false if
+// the plan is missing or already disabled.
+func (c *Config) DisablePlan(id string) bool {
+	plan := c.FindPlan(id)
+	if plan == nil || plan.Schedule.Disabled {
+		return false
+	}
+	extras := withoutKeys(cloneRaw(plan.Schedule.extras), scheduleOneof)
+	plan.Schedule = PlanSchedule{Disabled: true, Clock: plan.Schedule.Clock, extras: extras}
+	return true
+}
+
+// RemovePlan deletes the plan with the given ID and reports whether it existed.
+func (c *Config) RemovePlan(id string) bool {
+	for i := range c.Plans {
+		if c.Plans[i].ID == id {
+			c.Plans = append(c.Plans[:i], c.Plans[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
 // UpsertPlans merges the provided plans by ID into the config and returns the IDs that changed.
 func (c *Config) UpsertPlans(plans []Plan) (bool, []string) {
 	if len(plans) == 0 {
@@ -129,22 +546,23 @@ func (c *Config) UpsertPlans(plans []Plan) (bool, []string) {
 		plans[idx].Normalize()
 	}
 
+	index := make(map[string]int, len(c.Plans))
+	for i := range c.Plans {
+		index[c.Plans[i].ID] = i
+	}
 	changedIDs := make([]string, 0, len(plans))
 	for _, plan := range plans {
-		found := false
-		for i := range c.Plans {
-			if c.Plans[i].ID == plan.ID {
-				found = true
-				if !plansEqual(c.Plans[i], plan) {
-					c.Plans[i] = plan
-					changedIDs = append(changedIDs, plan.ID)
-				}
-				break
-			}
-		}
+		i, found := index[plan.ID]
 		if !found {
+			index[plan.ID] = len(c.Plans)
 			c.Plans = append(c.Plans, plan)
 			changedIDs = append(changedIDs, plan.ID)
+			continue
+		}
+		plan.InheritExtras(c.Plans[i])
+		if !plansEqual(c.Plans[i], plan) {
+			c.Plans[i] = plan
+			changedIDs = append(changedIDs, plan.ID)
 		}
 	}
 
@@ -212,14 +630,16 @@ func uniqueStrings(in []string) []string {
 }
 
 func plansEqual(a, b Plan) bool {
-	ab, _ := json.Marshal(a)
-	bb, _ := json.Marshal(b)
-	return string(ab) == string(bb)
+	ab, _ := a.canonicalJSON()
+	bb, _ := b.canonicalJSON()
+	return bytes.Equal(ab, bb)
 }
 
 // Label helpers -------------------------------------------------------------
 
 const (
+	// LabelPrefix is shared by every label the sidecar reads.
+	LabelPrefix            = "backrest."
 	LabelEnable            = "backrest.enable"
 	LabelRepo              = "backrest.repo"
 	LabelSchedule          = "backrest.schedule"
@@ -230,6 +650,7 @@ const (
 	LabelHooksTemplate     = "backrest.hooks.template"
 	LabelRetentionKeep     = "backrest.keep"
 	LabelQuiesce           = "backrest.quiesce"
+	LabelGranularity       = "backrest.granularity"
 	LabelComposeProject    = "com.docker.compose.project"
 	LabelComposeService    = "com.docker.compose.service"
 )
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestUpsertPlansKeepsUnknownFieldsOnManagedPlans(t *testing.T) {
+	var cfg Config
+	seed := `{"id":"managed","repo":"repo","paths":["/data"],"schedule":{"cron":"0 2 * * *","clock":"CLOCK_LOCAL"},"retention":{},"backup_flags":["--exclude-caches"],"hooks":[{"conditions":["CONDITION_SNAPSHOT_START"],"onError":"ON_ERROR_CANCEL","actionCommand":{"command":"docker stop app"}}]}`
+	var existing Plan
+	if err := json.Unmarshal([]byte(seed), &existing); err != nil {
+		t.Fatalf("unmarshal plan: %v", err)
+	}
+	cfg.Plans = []Plan{existing}
+
+	rendered := Plan{
+		ID:       "managed",
+		Repo:     "repo",
+		Paths:    []string{"/data"},
+		Schedule: PlanSchedule{Cron: "0 2 * * *", Clock: "CLOCK_LOCAL"},
+		Hooks: []PlanHook{{
+			Conditions:    []string{"CONDITION_SNAPSHOT_START"},
+			ActionCommand: HookCommand{Command: "docker stop app"},
+		}},
+	}
+	if changed, ids := cfg.UpsertPlans([]Plan{rendered}); changed {
+		t.Fatalf("expected identical render with extras to be a no-op, changed %v", ids)
+	}
+
+	rendered.Paths = []string{"/data", "/more"}
+	if changed, _ := cfg.UpsertPlans([]Plan{rendered}); !changed {
+		t.Fatalf("expected path change to be detected")
+	}
+	out, err := json.Marshal(cfg.Plans[0])
+	if err != nil {
+		t.Fatalf("marshal plan: %v", err)
+	}
+	for _, want := range []string{`"backup_flags":["--exclude-caches"]`, `"onError":"ON_ERROR_CANCEL"`, `"/more"`} {
+		if !strings.Contains(string(out), want) {
+			t.Fatalf("expected %s in %s", want, out)
+		}
+	}
+}
+
+func TestDiffPlansReportsChangedFieldPaths(t *testing.T) {
+	base := Plan{
+		ID:       "p",
+		Repo:     "repo",
+		Paths:    []string{"/a", "/b"},
+		Schedule: PlanSchedule{Cron: "0 2 * * *", Clock: "CLOCK_LOCAL"},
+	}
+	same := base
+	same.Paths = []string{"/b", "/a"}
+	if diff := DiffPlans(base, same); len(diff) != 0 {
+		t.Fatalf("expected reordered paths to be equal, got %v", diff)
+	}
+
+	edited := base
+	edited.Paths = []string{"/a"}
+	edited.Schedule.Cron = "0 4 * * *"
+	edited.SetExtras(map[string]json.RawMessage{"backup_flags": json.RawMessage(`["--one-file-system"]`)})
+	got := strings.Join(DiffPlans(base, edited), ",")
+	if got != "backup_flags,paths,schedule.cron" {
+		t.Fatalf("unexpected diff %q", got)
+	}
+}
+
+func TestUpsertPlansKeepsBackrestScheduleAndRetentionFields(t *testing.T) {
+	var cfg Config
+	seed := `{"id":"managed","repo":"repo","paths":["/data"],"schedule":{"maxFrequencyDays":1,"clock":"CLOCK_LOCAL"},"retention":{"policyKeepLastN":30}}`
+	var existing Plan
+	if err := json.Unmarshal([]byte(seed), &existing); err != nil {
+		t.Fatalf("unmarshal plan: %v", err)
+	}
+	cfg.Plans = []Plan{existing}
+
+	rendered := Plan{
+		ID:       "managed",
+		Repo:     "repo",
+		Paths:    []string{"/data", "/more"},
+		Schedule: PlanSchedule{Clock: "CLOCK_LOCAL"},
+	}
+	if changed, _ := cfg.UpsertPlans([]Plan{rendered.Clone()}); !changed {
+		t.Fatalf("expected path change to be detected")
+	}
+	out, err := json.Marshal(cfg.Plans[0])
+	if err != nil {
+		t.Fatalf("marshal plan: %v", err)
+	}
+	for _, want := range []string{`"maxFrequencyDays":1`, `"retention":{"policyKeepLastN":30}`} {
+		if !strings.Contains(string(out), want) {
+			t.Fatalf("expected %s in %s", want, out)
+		}
+	}
+
+	// a rendered cron or bucketed policy replaces Backrest's oneof member
+	rendered.Schedule.Cron = "0 2 * * *"
+	rendered.Retention.PolicyTimeBucketed = &RetentionBuckets{Daily: 7}
+	cfg.UpsertPlans([]Plan{rendered.Clone()})
+	out, _ = json.Marshal(cfg.Plans[0])
+	if strings.Contains(string(out), "maxFrequencyDays") || strings.Contains(string(out), "policyKeepLastN") {
+		t.Fatalf("expected rendered policies to replace Backrest's, got %s", out)
+	}
+
+	cfg.Plans[0].Retention = PlanRetention{}
+	if err := json.Unmarshal([]byte(`{"policyKeepAll":true}`), &cfg.Plans[0].Retention); err != nil {
+		t.Fatalf("unmarshal retention: %v", err)
+	}
+	cfg.DisablePlan("managed")
+	out, _ = json.Marshal(cfg.Plans[0])
+	if !strings.Contains(string(out), `"schedule":{"disabled":true,"clock":"CLOCK_LOCAL"}`) || !strings.Contains(string(out), `"policyKeepAll":true`) {
+		t.Fatalf("expected a disabled plan to keep its retention, got %s", out)
+	}
+}
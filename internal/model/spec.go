@@ -0,0 +1,161 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LabelSpec carries a JSON Spec, the structured alternative to the flat
+// labels. A compose service's x-backrest block is read into the same label.
+const LabelSpec = "backrest.spec"
+
+// Spec is the structured form of the backrest.* labels. Lists are real
+// lists, so a hook command or path may contain commas. A flat label, when
+// set, takes precedence over the matching Spec field.
+type Spec struct {
+	Repo     string        `json:"repo,omitempty"`
+	Schedule string        `json:"schedule,omitempty"`
+	Keep     RetentionSpec `json:"keep,omitempty"`
+	Paths    SpecPaths     `json:"paths"`
+	Hooks    SpecHooks     `json:"hooks"`
+	// Granularity mirrors backrest.granularity.
+	Granularity string `json:"granularity,omitempty"`
+}
+
+// SpecPaths mirrors backrest.paths.include and backrest.paths.exclude.
+type SpecPaths struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// SpecHooks mirrors backrest.snapshot-start, backrest.snapshot-end and
+// backrest.hooks.template.
+type SpecHooks struct {
+	SnapshotStart []string `json:"snapshot-start,omitempty"`
+	SnapshotEnd   []string `json:"snapshot-end,omitempty"`
+	Template      string   `json:"template,omitempty"`
+}
+
+// RetentionSpec is a backrest.keep spec. In JSON it is either the label's
+// "daily=7,weekly=4" string or an object such as {"daily": 7, "weekly": 4}.
+type RetentionSpec string
+
+// UnmarshalJSON accepts the string or the object form.
+func (r *RetentionSpec) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*r = RetentionSpec(s)
+		return nil
+	}
+	var obj map[string]any
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("keep must be a string or an object")
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		value := fmt.Sprint(obj[k])
+		if n, ok := obj[k].(float64); ok {
+			// %v would turn 1000000 into 1e+06
+			value = strconv.FormatFloat(n, 'f', -1, 64)
+		}
+		parts = append(parts, k+"="+value)
+	}
+	*r = RetentionSpec(strings.Join(parts, ","))
+	return nil
+}
+
+// ParseSpec reads the backrest.spec label, or returns nil when it is unset.
+// Unknown fields are errors so typos do not pass silently.
+func ParseSpec(labels map[string]string) (*Spec, error) {
+	raw := strings.TrimSpace(labels[LabelSpec])
+	if raw == "" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	dec.DisallowUnknownFields()
+	var spec Spec
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("%s: %w", LabelSpec, err)
+	}
+	return &spec, nil
+}
+
+// Setting resolves a scalar label key: the flat label when set, else the
+// matching Spec field. source is the label key or "backrest.spec <field>",
+// or "" when neither is set. s may be nil.
+func (s *Spec) Setting(labels map[string]string, key string) (value, source string) {
+	if v := strings.TrimSpace(labels[key]); v != "" {
+		return v, "label " + key
+	}
+	if s == nil {
+		return "", ""
+	}
+	var field string
+	switch key {
+	case LabelRepo:
+		value, field = s.Repo, "repo"
+	case LabelSchedule:
+		value, field = s.Schedule, "schedule"
+	case LabelRetentionKeep:
+		value, field = string(s.Keep), "keep"
+	case LabelHooksTemplate:
+		value, field = s.Hooks.Template, "hooks.template"
+	case LabelGranularity:
+		value, field = s.Granularity, "granularity"
+	}
+	if value = strings.TrimSpace(value); value == "" {
+		return "", ""
+	}
+	return value, LabelSpec + " " + field
+}
+
+// List resolves a list label key: the flat label split as CSV when set,
+// else the matching Spec list. s may be nil.
+func (s *Spec) List(labels map[string]string, key string) (values []string, source string) {
+	if values = ParseCSV(labels[key]); len(values) > 0 {
+		return values, "label " + key
+	}
+	if s == nil {
+		return nil, ""
+	}
+	var field string
+	switch key {
+	case LabelPathsInclude:
+		values, field = s.Paths.Include, "paths.include"
+	case LabelPathsExclude:
+		values, field = s.Paths.Exclude, "paths.exclude"
+	case LabelHookSnapshotStart:
+		values, field = s.Hooks.SnapshotStart, "hooks.snapshot-start"
+	case LabelHookSnapshotEnd:
+		values, field = s.Hooks.SnapshotEnd, "hooks.snapshot-end"
+	}
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if t := strings.TrimSpace(v); t != "" {
+			out = append(out, t)
+		}
+	}
+	if len(out) == 0 {
+		return nil, ""
+	}
+	return out, LabelSpec + " " + field
+}
+
+// Sets reports whether the spec itself sets the field behind label key.
+// s may be nil.
+func (s *Spec) Sets(key string) bool {
+	if value, _ := s.Setting(nil, key); value != "" {
+		return true
+	}
+	values, _ := s.List(nil, key)
+	return len(values) > 0
+}
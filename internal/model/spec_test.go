@@ -0,0 +1,32 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRetentionSpecAcceptsStringsAndObjects(t *testing.T) {
+	cases := []struct {
+		name string
+		raw  string
+		want RetentionSpec
+	}{
+		{name: "label string", raw: `"daily=7,weekly=4"`, want: "daily=7,weekly=4"},
+		{name: "object", raw: `{"weekly": 4, "daily": 7}`, want: "daily=7,weekly=4"},
+		{name: "large counts", raw: `{"hourly": 1000000, "daily": 1e3}`, want: "daily=1000,hourly=1000000"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got RetentionSpec
+			if err := json.Unmarshal([]byte(tc.raw), &got); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+			if err := ValidateRetentionSpec(string(got)); err != nil {
+				t.Fatalf("expected a valid spec: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// KnownLabels lists every backrest.* label key the sidecar reads.
+var KnownLabels = []string{
+	LabelEnable,
+	LabelRepo,
+	LabelSchedule,
+	LabelPathsInclude,
+	LabelPathsExclude,
+	LabelHookSnapshotStart,
+	LabelHookSnapshotEnd,
+	LabelHooksTemplate,
+	LabelRetentionKeep,
+	LabelQuiesce,
+	LabelSpec,
+	LabelGranularity,
+}
+
+// IsKnownLabel reports whether key is a label the sidecar reads.
+func IsKnownLabel(key string) bool {
+	for _, known := range KnownLabels {
+		if key == known {
+			return true
+		}
+	}
+	return false
+}
+
+// resticDuration matches restic's --keep-within values such as "2y5m7d".
+var resticDuration = regexp.MustCompile(`^([0-9]+[ymwdh])+$`)
+
+// ValidateRetentionSpec checks a backrest.keep spec strictly. RetentionFromSpec
+// and backup-once skip parts they cannot use; this reports them instead.
+func ValidateRetentionSpec(spec string) error {
+	var errs []error
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			errs = append(errs, errors.New("empty entry"))
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			errs = append(errs, fmt.Errorf("%q is not key=value", part))
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		val := strings.TrimSpace(kv[1])
+		switch key {
+		case "last", "hourly", "daily", "weekly", "monthly", "yearly":
+			if n, err := strconv.Atoi(val); err != nil || n < 0 {
+				errs = append(errs, fmt.Errorf("%s=%q is not a count", key, val))
+			}
+		case "within", "within-d", "within-w", "within-m", "within-y":
+			if !resticDuration.MatchString(val) {
+				errs = append(errs, fmt.Errorf("%s=%q is not a duration like 30d or 1y6m", key, val))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unknown retention key %q", key))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// backupOnceRetentionKeys are the backrest.keep keys only backup-once passes
+// on to restic; Backrest plans have no equivalent in policyTimeBucketed.
+var backupOnceRetentionKeys = []string{"last", "within", "within-d", "within-w", "within-m", "within-y"}
+
+// BackupOnceOnlyRetentionKeys lists the keys of a backrest.keep spec that
+// RetentionFromSpec drops for Backrest plans, in spec order.
+func BackupOnceOnlyRetentionKeys(spec string) []string {
+	var keys []string
+	for _, part := range strings.Split(spec, ",") {
+		key, _, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		for _, only := range backupOnceRetentionKeys {
+			if key == only {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// cronField is one field of a five-field cron expression.
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // optional names, indexed from min
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+var cronDescriptors = map[string]bool{
+	"@yearly": true, "@annually": true, "@monthly": true, "@weekly": true,
+	"@daily": true, "@midnight": true, "@hourly": true,
+}
+
+// ValidateCron checks a backrest.schedule value: five cron fields (a "T"
+// minute is randomized per plan) or a descriptor such as @daily.
+func ValidateCron(expr string) error {
+	expr = strings.TrimSpace(expr)
+	if cronDescriptors[strings.ToLower(expr)] {
+		return nil
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return fmt.Errorf("want %d fields, got %d", len(cronFields), len(fields))
+	}
+	for i, raw := range fields {
+		if i == 0 && strings.EqualFold(raw, "T") {
+			continue
+		}
+		if err := cronFields[i].validate(raw); err != nil {
+			return fmt.Errorf("%s %q: %w", cronFields[i].name, raw, err)
+		}
+	}
+	return nil
+}
+
+func (f cronField) validate(raw string) error {
+	for _, item := range strings.Split(raw, ",") {
+		rangePart, step, hasStep := strings.Cut(item, "/")
+		if hasStep {
+			if n, err := strconv.Atoi(step); err != nil || n <= 0 {
+				return fmt.Errorf("bad step %q", step)
+			}
+		}
+		if rangePart == "*" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(rangePart, "-")
+		start, err := f.value(lo)
+		if err != nil {
+			return err
+		}
+		if !isRange {
+			continue
+		}
+		end, err := f.value(hi)
+		if err != nil {
+			return err
+		}
+		if end < start {
+			return fmt.Errorf("range %s is backwards", rangePart)
+		}
+	}
+	return nil
+}
+
+func (f cronField) value(raw string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(raw, name) {
+			return f.min + i, nil
+		}
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", raw)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("%d is outside %d-%d", n, f.min, f.max)
+	}
+	return n, nil
+}
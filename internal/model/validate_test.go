@@ -0,0 +1,34 @@
+package model
+
+import "testing"
+
+func TestValidateRetentionSpecAndCron(t *testing.T) {
+	for spec, ok := range map[string]bool{
+		"daily=7,weekly=4":   true,
+		"last=3,within=1y6m": true,
+		"dayly=7":            false,
+		"daily=seven":        false,
+		"daily=7,,weekly=4":  false,
+		"within=forever":     false,
+		"daily":              false,
+	} {
+		if err := ValidateRetentionSpec(spec); (err == nil) != ok {
+			t.Fatalf("ValidateRetentionSpec(%q) = %v, want ok=%v", spec, err, ok)
+		}
+	}
+	for expr, ok := range map[string]bool{
+		"0 2 * * *":           true,
+		"T 3 * * mon-fri":     true,
+		"*/15 0-6,22 1 JAN *": true,
+		"@daily":              true,
+		"0 2 * *":             false,
+		"60 2 * * *":          false,
+		"0 2 * * 8":           false,
+		"0 5-2 * * *":         false,
+		"*/0 * * * *":         false,
+	} {
+		if err := ValidateCron(expr); (err == nil) != ok {
+			t.Fatalf("ValidateCron(%q) = %v, want ok=%v", expr, err, ok)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	fsutil "github.com/zettaio/backrest-sidecar/internal/util/fs"
+)
+
+// State is the sidecar's bookkeeping that must survive between reconcile runs.
+// It lives next to the Backrest config but is never read by Backrest itself.
+type State struct {
+	// Orphans maps sidecar-owned plan IDs to the time their container was first seen missing.
+	Orphans map[string]time.Time `json:"orphans,omitempty"`
+	// Quarantined maps plan IDs to the fingerprint of a render that left Backrest
+	// unhealthy; that exact render is not written again.
+	Quarantined map[string]string `json:"quarantined,omitempty"`
+	// Rendered holds each sidecar-owned plan as the sidecar last left it in the
+	// config, so edits made elsewhere (e.g. the Backrest UI) can be told apart
+	// from label changes.
+	Rendered map[string]json.RawMessage `json:"rendered,omitempty"`
+}
+
+// DefaultPath derives the state file location from the Backrest config path.
+func DefaultPath(configPath string) string {
+	if configPath == "" {
+		return ""
+	}
+	return configPath + ".sidecar-state.json"
+}
+
+// EnsureNonNil ensures maps are initialized.
+func (s *State) EnsureNonNil() {
+	if s.Orphans == nil {
+		s.Orphans = make(map[string]time.Time)
+	}
+	if s.Quarantined == nil {
+		s.Quarantined = make(map[string]string)
+	}
+	if s.Rendered == nil {
+		s.Rendered = make(map[string]json.RawMessage)
+	}
+}
+
+// Load reads the state file, returning an empty state if it does not exist.
+func Load(path string) (*State, error) {
+	st := &State{}
+	if path == "" {
+		st.EnsureNonNil()
+		return st, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			st.EnsureNonNil()
+			return st, nil
+		}
+		return nil, fmt.Errorf("read state: %w", err)
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("parse state: %w", err)
+	}
+	st.EnsureNonNil()
+	return st, nil
+}
+
+// Write persists the state atomically.
+func Write(path string, st *State) error {
+	if path == "" {
+		return nil
+	}
+	st.EnsureNonNil()
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal state: %w", err)
+	}
+	return fsutil.AtomicWrite(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,155 @@
+// Package textdiff renders line diffs in unified format.
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Unified returns a unified diff of a and b (split into lines) with the
+// given lines of context, or "" when they are equal. The inputs are plan- or
+// config-sized, so a plain LCS table is fast enough.
+func Unified(aName, bName, a, b string, context int) string {
+	if a == b {
+		return ""
+	}
+	al, bl := splitLines(a), splitLines(b)
+	ops := diffLines(al, bl)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+	for _, h := range hunks(ops, context) {
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", span(h.aStart, h.aLen), span(h.bStart, h.bLen))
+		for _, op := range h.ops {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+	}
+	return out.String()
+}
+
+type op struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines returns the edit script turning a into b.
+func diffLines(a, b []string) []op {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	ops := make([]op, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, op{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, op{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, op{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, op{'+', b[j]})
+	}
+	return ops
+}
+
+type hunk struct {
+	aStart, aLen int
+	bStart, bLen int
+	ops          []op
+}
+
+// hunks groups changes with up to context unchanged lines around them.
+func hunks(ops []op, context int) []hunk {
+	var out []hunk
+	aLine, bLine := make([]int, len(ops)), make([]int, len(ops))
+	a, b := 1, 1
+	for i, o := range ops {
+		aLine[i], bLine[i] = a, b
+		if o.kind != '+' {
+			a++
+		}
+		if o.kind != '-' {
+			b++
+		}
+	}
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := max(i-context, 0)
+		end := i
+		// extend while the next change is within 2*context unchanged lines
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*context {
+				end = min(end+context, len(ops))
+				break
+			}
+			end = next
+		}
+		h := hunk{aStart: aLine[start], bStart: bLine[start], ops: ops[start:end]}
+		for _, o := range h.ops {
+			if o.kind != '+' {
+				h.aLen++
+			}
+			if o.kind != '-' {
+				h.bLen++
+			}
+		}
+		// an empty side starts one line earlier by convention
+		if h.aLen == 0 {
+			h.aStart--
+		}
+		if h.bLen == 0 {
+			h.bStart--
+		}
+		out = append(out, h)
+		i = end
+	}
+	return out
+}
+
+func span(start, n int) string {
+	if n == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, n)
+}
@@ -0,0 +1,28 @@
+package textdiff
+
+import "testing"
+
+func TestUnifiedGroupsChangesIntoHunks(t *testing.T) {
+	a := "a\nb\nc\nd\ne\nf\ng\nh\n"
+	b := "a\nB\nc\nd\ne\nf\ng\nh\ni\n"
+	want := `--- old
++++ new
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+@@ -8 +8,2 @@
+ h
++i
+`
+	if got := Unified("old", "new", a, b, 1); got != want {
+		t.Fatalf("unexpected diff:\n%s\nwant:\n%s", got, want)
+	}
+	if got := Unified("old", "new", a, a, 3); got != "" {
+		t.Fatalf("expected no diff for equal input, got %q", got)
+	}
+	if got := Unified("old", "new", "", "x\n", 3); got != "--- old\n+++ new\n@@ -0,0 +1 @@\n+x\n" {
+		t.Fatalf("unexpected diff against empty input:\n%s", got)
+	}
+}